@@ -3,25 +3,51 @@ package main
 // cSpell:words certgraph crtsh
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/lanrat/certgraph/dns"
 	"github.com/lanrat/certgraph/driver"
-	"github.com/lanrat/certgraph/driver/censys"
-	"github.com/lanrat/certgraph/driver/crtsh"
+	_ "github.com/lanrat/certgraph/driver/censys"      // register the censys driver
+	_ "github.com/lanrat/certgraph/driver/certspotter" // register the certspotter driver
+	_ "github.com/lanrat/certgraph/driver/certstream"  // register the certstream driver
+	_ "github.com/lanrat/certgraph/driver/crtsh"       // register the crtsh driver
+	"github.com/lanrat/certgraph/driver/csvimport"
+	"github.com/lanrat/certgraph/driver/filter"
 	"github.com/lanrat/certgraph/driver/http"
+	"github.com/lanrat/certgraph/driver/limit"
 	"github.com/lanrat/certgraph/driver/multi"
-	"github.com/lanrat/certgraph/driver/smtp"
+	_ "github.com/lanrat/certgraph/driver/smtp"     // register the smtp driver
+	_ "github.com/lanrat/certgraph/driver/starttls" // register the imap and pop3 drivers
+	"github.com/lanrat/certgraph/driver/timing"
+	"github.com/lanrat/certgraph/fingerprint"
 	"github.com/lanrat/certgraph/graph"
+	"github.com/lanrat/certgraph/msgpack"
+	"github.com/lanrat/certgraph/parquet"
+	"github.com/lanrat/certgraph/status"
 	"github.com/lanrat/certgraph/web"
 )
 
@@ -35,7 +61,11 @@ var (
 // temp flag vars
 var (
 	timeoutSeconds uint
-	regexString    string
+	srvString      string
+	portsString    string
+	scopeString    string
+	asOfString     string
+	issuedString   string
 )
 
 // webContent holds our static web server content.
@@ -45,48 +75,239 @@ var webContent embed.FS
 
 var certDriver driver.Driver
 
+// domainsWithCerts counts the domains the driver returned certificates for
+// used to warn when the driver returns no certificates for a domain, which may be a silent failure
+var domainsWithCerts int64
+
+// timingDrivers holds the drivers to print latency stats for with -stats
+var timingDrivers []*timing.Driver
+
+// certThreadPass limits the number of certificates queried in parallel across all domains
+var certThreadPass chan bool
+
+// sniAddresses holds the IP address the http driver connects to for each hostname from -sni-list
+var sniAddresses map[string]string
+
+// seedPorts holds the ports the http, smtp, imap, and pop3 drivers connect to for each start domain passed as host:port
+var seedPorts = make(map[string][]string)
+
+// failures holds the domains that could not be queried for -failures-out
+var failures = failureList{categories: make(map[string]string), drivers: make(map[string]int)}
+
+// seedApexes holds the apex domains of the domains the crawl started from
+var seedApexes = seedApexSet{apexes: make(map[string]bool)}
+
+// seedApexSet is a set of apex domains safe for concurrent use, scans posted to the web server add to it
+type seedApexSet struct {
+	sync.RWMutex
+	apexes map[string]bool
+}
+
+// add adds the apex domain of domain to the set
+func (s *seedApexSet) add(domain string) {
+	s.Lock()
+	defer s.Unlock()
+	s.apexes[dns.ApexDomainFallback(domain)] = true
+}
+
+// contains returns true if the apex domain of domain is in the set
+func (s *seedApexSet) contains(domain string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.apexes[dns.ApexDomainFallback(domain)]
+}
+
+// domainScore scores domains for -priority, domains with higher scores are visited first within each depth
+var domainScore = defaultDomainScore
+
 // config & flags
 // TODO move driver options to own struct
 var config struct {
 	timeout             time.Duration
 	verbose             bool
 	maxDepth            uint
+	maxDepthCT          int
+	maxDepthHTTP        int
+	depthDelay          time.Duration
+	stallTimeout        time.Duration
+	checkpoint          string
+	domainsFile         string
+	noRelatedExpansion  bool
+	maxCerts            uint
+	maxDomains          uint
+	outputIDN           string
+	maxSANsPrint        int
 	parallel            uint
+	certParallel        uint
+	priority            bool
+	deterministic       bool
 	savePath            string
+	saveManifest        bool
 	details             bool
+	counts              bool
+	onlyValid           bool
+	noSeeds             bool
 	printJSON           bool
+	printSTIX           bool
+	printEdgeList       bool
+	printGraphML        bool
+	printDOT            bool
+	tldSummary          bool
+	depthHistogram      bool
+	scc                 bool
+	hash                bool
+	parquetPath         string
+	splitComponents     string
+	csvEdges            string
+	csvNodes            string
+	msgpackPath         string
+	splitSingletons     bool
+	dumpQueries         bool
+	linkHints           bool
+	respectRobots       bool
+	failuresOut         string
+	jsonCompact         bool
+	jsonStream          bool
+	mergeEdges          bool
 	driver              string
+	importCSV           string
+	sniList             string
+	importCrawl         bool
+	compareDrivers      string
+	timeline            string
 	includeCTSubdomains bool
 	includeCTExpired    bool
+	ctNoCN              bool
+	maxCertSANs         int
+	ctParallel          uint
+	rate                float64
+	asOf                time.Time
+	issuedSince         time.Time
 	cdn                 bool
+	orgNodes            bool
+	linkAKID            bool
+	chain               bool
+	reportDupSANs       bool
+	strictHostnames     bool
 	maxSANsSize         int
+	keyAlgo             string
+	recentCerts         uint
 	apex                bool
 	updatePSL           bool
+	pslMaxAge           time.Duration
 	checkDNS            bool
+	mx                  bool
+	resolveIPs          bool
+	dnsCacheTTL         time.Duration
+	dnsRetries          uint
 	printVersion        bool
+	printVersionJSON    bool
+	printStats          bool
 	serve               string
-	regex               *regexp.Regexp
+	serveGraph          string
+	serveScan           bool
+	regex               regexList
+	issuer              regexList
+	excludeIssuer       regexList
+	glob                globList
+	srvServices         []string
+	ports               []string
+	scope               apexScope
+	headers             headerList
 }
 
 func init() {
 	flag.BoolVar(&config.printVersion, "version", false, "print version and exit")
+	flag.BoolVar(&config.printVersionJSON, "version-json", false, "print version, go version, and drivers as json and exit")
 	flag.UintVar(&timeoutSeconds, "timeout", 10, "tcp timeout in seconds")
 	flag.BoolVar(&config.verbose, "verbose", false, "verbose logging")
+	flag.BoolVar(&config.printStats, "stats", false, "print latency percentiles for each driver's queries when done")
 	flag.StringVar(&config.driver, "driver", "http", fmt.Sprintf("driver(s) to use [%s]", strings.Join(driver.Drivers, ", ")))
+	flag.StringVar(&config.compareDrivers, "compare-drivers", "", "instead of crawling, print the certificates found for each HOST by only one of two comma separated drivers, ex: crtsh,censys")
+	flag.StringVar(&config.timeline, "timeline", "", "instead of crawling, print every certificate the certificate transparency -driver finds for the domain, including expired certificates, in order of issuance with their validity, issuer, and number of SANs, uses crtsh unless -driver is set")
+	flag.StringVar(&config.importCSV, "import-csv", "", "graph the certificates in a CSV file of fingerprint,domain rows instead of using -driver, crawls every imported domain if no HOST is given")
+	flag.BoolVar(&config.importCrawl, "import-crawl", false, "continue crawling the domains imported with -import-csv using -driver")
 	flag.BoolVar(&config.includeCTSubdomains, "ct-subdomains", false, "include sub-domains in certificate transparency search")
-	flag.BoolVar(&config.includeCTExpired, "ct-expired", false, "include expired certificates in certificate transparency search")
+	flag.BoolVar(&config.includeCTExpired, "ct-expired", false, "include expired certificates in certificate transparency search and served to the http, smtp, imap, and pop3 drivers, hosts serving expired certificates are always marked expired")
+	flag.IntVar(&config.maxCertSANs, "max-cert-sans", 0, "maximum number of domains to fetch for each certificate from crtsh, 0 has no limit")
+	flag.BoolVar(&config.ctNoCN, "ct-no-cn", false, "only search and include dNSName SANs in certificate transparency results, ignoring the CommonName")
+	flag.UintVar(&config.ctParallel, "ct-parallel", 0, fmt.Sprintf("maximum concurrent queries to each certificate transparency driver regardless of -parallel, 0 uses the driver's default to avoid rate limits (%s)", strings.Join(defaultParallels(), ", ")))
+	flag.Float64Var(&config.rate, "rate", 0, "maximum number of queries per second to each driver, shared by every thread, 0 has no limit")
+	flag.StringVar(&asOfString, "as-of", "", "only include certificates valid on this date (YYYY-MM-DD) in certificate transparency search, includes expired certificates")
+	flag.StringVar(&issuedString, "issued-since", "", "only include certificates issued after this date (YYYY-MM-DD) or within this duration (ex: 7d, 12h) in certificate transparency search")
 	flag.IntVar(&config.maxSANsSize, "sanscap", 80, "maximum number of uniq apex domains in certificate to include, 0 has no limit")
+	flag.UintVar(&config.recentCerts, "recent-certs", 0, "only process the n most recently issued certificates for each domain, 0 has no limit")
+	flag.StringVar(&config.keyAlgo, "key-algo", "", "only include certificates with this public key algorithm and optional size, ex: RSA, ECDSA-256, RSA-1024")
 	flag.BoolVar(&config.cdn, "cdn", false, "include certificates from CDNs")
+	flag.BoolVar(&config.orgNodes, "org-nodes", false, "add certificate subject organizations as nodes in the json graph")
+	flag.BoolVar(&config.linkHints, "link-hints", false, "add the hosts of preconnect and dns-prefetch links in Link headers and the HTML head as related domains, http driver only")
+	flag.BoolVar(&config.respectRobots, "respect-robots", false, "only request hosts whose robots.txt allows it and wait for its Crawl-delay, disallowed hosts are only connected to for their certificate, http driver only")
+	flag.BoolVar(&config.chain, "chain", false, "add the intermediate certificates sent by servers to the graph linked to the certificates they issued, http driver only")
+	flag.BoolVar(&config.linkAKID, "link-akid", false, "link certificates to their issuer by authority key id in the json graph, issuers not in the graph are added as nodes")
+	flag.BoolVar(&config.reportDupSANs, "report-dup-sans", false, "add the number of repeated or wildcard redundant SANs in each certificate to the json graph, live drivers only")
+	flag.BoolVar(&config.strictHostnames, "strict-hostnames", false, "drop certificate domains that exceed the DNS label or name length limits")
 	flag.BoolVar(&config.checkDNS, "dns", false, "check for DNS records to determine if domain is registered")
+	flag.DurationVar(&config.dnsCacheTTL, "dns-cache-ttl", dns.DefaultCacheTTL, "how long to cache DNS results for, 0 disables caching")
+	flag.UintVar(&config.dnsRetries, "dns-retries", dns.DefaultRetries, "number of times to retry DNS lookups that fail with SERVFAIL or a timeout, domains that do not exist are not retried")
+	flag.BoolVar(&config.resolveIPs, "resolve-ips", false, "lookup the A and AAAA records of every domain and add them to the json graph")
+	flag.BoolVar(&config.mx, "mx", false, "lookup MX records for every domain and add the mail servers as related domains")
+	flag.StringVar(&srvString, "srv", "", "comma separated SRV services to lookup related domains in, ex: _xmpp-server._tcp,_sip._tls")
 	flag.BoolVar(&config.apex, "apex", false, "for every domain found, add the apex domain of the domain's parent")
 	flag.BoolVar(&config.updatePSL, "updatepsl", false, "Update the default Public Suffix List")
+	flag.DurationVar(&config.pslMaxAge, "psl-max-age", dns.DefaultPublicSuffixListMaxAge, "maximum age of the cached Public Suffix List before -updatepsl downloads it again")
 	flag.UintVar(&config.maxDepth, "depth", 5, "maximum BFS depth to go")
+	flag.IntVar(&config.maxDepthCT, "depth-ct", -1, "maximum BFS depth for domains found by certificate transparency drivers, -1 uses -depth")
+	flag.IntVar(&config.maxDepthHTTP, "depth-http", -1, "maximum BFS depth for domains found by the live http, smtp, imap, and pop3 drivers, -1 uses -depth")
+	flag.DurationVar(&config.depthDelay, "depth-delay", 0, "time to wait before crawling each new BFS depth")
+	flag.UintVar(&config.maxCerts, "max-certs", 0, "stop crawling and output partial results once this many certificates are found, 0 has no limit")
+	flag.UintVar(&config.maxDomains, "max-domains", 0, "stop adding new domains to the crawl once the graph has this many domains, the domains already added are still visited, 0 has no limit")
+	flag.BoolVar(&config.noRelatedExpansion, "no-related-expansion", false, "only crawl the domains on certificates, related domains found by redirects, DNS, and link hints are recorded but not crawled")
+	flag.StringVar(&config.domainsFile, "domains-file", "", "file of domains to start the search from, one per line, in addition to any HOST arguments, - reads from stdin")
+	flag.StringVar(&config.checkpoint, "checkpoint", "", "periodically save the graph and queued domains to file and resume the crawl from it if it exists, start domains are added to the resumed crawl")
+	flag.DurationVar(&config.stallTimeout, "stall-timeout", 0, "stop crawling and output partial results if no domain is visited for this long, 0 disables")
 	flag.UintVar(&config.parallel, "parallel", 10, "number of certificates to retrieve in parallel")
+	flag.UintVar(&config.certParallel, "cert-parallel", 0, "number of certificate details to query in parallel, 0 uses -parallel")
+	flag.BoolVar(&config.priority, "priority", false, "visit the most relevant domains at each depth first instead of in discovery order")
+	flag.BoolVar(&config.deterministic, "deterministic", false, "crawl and output domains in a stable order so identical crawls produce identical graphs, slower")
+	flag.IntVar(&config.maxSANsPrint, "max-sans-print", 0, "maximum number of domains printed for each certificate in text output, the json output always has every domain, 0 has no limit")
+	flag.StringVar(&config.outputIDN, "output-idn", "", "convert internationalized domains when output, including by -details, to unicode or ascii (punycode), default is as stored, crawled domains are stored as ascii")
 	flag.BoolVar(&config.details, "details", false, "print details about the domains crawled")
+	flag.BoolVar(&config.dumpQueries, "dump-queries", false, "log the queries sent by the certificate transparency drivers for each domain, credentials are not logged")
+	flag.BoolVar(&config.counts, "counts", false, "print the number of certificates found for each domain after the domain")
+	flag.BoolVar(&config.onlyValid, "only-valid", false, "only print domains that have a non-expired certificate")
+	flag.BoolVar(&config.noSeeds, "no-seeds", false, "do not print the domains provided to start the search, only the domains discovered from them")
 	flag.BoolVar(&config.printJSON, "json", false, "print the graph as json, can be used for graph in web UI")
+	flag.BoolVar(&config.mergeEdges, "merge-edges", false, "combine -json links with the same source and target into one link with each link's type and a weight")
+	flag.BoolVar(&config.jsonStream, "json-stream", false, "print each domain as a line of json as soon as it is visited")
+	flag.BoolVar(&config.jsonCompact, "json-compact", false, "print -json and -stix output without indentation")
+	flag.BoolVar(&config.printEdgeList, "edgelist", false, "print the graph's edges as tab separated source, target, and type lines as they are found")
+	flag.BoolVar(&config.depthHistogram, "depth-histogram", false, "print the number of domains found at each depth when done")
+	flag.BoolVar(&config.tldSummary, "tld-summary", false, "print the number of domains found in each TLD when done")
+	flag.BoolVar(&config.hash, "hash", false, "print a SHA-256 hash of the graph's nodes and links when done, it only changes when the graph found changes")
+	flag.BoolVar(&config.scc, "scc", false, "print the groups of domains mutually reachable through their certificates (strongly connected components) when done")
+	flag.BoolVar(&config.printDOT, "dot", false, "print the graph in GraphViz DOT format, ex: certgraph -dot example.com | dot -Tsvg")
+	flag.BoolVar(&config.printGraphML, "graphml", false, "print the graph as GraphML, can be used with yEd or Cytoscape")
+	flag.BoolVar(&config.printSTIX, "stix", false, "print the graph as a STIX 2.1 bundle")
+	flag.StringVar(&portsString, "ports", "", "comma separated ports for the http, smtp, imap, and pop3 drivers to connect to, ex: 443,8443 (default the driver's standard port), start domains passed as host:port only use their own port")
+	flag.StringVar(&config.sniList, "sni-list", "", "file of \"ip,sni\" lines, the http driver connects to each ip sending the sni and the snis are added to the start domains")
+	flag.StringVar(&config.parquetPath, "parquet", "", "write the graph's domains and edges to parquet files in folder")
+	flag.StringVar(&config.msgpackPath, "msgpack", "", "write the graph to file as MessagePack with the same structure as -json")
+	flag.StringVar(&config.csvEdges, "csv", "", "write the graph's edges to file as csv rows of source, target, type, driver, and depth")
+	flag.StringVar(&config.csvNodes, "csv-nodes", "", "write the graph's domain and certificate nodes to file as csv rows of id, type, status, and root")
+	flag.StringVar(&config.splitComponents, "split-components", "", "write each connected component of the graph as a json graph file named component-N.json in folder, largest first")
+	flag.BoolVar(&config.splitSingletons, "split-singletons", false, "write the -split-components components of a single domain without certificates together in singletons.json")
+	flag.StringVar(&config.failuresOut, "failures-out", "", "write the domains that could not be queried to file as tab separated domain and error lines")
+	flag.Var(&config.headers, "header", "header to add to http driver requests in the form \"Name: Value\", may be repeated")
 	flag.StringVar(&config.savePath, "save", "", "save certs to folder in PEM format")
+	flag.BoolVar(&config.saveManifest, "save-manifest", false, "write a manifest.json to the -save folder mapping each saved cert to its domains and drivers")
 	flag.StringVar(&config.serve, "serve", "", "address:port to serve html UI on")
-	flag.StringVar(&regexString, "regex", "", "regex domains must match to be part of the graph")
+	flag.StringVar(&config.serveGraph, "serve-graph", "", "json graph file to load in the html UI served with -serve")
+	flag.BoolVar(&config.serveScan, "serve-scan", false, "allow scans of domains posted to /api/scan on the html UI served with -serve")
+	flag.Var(&config.regex, "regex", "regex domains must match to be part of the graph, may be repeated to match any of the regexes")
+	flag.Var(&config.issuer, "issuer", "regex the issuer common name or organization of certificates must match to be part of the graph, may be repeated to match any of the regexes")
+	flag.Var(&config.excludeIssuer, "exclude-issuer", "regex of issuer common names or organizations to drop certificates from the graph, may be repeated")
+	flag.StringVar(&scopeString, "scope", "", "comma separated apex domains to crawl, ex: example.com,example.net, domains found outside of them are added to the graph without being visited")
+	flag.Var(&config.glob, "glob", "shell glob domains must match to be part of the graph, ex: *.example.com, may be repeated and combined with -regex to match any of them")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s: [OPTION]... HOST...\n\thttps://github.com/lanrat/certgraph\nOPTIONS:\n", os.Args[0])
@@ -104,24 +325,102 @@ func main() {
 		fmt.Println(version())
 		return
 	}
-
-	// check for regex
-	if len(regexString) > 0 {
-		config.regex, err = regexp.Compile(regexString)
+	if config.printVersionJSON {
+		j, err := json.Marshal(map[string]interface{}{
+			"version":    gitHash,
+			"date":       gitDate,
+			"go_version": runtime.Version(),
+			"drivers":    driver.Drivers,
+		})
 		if err != nil {
 			e(err)
 			return
 		}
+		fmt.Println(string(j))
+		return
 	}
 
-	if len(config.serve) > 0 {
-		err = web.Serve(config.serve, webContent)
+	graph.SetMaxSANsPrint(config.maxSANsPrint)
+	graph.SetLinkKeyIDs(config.linkAKID)
+
+	// set the output form of internationalized domains
+	err = graph.SetOutputIDN(config.outputIDN)
+	if err != nil {
 		e(err)
 		return
 	}
 
+	// check for srv services
+	if len(srvString) > 0 {
+		config.srvServices = strings.Split(srvString, ",")
+	}
+
+	// parse ports for the http, smtp, imap, and pop3 drivers
+	if len(portsString) > 0 {
+		config.ports, err = parsePorts(portsString)
+		if err != nil {
+			e(err)
+			return
+		}
+	}
+
+	// parse apex domains to limit the crawl to
+	if len(scopeString) > 0 {
+		config.scope, err = parseScope(scopeString)
+		if err != nil {
+			e(err)
+			return
+		}
+	}
+
+	// parse issuance window for CT drivers
+	if len(issuedString) > 0 {
+		config.issuedSince, err = parseIssuedSince(issuedString)
+		if err != nil {
+			e(err)
+			return
+		}
+	}
+
+	// parse historical date for CT drivers
+	if len(asOfString) > 0 {
+		config.asOf, err = time.Parse("2006-01-02", asOfString)
+		if err != nil {
+			e(err)
+			return
+		}
+	}
+
+	// domains to start the search from were passed
+	hasInput := flag.NArg() > 0 || len(config.importCSV) > 0 || len(config.sniList) > 0 || len(config.domainsFile) > 0
+
+	if len(config.serve) > 0 {
+		var graphJSON []byte
+		if len(config.serveGraph) > 0 {
+			graphJSON, err = os.ReadFile(config.serveGraph)
+			if err != nil {
+				e(err)
+				return
+			}
+			if !json.Valid(graphJSON) {
+				e("invalid json graph:", config.serveGraph)
+				return
+			}
+		}
+		if !hasInput && !config.serveScan {
+			err = web.Serve(config.serve, webContent, graphJSON)
+			e(err)
+			return
+		}
+		// serve the graph as it is crawled, and keep serving it once the crawl is done
+		go func() {
+			e(web.Serve(config.serve, webContent, graphJSON))
+			os.Exit(1)
+		}()
+	}
+
 	// print usage if no domain passed
-	if flag.NArg() < 1 {
+	if !hasInput && len(config.timeline) == 0 && !(config.serveScan && len(config.serve) > 0) {
 		flag.Usage()
 		return
 	}
@@ -132,39 +431,145 @@ func main() {
 		flag.Usage()
 		return
 	}
+	if config.saveManifest && len(config.savePath) == 0 {
+		fmt.Fprintln(os.Stderr, "-save-manifest requires -save")
+		flag.Usage()
+		return
+	}
+	if config.certParallel < 1 {
+		config.certParallel = config.parallel
+	}
+	certThreadPass = make(chan bool, config.certParallel)
+	for i := uint(0); i < config.certParallel; i++ {
+		certThreadPass <- true
+	}
+
+	dns.SetCacheTTL(config.dnsCacheTTL)
+	dns.SetRetries(int(config.dnsRetries))
 
 	// update the public suffix list if required
 	if config.updatePSL {
-		err = dns.UpdatePublicSuffixList(config.timeout)
+		err = dns.UpdatePublicSuffixList(config.timeout, config.pslMaxAge)
 		if err != nil {
-			e(err)
-			return
+			// the built in list is still usable
+			e("unable to update the Public Suffix List, using the built in list:", err)
 		}
 	}
+	err = dns.CheckPublicSuffixList()
+	if err != nil {
+		e("the Public Suffix List failed to load, using the last two labels of domains as their apex:", err)
+	}
 
 	// add domains passed to startDomains
-	startDomains := make([]string, 0, 1)
-	for _, domain := range flag.Args() {
+	seeds := flag.Args()
+	if len(config.domainsFile) > 0 {
+		fileDomains, err := readDomainsFile(config.domainsFile)
+		if err != nil {
+			e(err)
+			return
+		}
+		seeds = append(seeds, fileDomains...)
+	}
+	startDomains := make([]string, 0, len(seeds))
+	for _, domain := range seeds {
 		d := strings.ToLower(domain)
 		if len(d) > 0 {
-			startDomains = append(startDomains, cleanInput(d))
+			d, port := splitInputPort(d)
+			d = cleanInput(d)
+			if len(port) > 0 {
+				addSeedPort(d, port)
+			}
+			startDomains = append(startDomains, d)
 			if config.apex {
-				apexDomain, err := dns.ApexDomain(domain)
-				if err != nil {
-					continue
-				}
-				startDomains = append(startDomains, apexDomain)
+				startDomains = append(startDomains, dns.ApexDomainFallback(d))
+			}
+		}
+	}
+
+	// connect to the IP of each -sni-list hostname and start the search from the hostnames
+	if len(config.sniList) > 0 {
+		sniAddresses, err = readSNIList(config.sniList)
+		if err != nil {
+			e(err)
+			return
+		}
+		snis := make([]string, 0, len(sniAddresses))
+		for sni := range sniAddresses {
+			snis = append(snis, sni)
+		}
+		sort.Strings(snis)
+		startDomains = append(startDomains, snis...)
+	}
+
+	// print the certificate history of a domain instead of crawling
+	if len(config.timeline) > 0 {
+		timelineDriver := defaultTimelineDriver
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "driver" {
+				timelineDriver = config.driver
 			}
+		})
+		err = printTimeline(cleanInput(strings.ToLower(config.timeline)), timelineDriver)
+		if err != nil {
+			e(err)
+		}
+		return
+	}
+
+	// compare drivers instead of crawling
+	if len(config.compareDrivers) > 0 {
+		err = compareDrivers(config.compareDrivers, startDomains)
+		if err != nil {
+			e(err)
+		}
+		return
+	}
+
+	// import certificates
+	var importDriver *csvimport.Import
+	if len(config.importCSV) > 0 {
+		importDriver, err = csvimport.Driver(config.importCSV)
+		if err != nil {
+			e(err)
+			return
 		}
+		if len(startDomains) == 0 {
+			startDomains = importDriver.Domains()
+		}
+	}
+
+	for _, domain := range startDomains {
+		seedApexes.add(domain)
 	}
 
 	// set driver
-	certDriver, err = setDriver(config.driver)
+	switch {
+	case importDriver != nil && config.importCrawl:
+		var crawlDriver driver.Driver
+		crawlDriver, err = setDriver(config.driver, driverOptions())
+		certDriver = importDriver.Crawl(crawlDriver)
+	case importDriver != nil:
+		certDriver = importDriver
+	default:
+		certDriver, err = setDriver(config.driver, driverOptions())
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 
+	// only add the certificates matching the certificate filters to the graph
+	certFilters := make([]filter.Func, 0, 2)
+	if len(config.keyAlgo) > 0 {
+		certFilters = append(certFilters, keyAlgoMatch)
+	}
+	if len(config.issuer) > 0 || len(config.excludeIssuer) > 0 {
+		certFilters = append(certFilters, issuerMatch)
+	}
+	if len(certFilters) > 0 {
+		certDriver = filter.Wrap(certDriver, certFilters...)
+	}
+
 	// create the output directory if it does not exist
 	if len(config.savePath) > 0 {
 		err := os.MkdirAll(config.savePath, 0777)
@@ -172,26 +577,164 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			return
 		}
+		// fail now instead of after crawling if the certs can't be saved
+		err = checkWritable(config.savePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+
+	// resume from the checkpoint and save it when interrupted
+	if len(config.checkpoint) > 0 {
+		crawlCheckpoint, err = readCheckpoint(config.checkpoint)
+		if err != nil {
+			e(err)
+			return
+		}
+		crawlCheckpoint.restoreGraph(certGraph)
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupt
+			err := crawlCheckpoint.write()
+			if err != nil {
+				e(err)
+			}
+			os.Exit(1)
+		}()
 	}
 
 	// perform breath-first-search on the graph
+	// scans posted to the web server are refused until the crawl of the start domains is done
+	ownsScan := atomic.CompareAndSwapInt32(&scanning, 0, 1)
+	if config.serveScan && len(config.serve) > 0 {
+		web.SetScanner(scanDomain)
+	}
 	breathFirstSearch(startDomains)
+	if ownsScan {
+		atomic.StoreInt32(&scanning, 0)
+	}
 
 	// print the json output
 	if config.printJSON {
 		printJSONGraph()
 	}
 
+	// print the stix output
+	if config.printSTIX {
+		printSTIXGraph()
+	}
+
+	// print the graphml output
+	if config.printGraphML {
+		err = certGraph.GenerateGraphML(os.Stdout)
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// print the dot output
+	if config.printDOT {
+		fmt.Print(certGraph.GenerateDOT())
+	}
+
+	// print the tld summary
+	if config.tldSummary {
+		printTLDSummary()
+	}
+
+	// print the depth histogram
+	if config.depthHistogram {
+		printDepthHistogram()
+	}
+
+	// print the strongly connected components
+	if config.scc {
+		printSCC()
+	}
+
+	// print the graph's content hash
+	if config.hash {
+		fmt.Println(certGraph.ContentHash())
+	}
+
+	// write the manifest of saved certs
+	if config.saveManifest {
+		err = writeSaveManifest(config.savePath)
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// write the failed domains
+	if len(config.failuresOut) > 0 {
+		err = failures.writeFile(config.failuresOut)
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// write the parquet output
+	if len(config.parquetPath) > 0 {
+		err = writeParquetGraph(config.parquetPath)
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// write the msgpack output
+	if len(config.msgpackPath) > 0 {
+		err = msgpack.WriteFile(config.msgpackPath, jsonGraph())
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// write the csv output
+	if len(config.csvEdges) > 0 {
+		err = writeCSVFile(config.csvEdges, certGraph.WriteEdgeCSV)
+		if err != nil {
+			e(err)
+		}
+	}
+	if len(config.csvNodes) > 0 {
+		err = writeCSVFile(config.csvNodes, certGraph.WriteNodeCSV)
+		if err != nil {
+			e(err)
+		}
+	}
+
+	// write each component of the graph
+	if len(config.splitComponents) > 0 {
+		err = writeComponents(config.splitComponents)
+		if err != nil {
+			e(err)
+		}
+	}
+
 	v("Found", certGraph.NumDomains(), "domains")
 	v("Graph Depth:", certGraph.DomainDepth())
+
+	if config.printStats {
+		for _, td := range timingDrivers {
+			td.PrintStats(os.Stderr)
+		}
+	}
+
+	// keep serving the html UI and the graph once the crawl is done
+	if len(config.serve) > 0 {
+		v("Crawl done, serving on", config.serve)
+		select {}
+	}
 }
 
-func setDriver(name string) (driver.Driver, error) {
+func setDriver(name string, options driver.Options) (driver.Driver, error) {
 	if strings.Contains(name, ",") {
 		names := strings.Split(name, ",")
 		drivers := make([]driver.Driver, 0, len(names))
 		for _, driverName := range names {
-			d, err := getDriverSingle(driverName)
+			d, err := getDriverSingle(driverName, options)
 			if err != nil {
 				return nil, err
 			}
@@ -199,171 +742,1010 @@ func setDriver(name string) (driver.Driver, error) {
 		}
 		return multi.Driver(drivers), nil
 	}
-	return getDriverSingle(name)
-}
-
-// getDriverSingle sets the driver variable for the provided driver string and does any necessary driver prep work
-// TODO make config generic and move this to driver module
-func getDriverSingle(name string) (driver.Driver, error) {
-	var err error
-	var d driver.Driver
-	switch name {
-	case "crtsh":
-		d, err = crtsh.Driver(1000, config.timeout, config.savePath, config.includeCTSubdomains, config.includeCTExpired)
-	case "http":
-		d, err = http.Driver(config.timeout, config.savePath)
-	case "smtp":
-		d, err = smtp.Driver(config.timeout, config.savePath)
-	case "censys":
-		d, err = censys.Driver(config.savePath, config.includeCTSubdomains, config.includeCTExpired)
-	default:
-		return nil, fmt.Errorf("unknown driver name: %s", config.driver)
-	}
-	return d, err
+	return getDriverSingle(name, options)
 }
 
-// verbose logging
-func v(a ...interface{}) {
-	if config.verbose {
-		e(a...)
+// defaultParallels returns the default maximum concurrent queries of the drivers that have one, ex: "crtsh: 4"
+func defaultParallels() []string {
+	defaults := make([]string, 0)
+	for _, name := range driver.Drivers {
+		if parallel := driver.GetInfo(name).DefaultParallel; parallel > 0 {
+			defaults = append(defaults, fmt.Sprintf("%s: %d", name, parallel))
+		}
 	}
+	return defaults
 }
 
-func e(a ...interface{}) {
-	if a != nil {
-		fmt.Fprintln(os.Stderr, a...)
+// driverOptions returns the driver options set by the flags
+func driverOptions() driver.Options {
+	return driver.Options{
+		Timeout:           config.timeout,
+		SavePath:          config.savePath,
+		IncludeSubdomains: config.includeCTSubdomains,
+		IncludeExpired:    config.includeCTExpired,
+		IncludeCN:         !config.ctNoCN,
+		Ordered:           config.deterministic,
+		AsOf:              config.asOf,
+		IssuedSince:       config.issuedSince,
+		MaxCertSANs:       config.maxCertSANs,
+		Headers:           config.headers,
+		SNIAddresses:      sniAddresses,
+		Ports:             config.ports,
+		HostPorts:         seedPorts,
+		Chain:             config.chain,
+		DumpQueries:       config.dumpQueries,
+		LinkHints:         config.linkHints,
+		RespectRobots:     config.respectRobots,
+		Verbose:           config.verbose,
 	}
 }
 
-// prints the graph as a json object
-func printJSONGraph() {
-	jsonGraph := certGraph.GenerateMap()
-	jsonGraph["certgraph"] = generateGraphMetadata()
-
-	j, err := json.MarshalIndent(jsonGraph, "", "\t")
+// getDriverSingle creates the registered driver for the provided driver name and does any necessary driver prep work
+func getDriverSingle(name string, options driver.Options) (driver.Driver, error) {
+	d, err := driver.New(name, options)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
-	fmt.Println(string(j))
+	ctParallel := driver.GetInfo(name).DefaultParallel
+	if ctParallel > 0 && config.ctParallel > 0 {
+		ctParallel = config.ctParallel
+	}
+	if config.printStats {
+		td := timing.Wrap(d)
+		timingDrivers = append(timingDrivers, td)
+		d = td
+	}
+	// space out queries to avoid being throttled, after timing so waiting is not counted as latency
+	if config.rate > 0 {
+		d = limit.Rate(d, config.rate)
+	}
+	// limit CT drivers to avoid rate limits
+	if ctParallel > 0 {
+		d = limit.Wrap(d, ctParallel)
+	}
+	return d, nil
 }
 
-// breathFirstSearch perform Breadth first search to build the graph
-func breathFirstSearch(roots []string) {
-	var wg sync.WaitGroup
-	domainNodeInputChan := make(chan *graph.DomainNode, 5)  // input queue
-	domainNodeOutputChan := make(chan *graph.DomainNode, 5) // output queue
-
-	// thread limit code
-	threadPass := make(chan bool, config.parallel)
-	for i := uint(0); i < config.parallel; i++ {
-		threadPass <- true
+// compareDrivers queries each domain with both of the comma separated drivers and prints
+// the certificates found by only one of them as "domain\tdriver\tfingerprint" lines
+func compareDrivers(names string, domains []string) error {
+	driverNames := strings.Split(names, ",")
+	if len(driverNames) != 2 {
+		return fmt.Errorf("-compare-drivers requires exactly two drivers, got: %s", names)
 	}
-
-	// thread to put root nodes/domains into queue
-	wg.Add(1)
-	go func() {
-		// the waitGroup Add and Done for this thread ensures that we don't exit before any of the inputs domains are put into the Queue
-		defer wg.Done()
-		for _, root := range roots {
-			wg.Add(1)
-			n := graph.NewDomainNode(root, 0)
-			n.Root = true
-			domainNodeInputChan <- n
+	drivers := make([]driver.Driver, 0, len(driverNames))
+	for _, name := range driverNames {
+		d, err := getDriverSingle(name, driverOptions())
+		if err != nil {
+			return err
 		}
-	}()
-	// thread to start all other threads from DomainChan
-	go func() {
-		for {
-			domainNode := <-domainNodeInputChan
+		drivers = append(drivers, d)
+	}
 
-			// depth check
-			if domainNode.Depth > config.maxDepth {
-				v("Max depth reached, skipping:", domainNode.Domain)
-				wg.Done()
+	for _, domain := range domains {
+		found := make([]map[fingerprint.Fingerprint]bool, len(drivers))
+		for i, d := range drivers {
+			found[i] = make(map[fingerprint.Fingerprint]bool)
+			results, err := d.QueryDomain(context.Background(), domain)
+			if err != nil {
+				v("QueryDomain", d.GetName(), domain, err)
 				continue
 			}
-			// use certGraph.domains map as list of
-			// domains that are queued to be visited, or already have been
-
-			if _, found := certGraph.GetDomain(domainNode.Domain); !found {
-				certGraph.AddDomain(domainNode)
-				go func(domainNode *graph.DomainNode) {
-					defer wg.Done()
-					// wait for pass
-					<-threadPass
-					defer func() { threadPass <- true }()
-
-					// regex match check
-					if config.regex != nil && !config.regex.MatchString(domainNode.Domain) {
-						// skip domain that does not match regex
-						v("domain does not match regex, skipping :", domainNode.Domain)
-						return
-					}
-
-					// operate on the node
-					v("Visiting", domainNode.Depth, domainNode.Domain)
-					visit(domainNode)
-					domainNodeOutputChan <- domainNode
-					for _, neighbor := range certGraph.GetDomainNeighbors(domainNode.Domain, config.cdn, config.maxSANsSize) {
-						wg.Add(1)
-						domainNodeInputChan <- graph.NewDomainNode(neighbor, domainNode.Depth+1)
-						if config.apex {
-							apexDomain, err := dns.ApexDomain(neighbor)
-							if err != nil {
-								continue
-							}
-							wg.Add(1)
-							domainNodeInputChan <- graph.NewDomainNode(apexDomain, domainNode.Depth+1)
-						}
-					}
-				}(domainNode)
-			} else {
-				wg.Done()
+			fingerprintMap, err := results.GetFingerprints()
+			if err != nil {
+				v("GetFingerprints", d.GetName(), err)
+				continue
+			}
+			for _, fp := range fingerprintMap[domain] {
+				found[i][fp] = true
 			}
 		}
-	}()
-
-	// save/output thread
-	done := make(chan bool)
-	go func() {
-		for {
-			domainNode, more := <-domainNodeOutputChan
-			if more {
-				if !config.printJSON {
-					printNode(domainNode)
-				} else if config.details {
-					fmt.Fprintln(os.Stderr, domainNode)
+		for i, d := range drivers {
+			other := found[1-i]
+			only := make([]fingerprint.Fingerprint, 0)
+			for fp := range found[i] {
+				if !other[fp] {
+					only = append(only, fp)
 				}
-			} else {
-				done <- true
-				return
+			}
+			for _, fp := range sortedFingerprints(only) {
+				fmt.Printf("%s\t%s\t%s\n", domain, d.GetName(), fp.HexString())
 			}
 		}
-	}()
+	}
+	return nil
+}
 
-	wg.Wait() // wait for querying to finish
-	close(domainNodeOutputChan)
-	<-done // wait for save to finish
+// defaultTimelineDriver is used by -timeline when -driver is not set
+const defaultTimelineDriver = "crtsh"
+
+// timelineDrivers are the drivers -timeline can use, they search the certificate history instead of what is served now
+var timelineDrivers = map[string]bool{
+	"crtsh":       true,
+	"censys":      true,
+	"certspotter": true,
 }
 
-// visit visits each node and get and set its neighbors
-func visit(domainNode *graph.DomainNode) {
-	// check NS if necessary
-	if config.checkDNS {
-		_, err := domainNode.CheckForDNS(config.timeout)
-		if err != nil {
-			v("CheckForNS", err)
+// printTimeline prints every certificate the driver finds for the domain sorted by when it was issued
+// each line has the certificate's validity, issuer, number of SANs, and fingerprint, or a json array with -json
+func printTimeline(domain, driverNames string) error {
+	for _, name := range strings.Split(driverNames, ",") {
+		if !timelineDrivers[name] {
+			return fmt.Errorf("-timeline requires a certificate transparency driver, got: %s", name)
 		}
 	}
-
-	// perform cert search
-	// TODO do pagination in multiple threads to not block on long searches
-	results, err := certDriver.QueryDomain(domainNode.Domain)
+	// the history includes every certificate, not only the valid ones
+	options := driverOptions()
+	options.IncludeExpired = true
+	d, err := setDriver(driverNames, options)
 	if err != nil {
-		// this is VERY common to error, usually this is a DNS or tcp connection related issue
+		return err
+	}
+	timeline, err := certTimeline(context.Background(), d, domain)
+	if err != nil {
+		return err
+	}
+
+	if config.printJSON {
+		entries := make([]map[string]interface{}, 0, len(timeline))
+		for _, cert := range timeline {
+			entries = append(entries, map[string]interface{}{
+				"fingerprint": cert.Fingerprint.HexString(),
+				"not_before":  cert.NotBefore.UTC().Format(time.RFC3339),
+				"not_after":   cert.NotAfter.UTC().Format(time.RFC3339),
+				"issuer":      timelineIssuer(cert),
+				"sans":        len(cert.Domains),
+			})
+		}
+		printJSON(entries)
+		return nil
+	}
+	for _, cert := range timeline {
+		fmt.Printf("%s\t%s\t%s\t%d\t%s\n", cert.NotBefore.UTC().Format("2006-01-02"), cert.NotAfter.UTC().Format("2006-01-02"), timelineIssuer(cert), len(cert.Domains), cert.Fingerprint.HexString())
+	}
+	return nil
+}
+
+// certTimeline returns every certificate the driver finds for the domain sorted by NotBefore, then fingerprint
+// certificates that fail to load are skipped
+func certTimeline(ctx context.Context, d driver.Driver, domain string) ([]*driver.CertResult, error) {
+	results, err := d.QueryDomain(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	fingerprintMap, err := results.GetFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := fingerprintMap[domain]
+	certs := make([]*driver.CertResult, len(fingerprints))
+	parallelFor(len(fingerprints), config.certParallel, func(i int) {
+		cert, err := results.QueryCert(ctx, fingerprints[i])
+		if err != nil {
+			v("QueryCert", err)
+			return
+		}
+		certs[i] = cert
+	})
+
+	timeline := make([]*driver.CertResult, 0, len(certs))
+	for _, cert := range certs {
+		if cert != nil {
+			timeline = append(timeline, cert)
+		}
+	}
+	sort.Slice(timeline, func(i, j int) bool {
+		if !timeline[i].NotBefore.Equal(timeline[j].NotBefore) {
+			return timeline[i].NotBefore.Before(timeline[j].NotBefore)
+		}
+		return timeline[i].Fingerprint.HexString() < timeline[j].Fingerprint.HexString()
+	})
+	return timeline, nil
+}
+
+// timelineIssuer returns the issuer's common name and organizations, or "unknown" if the driver does not know them
+func timelineIssuer(cert *driver.CertResult) string {
+	issuer := cert.IssuerCN
+	if len(cert.IssuerOrgs) > 0 {
+		issuer = strings.TrimSpace(fmt.Sprintf("%s (%s)", issuer, strings.Join(cert.IssuerOrgs, ", ")))
+	}
+	if len(issuer) == 0 {
+		return "unknown"
+	}
+	return issuer
+}
+
+// verbose logging
+func v(a ...interface{}) {
+	if config.verbose {
+		e(a...)
+	}
+}
+
+func e(a ...interface{}) {
+	if a != nil {
+		fmt.Fprintln(os.Stderr, a...)
+	}
+}
+
+// prints the graph as a json object
+func printJSONGraph() {
+	printJSON(jsonGraph())
+}
+
+// jsonGraph returns the crawl result as the map output by -json and -msgpack
+func jsonGraph() map[string]interface{} {
+	jsonGraph := crawlResult().Map()
+	if config.noSeeds {
+		removeSeeds(jsonGraph)
+	}
+	if config.mergeEdges {
+		jsonGraph["links"] = graph.MergeLinks(jsonGraph["links"].([]map[string]string))
+	}
+	return jsonGraph
+}
+
+// crawlResult returns the graph with the crawl's metadata, errors, and stats
+func crawlResult() *graph.CrawlResult {
+	result := &graph.CrawlResult{
+		Graph:        certGraph,
+		Metadata:     generateGraphMetadata(),
+		DriverErrors: failures.driverErrors(),
+		Failures:     failures.domains(),
+		Stats:        make(map[string]interface{}),
+	}
+	for _, td := range timingDrivers {
+		result.Stats[td.GetName()] = td.Stats()
+	}
+	return result
+}
+
+// seedDomains returns the domains provided to start the search
+func seedDomains() []string {
+	seeds := make([]string, 0)
+	for _, domainNode := range certGraph.GetDomains() {
+		if domainNode.Root {
+			seeds = append(seeds, graph.OutputDomain(domainNode.Domain))
+		}
+	}
+	sort.Strings(seeds)
+	return seeds
+}
+
+// removeSeeds removes the seed domain nodes and their links from the json graph
+func removeSeeds(jsonGraph map[string]interface{}) {
+	seeds := make(map[string]bool)
+	for _, seed := range seedDomains() {
+		seeds[seed] = true
+	}
+	nodes := make([]map[string]string, 0)
+	for _, node := range jsonGraph["nodes"].([]map[string]string) {
+		if node["type"] != "domain" || !seeds[node["id"]] {
+			nodes = append(nodes, node)
+		}
+	}
+	links := make([]map[string]string, 0)
+	for _, link := range jsonGraph["links"].([]map[string]string) {
+		if !seeds[link["source"]] && !seeds[link["target"]] {
+			links = append(links, link)
+		}
+	}
+	jsonGraph["nodes"] = nodes
+	jsonGraph["links"] = links
+}
+
+// prints the number of domains in each TLD, most common first
+func printTLDSummary() {
+	counts := make(map[string]int)
+	for _, domainNode := range certGraph.GetDomains() {
+		tld, err := dns.TLD(domainNode.Domain)
+		if err != nil {
+			v("TLD", err)
+			continue
+		}
+		counts[tld]++
+	}
+	tlds := make([]string, 0, len(counts))
+	for tld := range counts {
+		tlds = append(tlds, tld)
+	}
+	sort.Slice(tlds, func(i, j int) bool {
+		if counts[tlds[i]] != counts[tlds[j]] {
+			return counts[tlds[i]] > counts[tlds[j]]
+		}
+		return tlds[i] < tlds[j]
+	})
+	for _, tld := range tlds {
+		fmt.Printf("%s\t%d\n", tld, counts[tld])
+	}
+}
+
+// manifestEntry describes a saved certificate file in the -save-manifest output
+type manifestEntry struct {
+	Fingerprint string   `json:"fingerprint"`
+	File        string   `json:"file"`
+	Domains     []string `json:"domains"`
+	Drivers     []string `json:"drivers"`
+	driverSet   map[string]bool
+}
+
+// writes manifest.json to dir listing each saved cert file with the domains that presented it and the drivers that found it
+func writeSaveManifest(dir string) error {
+	entries := make(map[fingerprint.Fingerprint]*manifestEntry)
+	for _, domainNode := range certGraph.GetDomains() {
+		for fp, drivers := range domainNode.Certs {
+			entry, ok := entries[fp]
+			if !ok {
+				file := fp.HexString() + ".pem"
+				// not every driver saves every cert it finds
+				if _, err := os.Stat(path.Join(dir, file)); err != nil {
+					continue
+				}
+				entry = &manifestEntry{Fingerprint: fp.HexString(), File: file, driverSet: make(map[string]bool)}
+				entries[fp] = entry
+			}
+			entry.Domains = append(entry.Domains, graph.OutputDomain(domainNode.Domain))
+			for _, driver := range drivers {
+				if !entry.driverSet[driver] {
+					entry.driverSet[driver] = true
+					entry.Drivers = append(entry.Drivers, driver)
+				}
+			}
+		}
+	}
+
+	manifest := make([]*manifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		sort.Strings(entry.Domains)
+		sort.Strings(entry.Drivers)
+		manifest = append(manifest, entry)
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		return manifest[i].Fingerprint < manifest[j].Fingerprint
+	})
+
+	j, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, "manifest.json"), append(j, '\n'), 0666)
+}
+
+// prints each strongly connected component of the domain graph as its size and members, largest first
+func printSCC() {
+	for _, component := range certGraph.StronglyConnectedComponents(config.cdn, config.maxSANsSize) {
+		members := make([]string, 0, len(component))
+		for _, domain := range component {
+			members = append(members, graph.OutputDomain(domain))
+		}
+		fmt.Printf("%d\t%s\n", len(members), strings.Join(members, " "))
+	}
+}
+
+// prints the number of domains at each depth, starting with the seed domains at depth 0
+func printDepthHistogram() {
+	counts := make([]int, 0)
+	for _, domainNode := range certGraph.GetDomains() {
+		for uint(len(counts)) <= domainNode.Depth {
+			counts = append(counts, 0)
+		}
+		counts[domainNode.Depth]++
+	}
+	for depth, count := range counts {
+		fmt.Printf("%d\t%d\n", depth, count)
+	}
+}
+
+// prints the graph as a STIX 2.1 bundle
+func printSTIXGraph() {
+	printJSON(certGraph.GenerateSTIX())
+}
+
+// writes the graph as domains.parquet and edges.parquet in dir
+func writeParquetGraph(dir string) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	domainNodes := certGraph.GetDomains()
+	domains := make([]string, 0, len(domainNodes))
+	depths := make([]int32, 0, len(domainNodes))
+	statuses := make([]string, 0, len(domainNodes))
+	hasDNS := make([]bool, 0, len(domainNodes))
+	for _, domainNode := range domainNodes {
+		domains = append(domains, graph.OutputDomain(domainNode.Domain))
+		depths = append(depths, int32(domainNode.Depth))
+		statuses = append(statuses, domainNode.Status.String())
+		hasDNS = append(hasDNS, domainNode.HasDNS)
+	}
+	err = parquet.WriteFile(path.Join(dir, "domains.parquet"), []parquet.Column{
+		parquet.StringColumn("domain", domains),
+		parquet.Int32Column("depth", depths),
+		parquet.StringColumn("status", statuses),
+		parquet.BoolColumn("has_dns", hasDNS),
+	})
+	if err != nil {
+		return err
+	}
+
+	links := certGraph.GenerateMap()["links"].([]map[string]string)
+	sources := make([]string, 0, len(links))
+	targets := make([]string, 0, len(links))
+	types := make([]string, 0, len(links))
+	for _, link := range links {
+		sources = append(sources, link["source"])
+		targets = append(targets, link["target"])
+		types = append(types, link["type"])
+	}
+	return parquet.WriteFile(path.Join(dir, "edges.parquet"), []parquet.Column{
+		parquet.StringColumn("source", sources),
+		parquet.StringColumn("target", targets),
+		parquet.StringColumn("type", types),
+	})
+}
+
+// creates file and writes the csv output to it
+func writeCSVFile(file string, write func(io.Writer) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writes each connected component of the graph as component-N.json in dir
+// with -split-singletons the components of a single domain without certificates are written together as singletons.json
+func writeComponents(dir string) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	singletons := graph.NewCertGraph()
+	n := 0
+	for _, component := range certGraph.Components() {
+		if config.splitSingletons && component.NumDomains() == 1 && component.NumCerts() == 0 {
+			for _, domainNode := range component.GetDomains() {
+				singletons.AddDomain(domainNode)
+			}
+			continue
+		}
+		n++
+		err = writeJSONFile(path.Join(dir, fmt.Sprintf("component-%d.json", n)), component.GenerateMap())
+		if err != nil {
+			return err
+		}
+	}
+	if config.splitSingletons {
+		return writeJSONFile(path.Join(dir, "singletons.json"), singletons.GenerateMap())
+	}
+	return nil
+}
+
+// writes the object as json to file, indented unless -json-compact is set
+func writeJSONFile(file string, obj interface{}) error {
+	var j []byte
+	var err error
+	if config.jsonCompact {
+		j, err = json.Marshal(obj)
+	} else {
+		j, err = json.MarshalIndent(obj, "", "\t")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(j, '\n'), 0666)
+}
+
+// prints the object as json, indented unless -json-compact is set
+func printJSON(obj interface{}) {
+	var j []byte
+	var err error
+	if config.jsonCompact {
+		j, err = json.Marshal(obj)
+	} else {
+		j, err = json.MarshalIndent(obj, "", "\t")
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(j))
+}
+
+// breathFirstSearch perform Breadth first search to build the graph
+// the search is level-synchronous, every domain at a depth is visited before moving on to the next depth
+func breathFirstSearch(roots []string) {
+	domainNodeOutputChan := make(chan *graph.DomainNode, 5) // output queue
+
+	// time of the last crawl progress in unix nanoseconds, for -stall-timeout
+	var lastProgress int64
+	progressAt := func(t time.Time) {
+		// only move forward, a depth delay may have set it in the future
+		for {
+			last := atomic.LoadInt64(&lastProgress)
+			if t.UnixNano() <= last || atomic.CompareAndSwapInt64(&lastProgress, last, t.UnixNano()) {
+				return
+			}
+		}
+	}
+	progress := func() {
+		progressAt(time.Now())
+	}
+	progress()
+
+	// save/output thread
+	done := make(chan bool)
+	go func() {
+		// edges already printed with -edgelist
+		printedEdges := make(map[string]bool)
+		for {
+			domainNode, more := <-domainNodeOutputChan
+			if more {
+				progress()
+				if config.printEdgeList {
+					printEdges(domainNode, printedEdges)
+				}
+				if config.jsonStream {
+					printNodeJSON(domainNode)
+				}
+				if listOutput() {
+					printNode(domainNode)
+				} else if config.details {
+					fmt.Fprintln(os.Stderr, domainNode)
+				}
+			} else {
+				done <- true
+				return
+			}
+		}
+	}()
+
+	// put root nodes/domains into the first level
+	level := make([]*graph.DomainNode, 0, len(roots))
+	for _, root := range roots {
+		n := graph.NewDomainNode(root, 0)
+		n.Root = true
+		level = append(level, n)
+	}
+	// continue an interrupted crawl from its queued domains, start domains already visited are skipped by visitLevel
+	if resumed := crawlCheckpoint.resumeLevel(); len(resumed) > 0 {
+		v("Resuming crawl from checkpoint with", len(resumed), "queued domains")
+		level = append(resumed, level...)
+	}
+	crawlCheckpoint.queue(level...)
+
+	// save the checkpoint periodically until the crawl is done
+	if crawlCheckpoint != nil {
+		ticker := time.NewTicker(checkpointInterval)
+		stopTicker := make(chan bool)
+		defer func() {
+			ticker.Stop()
+			close(stopTicker)
+		}()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					err := crawlCheckpoint.write()
+					if err != nil {
+						e(err)
+					}
+				case <-stopTicker:
+					return
+				}
+			}
+		}()
+	}
+
+	// crawl in the background so that a stalled crawl can be cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	crawlDone := make(chan bool)
+	go func() {
+		defer close(crawlDone)
+		for depth := uint(0); len(level) > 0 && !certLimitReached() && ctx.Err() == nil; depth++ {
+			if depth > 0 && config.depthDelay > 0 {
+				v("Reached depth", depth, "sleeping", config.depthDelay)
+				// sleeping is not a stall
+				progressAt(time.Now().Add(config.depthDelay))
+				select {
+				case <-time.After(config.depthDelay):
+				case <-ctx.Done():
+					return
+				}
+			}
+			level = visitLevel(ctx, level, domainNodeOutputChan)
+			if len(config.serve) > 0 {
+				publishGraph()
+			}
+			err := crawlCheckpoint.write()
+			if err != nil {
+				e(err)
+			}
+		}
+	}()
+
+	if config.stallTimeout > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+	watch:
+		for {
+			select {
+			case <-crawlDone:
+				break watch
+			case <-ticker.C:
+				if time.Since(time.Unix(0, atomic.LoadInt64(&lastProgress))) > config.stallTimeout {
+					// cancel the queries in flight and wait for the workers to stop before returning the graph found so far
+					e(fmt.Sprintf("crawl stalled, no progress for %s, returning partial results", config.stallTimeout))
+					cancel()
+					<-crawlDone
+					break watch
+				}
+			}
+		}
+	} else {
+		<-crawlDone
+	}
+
+	close(domainNodeOutputChan)
+	<-done // wait for save to finish
+}
+
+// publishGraph serves a snapshot of the graph from the web server
+// it is called between BFS levels, when no workers are modifying the graph
+func publishGraph() {
+	graphJSON, err := json.Marshal(certGraph.GenerateMap())
+	if err != nil {
+		e(err)
+		return
+	}
+	err = web.SetGraph(graphJSON)
+	if err != nil {
+		e(err)
+	}
+}
+
+// scanning is 1 while a crawl is running, scans posted to the web server wait for it to be 0
+var scanning int32
+
+// scanDomain crawls the domain into the graph in the background for a scan posted to the web server
+// domains already in the graph are not crawled again
+func scanDomain(domain string) error {
+	domain, _ = splitInputPort(strings.ToLower(domain))
+	domain = cleanInput(domain)
+	if len(domain) == 0 {
+		return fmt.Errorf("invalid domain")
+	}
+	if !atomic.CompareAndSwapInt32(&scanning, 0, 1) {
+		return web.ErrScanRunning
+	}
+	seedApexes.add(domain)
+	v("Scanning", domain, "from", config.serve)
+	go func() {
+		defer atomic.StoreInt32(&scanning, 0)
+		breathFirstSearch([]string{domain})
+	}()
+	return nil
+}
+
+// domainLimitNotice prints the -max-domains notice once
+var domainLimitNotice sync.Once
+
+// certLimitReached returns true if -max-certs is set and the graph has that many certificates
+func certLimitReached() bool {
+	return config.maxCerts > 0 && uint(certGraph.NumCerts()) >= config.maxCerts
+}
+
+// visitLevel visits all the domains in the level in parallel and returns the domains for the next level
+// domains are not visited once ctx is done
+func visitLevel(ctx context.Context, level []*graph.DomainNode, domainNodeOutputChan chan<- *graph.DomainNode) []*graph.DomainNode {
+	var nextLevelLock sync.Mutex
+	nextLevel := make([]*graph.DomainNode, 0, len(level))
+
+	// visit the level in a stable order so the same duplicate domain is always added first
+	if config.deterministic {
+		sortDomainNodes(level)
+	}
+
+	// remove domains that are too deep or have already been queued
+	queue := make([]*graph.DomainNode, 0, len(level))
+	for _, domainNode := range level {
+		// depth check
+		if domainNode.Depth > maxDepth(domainNode) {
+			// it stays queued in the checkpoint so a resumed crawl with a larger -depth continues from it
+			v("Max depth reached, skipping:", domainNode.Domain)
+			continue
+		}
+		// skip domains visited before the crawl was resumed
+		if crawlCheckpoint.isVisited(domainNode.Domain) {
+			continue
+		}
+		// use certGraph.domains map as list of
+		// domains that are queued to be visited, or already have been
+		if existing, found := certGraph.GetDomain(domainNode.Domain); found {
+			if existing.Depth == domainNode.Depth {
+				existing.AddParents(domainNode.Parents...)
+			}
+			continue
+		}
+		if !certGraph.AddDomainLimit(domainNode, int(config.maxDomains)) {
+			domainLimitNotice.Do(func() {
+				e(fmt.Sprintf("reached -max-domains %d, no more domains will be added to the crawl", config.maxDomains))
+			})
+			continue
+		}
+		queue = append(queue, domainNode)
+	}
+
+	// visit the highest scoring domains first
+	if config.priority {
+		scores := make(map[*graph.DomainNode]float64, len(queue))
+		for _, domainNode := range queue {
+			scores[domainNode] = domainScore(domainNode)
+		}
+		sort.SliceStable(queue, func(i, j int) bool {
+			return scores[queue[i]] > scores[queue[j]]
+		})
+	}
+
+	// worker threads visit the queue in order
+	// with -deterministic the visited domains are output in queue order once the level is done
+	visited := make([]bool, len(queue))
+	parallelFor(len(queue), config.parallel, func(i int) {
+		// wind down the crawl once enough certificates are found or it is cancelled, the remaining domains are not visited
+		if certLimitReached() || ctx.Err() != nil {
+			return
+		}
+		output := func(n *graph.DomainNode) {
+			domainNodeOutputChan <- n
+		}
+		if config.deterministic {
+			output = func(*graph.DomainNode) {
+				visited[i] = true
+			}
+		}
+		visitNode(ctx, queue[i], output, func(n *graph.DomainNode) {
+			nextLevelLock.Lock()
+			defer nextLevelLock.Unlock()
+			nextLevel = append(nextLevel, n)
+		})
+	})
+
+	if config.deterministic {
+		for i, domainNode := range queue {
+			if visited[i] {
+				domainNodeOutputChan <- domainNode
+			}
+		}
+	}
+	return nextLevel
+}
+
+// parallelFor calls fn with each index below n from at most workers goroutines
+// each goroutine takes the next index when it is done, so the number of goroutines does not grow with n
+func parallelFor(n int, workers uint, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if uint(n) < workers {
+		workers = uint(n)
+	}
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for w := uint(0); w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sortDomainNodes sorts the domain nodes by domain, then by the parents and sources that found them
+func sortDomainNodes(domainNodes []*graph.DomainNode) {
+	sort.Slice(domainNodes, func(i, j int) bool {
+		a, b := domainNodes[i], domainNodes[j]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if aParents, bParents := strings.Join(a.Parents, " "), strings.Join(b.Parents, " "); aParents != bParents {
+			return aParents < bParents
+		}
+		return strings.Join(a.Sources, " ") < strings.Join(b.Sources, " ")
+	})
+}
+
+// visitNode visits the domain, passes it to output, and passes each of its neighbors to addNeighbor
+func visitNode(ctx context.Context, domainNode *graph.DomainNode, output func(*graph.DomainNode), addNeighbor func(*graph.DomainNode)) {
+	// regex and glob match check
+	if (len(config.regex) > 0 || len(config.glob) > 0) && !config.regex.MatchString(domainNode.Domain) && !config.glob.Match(domainNode.Domain) {
+		// skip domain that does not match regex or glob
+		v("domain does not match regex or glob, skipping :", domainNode.Domain)
+		crawlCheckpoint.done(domainNode.Domain)
+		return
+	}
+
+	// start domains are always visited, neighbors outside of the scope are leaf nodes
+	if domainNode.Depth > 0 && !config.scope.contains(domainNode.Domain) {
+		v("domain out of scope, not expanding:", domainNode.Domain)
+		output(domainNode)
+		crawlCheckpoint.done(domainNode.Domain)
+		return
+	}
+
+	// operate on the node
+	v("Visiting", domainNode.Depth, domainNode.Domain)
+	visit(ctx, domainNode)
+	if ctx.Err() != nil {
+		// leave the domain queued in the checkpoint, it may not have been fully queried
+		return
+	}
+	output(domainNode)
+	neighbors := certGraph.GetDomainNeighborSources(domainNode.Domain, config.cdn, config.maxSANsSize)
+
+	for neighbor, sources := range neighbors {
+		if len(sources) == 0 {
+			if config.noRelatedExpansion {
+				v("related domain not expanded, skipping:", neighbor)
+				continue
+			}
+			// related domains are found by the driver used for the crawl
+			sources = []string{certDriver.GetName()}
+		}
+		n := graph.NewDomainNode(neighbor, domainNode.Depth+1)
+		n.Sources = sources
+		n.AddParents(domainNode.Domain)
+		addNeighbor(n)
+		crawlCheckpoint.queue(n)
+		if config.apex {
+			n := graph.NewDomainNode(dns.ApexDomainFallback(neighbor), domainNode.Depth+1)
+			n.Sources = sources
+			n.AddParents(domainNode.Domain)
+			addNeighbor(n)
+			crawlCheckpoint.queue(n)
+		}
+	}
+	crawlCheckpoint.done(domainNode.Domain)
+}
+
+// defaultDomainScore ranks domains sharing an apex with a seed domain highest, then domains whose
+// certificates were seen on a live host, then domains found on certificates with fewer SANs
+func defaultDomainScore(domainNode *graph.DomainNode) float64 {
+	score := 0.0
+	if seedApexes.contains(domainNode.Domain) {
+		score += 2
+	}
+	for _, source := range domainNode.Sources {
+		if driver.GetInfo(source).Live {
+			score++
+			break
+		}
+	}
+	// smallest certificate linking the domain to a parent
+	minSANs := 0
+	for _, parent := range domainNode.Parents {
+		parentNode, found := certGraph.GetDomain(parent)
+		if !found {
+			continue
+		}
+		for _, fp := range parentNode.GetCertificates() {
+			certNode, found := certGraph.GetCert(fp)
+			if !found || (minSANs > 0 && len(certNode.Domains) >= minSANs) {
+				continue
+			}
+			for _, domain := range certNode.Domains {
+				if strings.TrimPrefix(domain, "*.") == domainNode.Domain {
+					minSANs = len(certNode.Domains)
+					break
+				}
+			}
+		}
+	}
+	if minSANs > 0 {
+		score += 1 / float64(minSANs)
+	}
+	return score
+}
+
+// maxDepth returns the maximum depth the domain may be crawled at based on the drivers that discovered it
+// CT drivers use -depth-ct, live drivers use -depth-http, and a domain found by multiple drivers uses the deepest limit
+func maxDepth(domainNode *graph.DomainNode) uint {
+	if len(domainNode.Sources) == 0 {
+		return config.maxDepth
+	}
+	max := -1
+	for _, source := range domainNode.Sources {
+		depth := -1
+		info := driver.GetInfo(source)
+		if info.CT {
+			depth = config.maxDepthCT
+		} else if info.Live {
+			depth = config.maxDepthHTTP
+		}
+		if depth < 0 {
+			depth = int(config.maxDepth)
+		}
+		if depth > max {
+			max = depth
+		}
+	}
+	return uint(max)
+}
+
+// visit visits each node and get and set its neighbors
+func visit(ctx context.Context, domainNode *graph.DomainNode) {
+	// check NS if necessary
+	if config.checkDNS {
+		_, err := domainNode.CheckForDNS(config.timeout)
+		if err != nil {
+			v("CheckForNS", err)
+		}
+	}
+
+	// resolve the domain's IP addresses
+	if config.resolveIPs {
+		_, err := domainNode.ResolveIPs(config.timeout)
+		if err != nil {
+			v("ResolveIPs", domainNode.Domain, err)
+		}
+	}
+
+	// add MX hosts as related domains
+	if config.mx {
+		hosts, err := dns.LookupMX(domainNode.Domain, config.timeout)
+		if err != nil {
+			v("LookupMX", domainNode.Domain, err)
+		}
+		mxStatuses := make(status.Map)
+		for _, host := range hosts {
+			if host != domainNode.Domain {
+				mxStatuses.Set(host, status.New(status.MX))
+			}
+		}
+		domainNode.AddStatusMap(mxStatuses)
+	}
+
+	// add SRV targets as related domains
+	if len(config.srvServices) > 0 {
+		targets, err := dns.LookupSRVTargets(domainNode.Domain, config.srvServices, config.timeout)
+		if err != nil {
+			v("LookupSRVTargets", domainNode.Domain, err)
+		}
+		srvStatuses := make(status.Map)
+		for _, target := range targets {
+			if target != domainNode.Domain {
+				srvStatuses.Set(target, status.New(status.SRV))
+			}
+		}
+		domainNode.AddStatusMap(srvStatuses)
+	}
+
+	// perform cert search
+	// TODO do pagination in multiple threads to not block on long searches
+	results, err := certDriver.QueryDomain(ctx, domainNode.Domain)
+	if ctx.Err() != nil {
+		// the crawl was cancelled, the domain was not queried
+		return
+	}
+	if err != nil {
+		// this is VERY common to error, usually this is a DNS or tcp connection related issue
 		// we will skip the domain if we can't query it
 		v("QueryDomain", domainNode.Domain, err)
+		failures.add(domainNode.Domain, err)
 		return
 	}
 	statuses := results.GetStatus()
@@ -371,9 +1753,16 @@ func visit(domainNode *graph.DomainNode) {
 	relatedDomains, err := results.GetRelated()
 	if err != nil {
 		v("GetRelated", domainNode.Domain, err)
+		failures.add(domainNode.Domain, err)
 		return
 	}
 	domainNode.AddRelatedDomains(relatedDomains)
+	// record which of the combined drivers found each related domain
+	if _, ok := results.(driver.SourceResult); ok {
+		for _, related := range relatedDomains {
+			domainNode.AddRelatedSources(related, driver.RelatedSources(results, certDriver.GetName(), related)...)
+		}
+	}
 
 	// TODO parallelize this
 	// TODO fix printing domains as they are found with new driver
@@ -381,40 +1770,211 @@ func visit(domainNode *graph.DomainNode) {
 	fingerprintMap, err := results.GetFingerprints()
 	if err != nil {
 		v("GetFingerprints", err)
+		failures.add(domainNode.Domain, err)
 		return
 	}
 
 	// fingerprints for the domain queried
 	fingerprints := fingerprintMap[domainNode.Domain]
-	for _, fp := range fingerprints {
+	if len(fingerprints) > 0 {
+		atomic.AddInt64(&domainsWithCerts, 1)
+	} else if found := atomic.LoadInt64(&domainsWithCerts); found > 0 && (config.verbose || config.printStats) {
+		e(fmt.Sprintf("warning: %s returned no certificates for %s after returning certificates for %d other domains, the driver may be failing", certDriver.GetName(), domainNode.Domain, found))
+	}
+	if config.deterministic {
+		fingerprints = sortedFingerprints(fingerprints)
+	}
+	if config.recentCerts > 0 && uint(len(fingerprints)) > config.recentCerts {
+		fingerprints = recentFingerprints(results, fingerprints)[:config.recentCerts]
+	}
+	// get cert details in parallel
+	// domains with many certificates share -cert-parallel workers instead of starting one for each certificate
+	certNodes := make([]*graph.CertNode, len(fingerprints))
+	parallelFor(len(fingerprints), config.certParallel, func(i int) {
+		fp := fingerprints[i]
 		// add certNode to graph
 		certNode, exists := certGraph.GetCert(fp)
 		if !exists {
+			// wait for pass
+			<-certThreadPass
+			defer func() { certThreadPass <- true }()
+
+			// stop processing new certificates once -max-certs is reached
+			if certLimitReached() {
+				return
+			}
+
 			// get cert details
-			certResult, err := results.QueryCert(fp)
+			certResult, err := results.QueryCert(ctx, fp)
+			if errors.Is(err, filter.ErrFiltered) {
+				v("certificate does not match the filters, skipping:", fp.HexString())
+				return
+			}
 			if err != nil {
 				v("QueryCert", err)
-				continue
+				return
 			}
 
-			certNode = certNodeFromCertResult(certResult)
-			certGraph.AddCert(certNode)
+			var added bool
+			certNode, added = certGraph.AddCertLimit(certNodeFromCertResult(certResult), int(config.maxCerts))
+			if !added {
+				return
+			}
+			addIssuers(ctx, results, certResult)
 		}
+		certNodes[i] = certNode
+	})
 
-		certNode.AddFound(certDriver.GetName())
-		domainNode.AddCertFingerprint(certNode.Fingerprint, certDriver.GetName())
+	for _, certNode := range certNodes {
+		if certNode == nil {
+			continue
+		}
+		// record the drivers that found the certificate instead of the combined driver so per driver depths apply
+		for _, source := range driver.CertSources(results, certDriver.GetName(), domainNode.Domain, certNode.Fingerprint) {
+			certNode.AddFound(source)
+			domainNode.AddCertFingerprint(certNode.Fingerprint, source)
+		}
 	}
 
 	// we don't process any other certificates returned, they will be collected
 	//  when we process the related domains
 }
 
+// addIssuers adds the chain of certificates issuing the certificate to the graph
+// only drivers returning the chain (http with -chain) set the certificate's Issuer
+// issuers count towards -max-certs, the chain stops once it is reached
+func addIssuers(ctx context.Context, results driver.Result, certResult *driver.CertResult) {
+	for fp := certResult.Issuer; fp != (fingerprint.Fingerprint{}); {
+		if _, exists := certGraph.GetCert(fp); exists {
+			return
+		}
+		issuer, err := results.QueryCert(ctx, fp)
+		if err != nil {
+			v("QueryCert", err)
+			return
+		}
+		issuerNode := certNodeFromCertResult(issuer)
+		issuerNode.Intermediate = true
+		issuerNode, added := certGraph.AddCertLimit(issuerNode, int(config.maxCerts))
+		if !added {
+			return
+		}
+		issuerNode.AddFound(certDriver.GetName())
+		fp = issuer.Issuer
+	}
+}
+
+// recentFingerprints returns the fingerprints sorted by the certificate's NotBefore date, newest first
+// dates are taken from the graph or from the result when the driver returns them with the domain's fingerprints
+// certificates are not queried for their dates, ones with an unknown date are sorted last
+func recentFingerprints(results driver.Result, fingerprints []fingerprint.Fingerprint) []fingerprint.Fingerprint {
+	notBefore := make(map[fingerprint.Fingerprint]time.Time, len(fingerprints))
+	validityResult, hasValidity := results.(driver.ValidityResult)
+	for _, fp := range fingerprints {
+		if certNode, exists := certGraph.GetCert(fp); exists {
+			notBefore[fp] = certNode.NotBefore
+			continue
+		}
+		if hasValidity {
+			if t, found := validityResult.GetNotBefore(fp); found {
+				notBefore[fp] = t
+			}
+		}
+	}
+	sorted := make([]fingerprint.Fingerprint, len(fingerprints))
+	copy(sorted, fingerprints)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return notBefore[sorted[i]].After(notBefore[sorted[j]])
+	})
+	return sorted
+}
+
+// sortedFingerprints returns a sorted copy of the fingerprints
+func sortedFingerprints(fingerprints []fingerprint.Fingerprint) []fingerprint.Fingerprint {
+	sorted := make([]fingerprint.Fingerprint, len(fingerprints))
+	copy(sorted, fingerprints)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	return sorted
+}
+
+// listOutput returns true if domains are printed as a list as they are found
+func listOutput() bool {
+	return !config.printJSON && !config.jsonStream && !config.printSTIX && !config.printEdgeList && !config.printGraphML && !config.printDOT && !config.tldSummary && !config.depthHistogram && !config.scc && !config.hash
+}
+
+// printEdges prints the domain's links as they would appear in the json graph
+// in the form "source\ttarget\ttype" skipping any in printedEdges
+func printEdges(domainNode *graph.DomainNode, printedEdges map[string]bool) {
+	for _, link := range certGraph.GetDomainLinks(domainNode.Domain) {
+		edge := fmt.Sprintf("%s\t%s\t%s", link["source"], link["target"], link["type"])
+		if !printedEdges[edge] {
+			printedEdges[edge] = true
+			fmt.Fprintln(os.Stdout, edge)
+		}
+	}
+}
+
+// skipPrint returns true if the domain should not be printed because of -no-seeds or -only-valid
+func skipPrint(domainNode *graph.DomainNode) bool {
+	if config.noSeeds && domainNode.Root {
+		return true
+	}
+	if config.onlyValid && !certGraph.HasValidCert(domainNode) {
+		v("no valid certificates, not printing:", domainNode.Domain)
+		return true
+	}
+	return false
+}
+
+// nodeEvent is a visited domain printed as a single line by -json-stream
+type nodeEvent struct {
+	Domain  string   `json:"domain"`
+	Depth   uint     `json:"depth"`
+	Status  string   `json:"status"`
+	Related []string `json:"related"`
+	Certs   []string `json:"certs"`
+}
+
+// printNodeJSON prints the domain as a single line json object
+func printNodeJSON(domainNode *graph.DomainNode) {
+	if skipPrint(domainNode) {
+		return
+	}
+	event := nodeEvent{
+		Domain:  graph.OutputDomain(domainNode.Domain),
+		Depth:   domainNode.Depth,
+		Status:  domainNode.Status.String(),
+		Related: make([]string, 0, len(domainNode.RelatedDomains)),
+		Certs:   make([]string, 0, len(domainNode.Certs)),
+	}
+	for _, related := range domainNode.GetRelatedDomains() {
+		event.Related = append(event.Related, graph.OutputDomain(related))
+	}
+	for _, fp := range sortedFingerprints(domainNode.GetCertificates()) {
+		event.Certs = append(event.Certs, fp.HexString())
+	}
+	j, err := json.Marshal(event)
+	if err != nil {
+		e(err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(j))
+}
+
 func printNode(domainNode *graph.DomainNode) {
+	if skipPrint(domainNode) {
+		return
+	}
+	line := graph.OutputDomain(domainNode.Domain)
 	if config.details {
-		fmt.Fprintln(os.Stdout, domainNode)
-	} else {
-		fmt.Fprintln(os.Stdout, domainNode.Domain)
+		line = domainNode.String()
+	}
+	if config.counts {
+		line = fmt.Sprintf("%s\t%d", line, len(domainNode.Certs))
 	}
+	fmt.Fprintln(os.Stdout, line)
 	if config.checkDNS && !domainNode.HasDNS {
 		// TODO print this in a better way
 		// TODO for debugging
@@ -424,11 +1984,449 @@ func printNode(domainNode *graph.DomainNode) {
 	}
 }
 
+// parseIssuedSince returns the time described by a YYYY-MM-DD date,
+// or a duration before now such as 12h or a number of days such as 7d
+func parseIssuedSince(s string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", s); err == nil {
+		return date, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32)
+		if err == nil {
+			return time.Now().AddDate(0, 0, -int(days)), nil
+		}
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -issued-since %q, must be a date (YYYY-MM-DD) or duration", s)
+	}
+	return time.Now().Add(-duration), nil
+}
+
+// checkpointInterval is how often the -checkpoint file is written during a crawl
+const checkpointInterval = 30 * time.Second
+
+// crawlCheckpoint records the crawl's progress for -checkpoint, nil if disabled
+var crawlCheckpoint *checkpoint
+
+// checkpoint is a thread safe record of the visited domains and the queued domains not yet visited
+// the graph of the visited domains is saved with it so a resumed crawl outputs the whole graph
+// its methods do nothing on a nil checkpoint
+type checkpoint struct {
+	sync.Mutex
+	file    string
+	visited map[string]bool
+	pending map[string]checkpointNode
+	domains []*graph.DomainNode // saved domains not yet restored to the graph
+	certs   []checkpointCert    // saved certificates not yet restored to the graph
+}
+
+// checkpointNode is a queued domain in the checkpoint file
+type checkpointNode struct {
+	Domain  string   `json:"domain"`
+	Depth   uint     `json:"depth"`
+	Root    bool     `json:"root,omitempty"`
+	Sources []string `json:"sources,omitempty"`
+	Parents []string `json:"parents,omitempty"`
+}
+
+// checkpointCert is a certificate in the checkpoint file with the drivers that found it
+type checkpointCert struct {
+	*graph.CertNode
+	Found []string `json:"found,omitempty"`
+}
+
+// checkpointFile is the json saved by -checkpoint
+type checkpointFile struct {
+	Visited []string            `json:"visited"`
+	Pending []checkpointNode    `json:"pending"`
+	Domains []*graph.DomainNode `json:"domains,omitempty"` // nodes of the visited domains
+	Certs   []checkpointCert    `json:"certs,omitempty"`
+}
+
+// readCheckpoint returns the checkpoint saved in file, or an empty checkpoint if file does not exist
+func readCheckpoint(file string) (*checkpoint, error) {
+	c := &checkpoint{
+		file:    file,
+		visited: make(map[string]bool),
+		pending: make(map[string]checkpointNode),
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved checkpointFile
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", file, err)
+	}
+	for _, domain := range saved.Visited {
+		c.visited[domain] = true
+	}
+	for _, node := range saved.Pending {
+		c.pending[node.Domain] = node
+	}
+	c.domains = saved.Domains
+	c.certs = saved.Certs
+	return c, nil
+}
+
+// restoreGraph adds the domains and certificates found before the crawl was resumed to g
+func (c *checkpoint) restoreGraph(g *graph.CertGraph) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, cert := range c.certs {
+		if cert.CertNode == nil {
+			continue
+		}
+		for _, found := range cert.Found {
+			cert.AddFound(found)
+		}
+		g.AddCert(cert.CertNode)
+	}
+	for _, n := range c.domains {
+		// nodes saved before they were visited are queued again instead
+		if n == nil || !c.visited[n.Domain] {
+			continue
+		}
+		if n.Certs == nil {
+			n.Certs = make(map[fingerprint.Fingerprint][]string)
+		}
+		if n.RelatedDomains == nil {
+			n.RelatedDomains = make(status.Map)
+		}
+		if n.Ports == nil {
+			n.Ports = make(status.Map)
+		}
+		g.AddDomain(n)
+	}
+	c.domains, c.certs = nil, nil
+}
+
+// resumeLevel returns the queued domains of the checkpoint to continue the crawl from, lowest depth first
+func (c *checkpoint) resumeLevel() []*graph.DomainNode {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	level := make([]*graph.DomainNode, 0, len(c.pending))
+	for _, node := range c.pending {
+		n := graph.NewDomainNode(node.Domain, node.Depth)
+		n.Root = node.Root
+		n.Sources = node.Sources
+		n.AddParents(node.Parents...)
+		level = append(level, n)
+	}
+	sort.SliceStable(level, func(i, j int) bool {
+		if level[i].Depth != level[j].Depth {
+			return level[i].Depth < level[j].Depth
+		}
+		return level[i].Domain < level[j].Domain
+	})
+	return level
+}
+
+// queue records the domains as waiting to be visited
+func (c *checkpoint) queue(domainNodes ...*graph.DomainNode) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range domainNodes {
+		if c.visited[n.Domain] {
+			continue
+		}
+		if existing, found := c.pending[n.Domain]; found && existing.Depth <= n.Depth {
+			continue
+		}
+		c.pending[n.Domain] = checkpointNode{Domain: n.Domain, Depth: n.Depth, Root: n.Root, Sources: n.Sources, Parents: n.Parents}
+	}
+}
+
+// done marks the domain as visited
+func (c *checkpoint) done(domain string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.visited[domain] = true
+	delete(c.pending, domain)
+}
+
+// isVisited returns true if the domain was visited by this or a previous crawl
+func (c *checkpoint) isVisited(domain string) bool {
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.visited[domain]
+}
+
+// write atomically replaces the checkpoint file with the current progress
+func (c *checkpoint) write() error {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	saved := checkpointFile{
+		Visited: make([]string, 0, len(c.visited)),
+		Pending: make([]checkpointNode, 0, len(c.pending)),
+	}
+	for domain := range c.visited {
+		saved.Visited = append(saved.Visited, domain)
+	}
+	for _, node := range c.pending {
+		saved.Pending = append(saved.Pending, node)
+	}
+	c.Unlock()
+	sort.Strings(saved.Visited)
+	sort.Slice(saved.Pending, func(i, j int) bool {
+		return saved.Pending[i].Domain < saved.Pending[j].Domain
+	})
+	// only the visited domains are saved, their nodes are no longer modified by the crawl
+	for _, domain := range saved.Visited {
+		if domainNode, found := certGraph.GetDomain(domain); found {
+			saved.Domains = append(saved.Domains, domainNode)
+		}
+	}
+	for _, certNode := range certGraph.GetCerts() {
+		saved.Certs = append(saved.Certs, checkpointCert{CertNode: certNode, Found: certNode.Found()})
+	}
+	sort.Slice(saved.Certs, func(i, j int) bool {
+		return bytes.Compare(saved.Certs[i].Fingerprint[:], saved.Certs[j].Fingerprint[:]) < 0
+	})
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file in the same folder and rename it over the checkpoint so it is never partially written
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// failureList is a thread safe record of the domains that failed and the category of their error
+type failureList struct {
+	sync.Mutex
+	categories map[string]string
+	drivers    map[string]int // number of failed domains for each driver
+}
+
+// add records that domain failed with err, the first error for a domain is kept
+func (f *failureList) add(domain string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, found := f.categories[domain]; !found {
+		f.categories[domain] = status.CheckNetErr(err).String()
+		f.drivers[certDriver.GetName()]++
+	}
+}
+
+// domains returns a copy of the failed domains and their error categories
+func (f *failureList) domains() map[string]string {
+	f.Lock()
+	defer f.Unlock()
+	domains := make(map[string]string, len(f.categories))
+	for domain, category := range f.categories {
+		domains[domain] = category
+	}
+	return domains
+}
+
+// driverErrors returns a copy of the number of failed domains for each driver
+func (f *failureList) driverErrors() map[string]int {
+	f.Lock()
+	defer f.Unlock()
+	drivers := make(map[string]int, len(f.drivers))
+	for name, count := range f.drivers {
+		drivers[name] = count
+	}
+	return drivers
+}
+
+// writeFile writes the failed domains to file as "domain\terror" lines sorted by domain
+func (f *failureList) writeFile(file string) error {
+	f.Lock()
+	defer f.Unlock()
+	domains := make([]string, 0, len(f.categories))
+	for domain := range f.categories {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	var buf bytes.Buffer
+	for _, domain := range domains {
+		fmt.Fprintf(&buf, "%s\t%s\n", domain, f.categories[domain])
+	}
+	return os.WriteFile(file, buf.Bytes(), 0666)
+}
+
+// regexList is a flag.Value holding every regex passed to a repeated flag
+type regexList []*regexp.Regexp
+
+func (r *regexList) String() string {
+	return strings.Join(r.patterns(), " ")
+}
+
+// Set compiles and adds the regex to the list
+func (r *regexList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, re)
+	return nil
+}
+
+// MatchString returns true if s matches any regex in the list
+func (r regexList) MatchString(s string) bool {
+	for _, re := range r {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r regexList) patterns() []string {
+	patterns := make([]string, 0, len(r))
+	for _, re := range r {
+		patterns = append(patterns, re.String())
+	}
+	return patterns
+}
+
+// globList is a flag.Value holding every shell glob passed to a repeated flag
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, " ")
+}
+
+// Set validates and adds the glob to the list
+func (g *globList) Set(value string) error {
+	_, err := path.Match(value, "")
+	if err != nil {
+		return fmt.Errorf("invalid glob %q: %w", value, err)
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// Match returns true if s matches any glob in the list
+func (g globList) Match(s string) bool {
+	for _, pattern := range g {
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// headerList is a flag.Value holding every http header passed to a repeated flag
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set validates and adds the header to the list
+func (h *headerList) Set(value string) error {
+	_, _, err := http.ParseHeader(value)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// keyAlgoMatch returns true if the certificate's public key matches the -key-algo filter
+// the filter is an algorithm optionally followed by a size, ex: RSA or RSA-1024
+func keyAlgoMatch(certResult *driver.CertResult) bool {
+	parts := strings.SplitN(config.keyAlgo, "-", 2)
+	if !strings.EqualFold(parts[0], certResult.KeyAlgorithm) {
+		return false
+	}
+	if len(parts) == 2 && parts[1] != strconv.Itoa(certResult.KeySize) {
+		return false
+	}
+	return true
+}
+
+// issuerMatch returns true if the certificate's issuer common name or an organization matches -issuer
+// and none match -exclude-issuer, certificates with an unknown issuer never match -issuer
+func issuerMatch(certResult *driver.CertResult) bool {
+	names := append([]string{certResult.IssuerCN}, certResult.IssuerOrgs...)
+	included := len(config.issuer) == 0
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		if config.excludeIssuer.MatchString(name) {
+			return false
+		}
+		if config.issuer.MatchString(name) {
+			included = true
+		}
+	}
+	return included
+}
+
 // certNodeFromCertResult convert certResult to certNode
 func certNodeFromCertResult(certResult *driver.CertResult) *graph.CertNode {
+	domains := certResult.Domains
+	if config.strictHostnames {
+		domains = make([]string, 0, len(certResult.Domains))
+		for _, domain := range certResult.Domains {
+			if !dns.ValidHostnameLength(domain) {
+				v("domain exceeds DNS length limits, dropping:", domain)
+				continue
+			}
+			domains = append(domains, domain)
+		}
+	}
 	certNode := &graph.CertNode{
-		Fingerprint: certResult.Fingerprint,
-		Domains:     certResult.Domains,
+		Fingerprint:    certResult.Fingerprint,
+		Domains:        domains,
+		NotBefore:      certResult.NotBefore,
+		NotAfter:       certResult.NotAfter,
+		KeyAlgorithm:   certResult.KeyAlgorithm,
+		KeySize:        certResult.KeySize,
+		SignatureAlgo:  certResult.SignatureAlgo,
+		Tags:           certResult.Tags,
+		Precert:        certResult.Precert,
+		AuthorityKeyID: certResult.AuthorityKeyID,
+		SubjectKeyID:   certResult.SubjectKeyID,
+		Issuer:         certResult.Issuer,
+		IssuerCN:       certResult.IssuerCN,
+		IssuerOrgs:     certResult.IssuerOrgs,
+	}
+	// organizations are only added to the graph when requested to create organization nodes
+	if config.orgNodes {
+		certNode.Organizations = certResult.Organizations
+	}
+	if config.reportDupSANs {
+		certNode.DuplicateSANs = certResult.DuplicateSANs
 	}
 	return certNode
 }
@@ -441,15 +2439,56 @@ func generateGraphMetadata() map[string]interface{} {
 	data["website"] = "https://lanrat.github.io/certgraph/"
 	data["scan_date"] = time.Now().UTC()
 	data["command"] = strings.Join(os.Args, " ")
+	data["seeds"] = seedDomains()
 	options := make(map[string]interface{})
 	options["parallel"] = config.parallel
+	options["cert_parallel"] = config.certParallel
+	options["priority"] = config.priority
+	options["deterministic"] = config.deterministic
 	options["driver"] = config.driver
+	options["import_csv"] = config.importCSV
+	options["import_crawl"] = config.importCrawl
+	options["sni_list"] = config.sniList
 	options["ct_subdomains"] = config.includeCTSubdomains
 	options["ct_expired"] = config.includeCTExpired
+	options["ct_no_cn"] = config.ctNoCN
+	options["max_cert_sans"] = config.maxCertSANs
+	options["ct_parallel"] = config.ctParallel
+	options["rate"] = config.rate
+	options["as_of"] = asOfString
+	options["issued_since"] = issuedString
 	options["sanscap"] = config.maxSANsSize
+	options["key_algo"] = config.keyAlgo
+	options["recent_certs"] = config.recentCerts
 	options["cdn"] = config.cdn
+	options["org_nodes"] = config.orgNodes
+	options["link_akid"] = config.linkAKID
+	options["chain"] = config.chain
+	options["link_hints"] = config.linkHints
+	options["respect_robots"] = config.respectRobots
+	options["no_related_expansion"] = config.noRelatedExpansion
+	options["report_dup_sans"] = config.reportDupSANs
+	options["strict_hostnames"] = config.strictHostnames
 	options["timeout"] = config.timeout
-	options["regex"] = regexString
+	options["depth"] = config.maxDepth
+	options["depth_ct"] = config.maxDepthCT
+	options["depth_http"] = config.maxDepthHTTP
+	options["depth_delay"] = config.depthDelay
+	options["stall_timeout"] = config.stallTimeout
+	options["checkpoint"] = config.checkpoint
+	options["max_certs"] = config.maxCerts
+	options["max_domains"] = config.maxDomains
+	options["no_seeds"] = config.noSeeds
+	options["regex"] = config.regex.patterns()
+	options["issuer"] = config.issuer.patterns()
+	options["exclude_issuer"] = config.excludeIssuer.patterns()
+	options["glob"] = config.glob
+	options["srv"] = srvString
+	options["ports"] = portsString
+	options["scope"] = scopeString
+	options["mx"] = config.mx
+	options["serve_scan"] = config.serveScan
+	options["resolve_ips"] = config.resolveIPs
 	data["options"] = options
 	return data
 }
@@ -459,18 +2498,159 @@ func version() string {
 	return fmt.Sprintf("Git commit: %s [%s]", gitDate, gitHash)
 }
 
+// checkWritable returns an error if a file can not be created in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".certgraph-write-test-")
+	if err != nil {
+		return fmt.Errorf("save path %q is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// parsePorts parses a comma separated list of TCP ports, removing duplicates
+func parsePorts(s string) ([]string, error) {
+	ports := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, port := range strings.Split(s, ",") {
+		port = strings.TrimSpace(port)
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port %q in -ports", port)
+		}
+		port = strconv.FormatUint(n, 10)
+		if seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// apexScope holds the apex domains the crawl is limited to, an empty scope contains every domain
+type apexScope map[string]bool
+
+// contains returns true if the scope is empty or has the apex domain of domain
+func (s apexScope) contains(domain string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	apex, err := dns.ApexDomain(domain)
+	return err == nil && s[apex]
+}
+
+// parseScope parses a comma separated list of domains into the set of their apex domains
+func parseScope(str string) (apexScope, error) {
+	scope := make(apexScope)
+	for _, domain := range strings.Split(str, ",") {
+		domain = graph.NormalizeDomain(strings.TrimSpace(domain))
+		apex, err := dns.ApexDomain(domain)
+		if err != nil || len(apex) == 0 {
+			return nil, fmt.Errorf("invalid domain %q in -scope", domain)
+		}
+		scope[apex] = true
+	}
+	return scope, nil
+}
+
+// readSNIList reads a file of "ip,sni" or "ip sni" lines and returns the IP for each SNI hostname
+// blank lines and lines starting with # are ignored
+func readSNIList(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"ip,sni\", got %q", file, i+1, line)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d: invalid IP address %q", file, i+1, fields[0])
+		}
+		sni := cleanInput(strings.ToLower(fields[1]))
+		if existing, found := addresses[sni]; found && existing != ip.String() {
+			return nil, fmt.Errorf("%s:%d: %s is already mapped to %s", file, i+1, sni, existing)
+		}
+		addresses[sni] = ip.String()
+	}
+	return addresses, nil
+}
+
+// readDomainsFile returns the domains in file, one per line, skipping blank lines and # comments
+// a file of "-" is read from stdin
+func readDomainsFile(file string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
+
+// splitInputPort returns the host and port of a start domain in the form host:port, [ipv6]:port, or a url with a port
+// the port is empty if the input does not have one
+func splitInputPort(input string) (string, string) {
+	if strings.Contains(input, "://") {
+		u, err := url.Parse(input)
+		if err != nil || len(u.Hostname()) == 0 {
+			return input, ""
+		}
+		return u.Hostname(), u.Port()
+	}
+	host, port, err := net.SplitHostPort(input)
+	if err == nil && len(host) > 0 {
+		if _, err := strconv.ParseUint(port, 10, 16); err == nil {
+			return host, port
+		}
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(input, "["), "]"), ""
+}
+
+// addSeedPort adds port to the ports queried for the start domain host
+func addSeedPort(host, port string) {
+	for _, p := range seedPorts[host] {
+		if p == port {
+			return
+		}
+	}
+	seedPorts[host] = append(seedPorts[host], port)
+}
+
 // cleanInput attempts to parse the input string as a url to extract the hostname
 // if it fails, then the input string is returned
-// also removes tailing '.'
+// also removes tailing '.' and normalizes internationalized domains to punycode
 func cleanInput(host string) string {
 	host = strings.TrimSuffix(host, ".")
 	u, err := url.Parse(host)
 	if err != nil {
-		return host
+		return graph.NormalizeDomain(host)
 	}
 	hostname := u.Hostname()
 	if hostname == "" {
-		return host
+		return graph.NormalizeDomain(host)
 	}
-	return hostname
+	return graph.NormalizeDomain(hostname)
 }
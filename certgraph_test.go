@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/driver/multi"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/graph"
+	"github.com/lanrat/certgraph/status"
+)
+
+func TestRegexListMatchAny(t *testing.T) {
+	var regexes regexList
+	for _, pattern := range []string{`\.example\.com$`, `^mail\.`} {
+		if err := regexes.Set(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]bool{
+		"www.example.com":  true,
+		"mail.example.org": true,
+		"www.example.org":  false,
+	}
+	for domain, match := range tests {
+		if regexes.MatchString(domain) != match {
+			t.Errorf("MatchString(%q) = %t, expected %t", domain, !match, match)
+		}
+	}
+	if err := regexes.Set("("); err == nil {
+		t.Errorf("expected invalid regex to return an error")
+	}
+}
+
+func TestSplitInputPort(t *testing.T) {
+	tests := map[string][2]string{
+		"example.com":               {"example.com", ""},
+		"example.com:8443":          {"example.com", "8443"},
+		"https://example.com:8443/": {"example.com", "8443"},
+		"https://example.com/":      {"example.com", ""},
+		"[2001:db8::1]:8443":        {"2001:db8::1", "8443"},
+		"[2001:db8::1]":             {"2001:db8::1", ""},
+		"2001:db8::1":               {"2001:db8::1", ""},
+		"example.com:https":         {"example.com:https", ""},
+	}
+	for input, expected := range tests {
+		host, port := splitInputPort(input)
+		if host != expected[0] || port != expected[1] {
+			t.Errorf("splitInputPort(%q) = %q, %q, expected %q, %q", input, host, port, expected[0], expected[1])
+		}
+	}
+}
+
+func TestGlobListMatchAny(t *testing.T) {
+	var globs globList
+	for _, pattern := range []string{"*.corp.example.com", "mail.*"} {
+		if err := globs.Set(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]bool{
+		"www.corp.example.com": true,
+		"mail.example.org":     true,
+		"corp.example.com":     false,
+	}
+	for domain, match := range tests {
+		if globs.Match(domain) != match {
+			t.Errorf("Match(%q) = %t, expected %t", domain, !match, match)
+		}
+	}
+	if err := globs.Set("["); err == nil {
+		t.Errorf("expected invalid glob to return an error")
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	oldGraph := certGraph
+	defer func() {
+		certGraph = oldGraph
+	}()
+	certGraph = graph.NewCertGraph()
+
+	file := filepath.Join(t.TempDir(), "checkpoint.json")
+	c, err := readCheckpoint(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := graph.NewDomainNode("example.com", 0)
+	root.Root = true
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	root.AddCertFingerprint(fp, "http")
+	certGraph.AddDomain(root)
+	certNode := certGraph.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{"example.com", "www.example.com"}})
+	certNode.AddFound("http")
+	// queued but not visited, so it is not restored
+	certGraph.AddDomain(graph.NewDomainNode("www.example.com", 1))
+	c.queue(root)
+	c.queue(graph.NewDomainNode("www.example.com", 1), graph.NewDomainNode("mail.example.com", 1))
+	c.done("example.com")
+	c.done("mail.example.com")
+	if err := c.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := readCheckpoint(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.isVisited("example.com") || !resumed.isVisited("mail.example.com") {
+		t.Error("expected visited domains to be restored")
+	}
+	level := resumed.resumeLevel()
+	if len(level) != 1 || level[0].Domain != "www.example.com" || level[0].Depth != 1 {
+		t.Errorf("expected to resume from www.example.com at depth 1, got %v", level)
+	}
+
+	g := graph.NewCertGraph()
+	resumed.restoreGraph(g)
+	if g.NumDomains() != 1 || g.NumCerts() != 1 {
+		t.Fatalf("expected 1 domain and 1 certificate to be restored, got %d and %d", g.NumDomains(), g.NumCerts())
+	}
+	restoredRoot, found := g.GetDomain("example.com")
+	if !found || !restoredRoot.Root || !reflect.DeepEqual(restoredRoot.Certs[fp], []string{"http"}) {
+		t.Errorf("unexpected restored domain %v", restoredRoot)
+	}
+	restoredCert, found := g.GetCert(fp)
+	if !found || !reflect.DeepEqual(restoredCert.Domains, certNode.Domains) || !reflect.DeepEqual(restoredCert.Found(), []string{"http"}) {
+		t.Errorf("unexpected restored certificate %v", restoredCert)
+	}
+}
+
+func TestParallelForBounded(t *testing.T) {
+	const n, workers = 10000, 4
+	base := runtime.NumGoroutine()
+	var mu sync.Mutex
+	peak := 0
+	seen := make([]int32, n)
+	parallelFor(n, workers, func(i int) {
+		atomic.AddInt32(&seen[i], 1)
+		mu.Lock()
+		defer mu.Unlock()
+		if g := runtime.NumGoroutine(); g > peak {
+			peak = g
+		}
+	})
+	if peak > base+workers {
+		t.Errorf("expected at most %d goroutines for %d items, got %d", base+workers, n, peak)
+	}
+	for i, count := range seen {
+		if count != 1 {
+			t.Fatalf("expected index %d to be visited once, got %d", i, count)
+		}
+	}
+}
+
+func TestApexScope(t *testing.T) {
+	scope, err := parseScope("example.com, www.example.co.uk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"example.com":            true,
+		"mail.example.com":       true,
+		"example.co.uk":          true,
+		"www.example.org":        false,
+		"notexample.com":         false,
+		"example.com.attack.net": false,
+	}
+	for domain, in := range tests {
+		if scope.contains(domain) != in {
+			t.Errorf("%s: expected in scope %t", domain, in)
+		}
+	}
+	if !apexScope(nil).contains("www.example.org") {
+		t.Errorf("expected an empty scope to contain every domain")
+	}
+	if _, err := parseScope("example.com,,"); err == nil {
+		t.Errorf("expected an error for an empty domain")
+	}
+}
+
+// hangDriver blocks every query until its context is done
+type hangDriver struct {
+	cancelled chan bool
+}
+
+func (d *hangDriver) GetName() string {
+	return "hang"
+}
+
+func (d *hangDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	<-ctx.Done()
+	d.cancelled <- true
+	return nil, ctx.Err()
+}
+
+func TestStallTimeoutCancels(t *testing.T) {
+	d := &hangDriver{cancelled: make(chan bool, 1)}
+	oldDriver, oldGraph, oldConfig := certDriver, certGraph, config
+	defer func() {
+		certDriver, certGraph, config = oldDriver, oldGraph, oldConfig
+	}()
+	certDriver = d
+	certGraph = graph.NewCertGraph()
+	config.parallel = 1
+	config.stallTimeout = time.Second
+
+	finished := make(chan bool)
+	go func() {
+		breathFirstSearch([]string{"example.com"})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stalled crawl did not return")
+	}
+	select {
+	case <-d.cancelled:
+	default:
+		t.Error("expected the stalled query to be cancelled before returning")
+	}
+}
+
+// fakeCertDriver returns a certificate for the domains in certs
+type fakeCertDriver struct {
+	name  string
+	certs map[string]*driver.CertResult
+}
+
+func (d *fakeCertDriver) GetName() string {
+	return d.name
+}
+
+func (d *fakeCertDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	return &fakeCertResult{host: domain, cert: d.certs[domain]}, nil
+}
+
+type fakeCertResult struct {
+	host string
+	cert *driver.CertResult
+}
+
+func (r *fakeCertResult) GetStatus() status.Map {
+	return status.NewMap(r.host, status.New(status.GOOD))
+}
+
+func (r *fakeCertResult) GetRelated() ([]string, error) {
+	return nil, nil
+}
+
+func (r *fakeCertResult) GetFingerprints() (driver.FingerprintMap, error) {
+	fpm := make(driver.FingerprintMap)
+	if r.cert != nil {
+		fpm.Add(r.host, r.cert.Fingerprint)
+	}
+	return fpm, nil
+}
+
+func (r *fakeCertResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	if r.cert == nil || r.cert.Fingerprint != fp {
+		return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+	}
+	return r.cert, nil
+}
+
+func TestDriverDepthMulti(t *testing.T) {
+	oldDriver, oldGraph, oldConfig, oldPass := certDriver, certGraph, config, certThreadPass
+	defer func() {
+		certDriver, certGraph, config, certThreadPass = oldDriver, oldGraph, oldConfig, oldPass
+	}()
+	ctCert := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("ct")), Domains: []string{"example.com", "ct.example.com"}}
+	httpCert := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("http")), Domains: []string{"example.com", "http.example.com"}}
+	certDriver = multi.Driver([]driver.Driver{
+		&fakeCertDriver{name: "crtsh", certs: map[string]*driver.CertResult{"example.com": ctCert}},
+		&fakeCertDriver{name: "http", certs: map[string]*driver.CertResult{"example.com": httpCert}},
+	})
+	certGraph = graph.NewCertGraph()
+	config.parallel, config.certParallel = 1, 1
+	certThreadPass = make(chan bool, 1)
+	certThreadPass <- true
+	config.maxDepth, config.maxDepthCT, config.maxDepthHTTP = 5, 0, 1
+
+	breathFirstSearch([]string{"example.com"})
+
+	if _, found := certGraph.GetDomain("ct.example.com"); found {
+		t.Error("expected ct.example.com to be past -depth-ct")
+	}
+	if _, found := certGraph.GetDomain("http.example.com"); !found {
+		t.Error("expected http.example.com to be within -depth-http")
+	}
+	root, _ := certGraph.GetDomain("example.com")
+	if !reflect.DeepEqual(root.Certs[ctCert.Fingerprint], []string{"crtsh"}) || !reflect.DeepEqual(root.Certs[httpCert.Fingerprint], []string{"http"}) {
+		t.Errorf("expected each certificate to record the driver that found it, got %v", root.Certs)
+	}
+}
+
+func TestMaxDepthDriverInfo(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config.maxDepth, config.maxDepthCT, config.maxDepthHTTP = 5, 1, 2
+
+	for _, test := range []struct {
+		sources []string
+		depth   uint
+	}{
+		{nil, 5},
+		{[]string{"certspotter"}, 1},
+		{[]string{"certstream"}, 1},
+		{[]string{"smtp"}, 2},
+		{[]string{"pop3"}, 2},
+		{[]string{"crtsh", "imap"}, 2},
+		{[]string{"crtsh", "unknown"}, 5},
+	} {
+		domainNode := graph.NewDomainNode("example.com", 0)
+		domainNode.Sources = test.sources
+		if depth := maxDepth(domainNode); depth != test.depth {
+			t.Errorf("expected depth %d for %v, got %d", test.depth, test.sources, depth)
+		}
+	}
+}
+
+func TestCertTimeline(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config.certParallel = 1
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("newer")), NotBefore: day.AddDate(0, 0, 2)}
+	older := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("older")), NotBefore: day}
+	sameA := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("same a")), NotBefore: day.AddDate(0, 0, 1)}
+	sameB := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("same b")), NotBefore: day.AddDate(0, 0, 1)}
+	drivers := make([]driver.Driver, 0, 4)
+	for _, cert := range []*driver.CertResult{newer, sameB, older, sameA} {
+		drivers = append(drivers, &fakeCertDriver{name: cert.Fingerprint.HexString(), certs: map[string]*driver.CertResult{"example.com": cert}})
+	}
+
+	timeline, err := certTimeline(context.Background(), multi.Driver(drivers), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := []*driver.CertResult{sameA, sameB}
+	if sameB.Fingerprint.HexString() < sameA.Fingerprint.HexString() {
+		same = []*driver.CertResult{sameB, sameA}
+	}
+	expected := []*driver.CertResult{older, same[0], same[1], newer}
+	if !reflect.DeepEqual(timeline, expected) {
+		t.Errorf("expected certificates sorted by NotBefore then fingerprint, got %v", timeline)
+	}
+}
+
+func TestTimelineIssuer(t *testing.T) {
+	tests := []struct {
+		cert     driver.CertResult
+		expected string
+	}{
+		{driver.CertResult{}, "unknown"},
+		{driver.CertResult{IssuerCN: "R3"}, "R3"},
+		{driver.CertResult{IssuerCN: "R3", IssuerOrgs: []string{"Let's Encrypt"}}, "R3 (Let's Encrypt)"},
+		{driver.CertResult{IssuerOrgs: []string{"Example", "Example Inc"}}, "(Example, Example Inc)"},
+	}
+	for _, test := range tests {
+		if issuer := timelineIssuer(&test.cert); issuer != test.expected {
+			t.Errorf("timelineIssuer(%+v) = %q, expected %q", test.cert, issuer, test.expected)
+		}
+	}
+}
+
+func TestPrintTimelineRequiresCT(t *testing.T) {
+	err := printTimeline("example.com", "crtsh,http")
+	if err == nil {
+		t.Error("expected -timeline to refuse the http driver")
+	}
+}
+
+func TestIssuerMatch(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config.issuer, config.excludeIssuer = nil, nil
+	if err := config.issuer.Set("^R3$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.excludeIssuer.Set("Bad"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cert     driver.CertResult
+		expected bool
+	}{
+		{driver.CertResult{IssuerCN: "R3", IssuerOrgs: []string{"Let's Encrypt"}}, true},
+		{driver.CertResult{IssuerCN: "Other", IssuerOrgs: []string{"R3"}}, true},
+		{driver.CertResult{IssuerCN: "R30"}, false},
+		{driver.CertResult{IssuerCN: "R3", IssuerOrgs: []string{"Bad CA"}}, false},
+		{driver.CertResult{}, false},
+	}
+	for _, test := range tests {
+		if match := issuerMatch(&test.cert); match != test.expected {
+			t.Errorf("issuerMatch(%+v) = %t, expected %t", test.cert, match, test.expected)
+		}
+	}
+
+	config.issuer = nil
+	if !issuerMatch(&driver.CertResult{}) {
+		t.Error("expected an unknown issuer to match without -issuer")
+	}
+}
+
+func TestDefaultDomainScoreLive(t *testing.T) {
+	oldGraph := certGraph
+	defer func() { certGraph = oldGraph }()
+	certGraph = graph.NewCertGraph()
+	for sources, expected := range map[string]float64{
+		"crtsh":       0,
+		"crtsh,imap":  1,
+		"http":        1,
+		"censys,smtp": 1,
+	} {
+		domainNode := graph.NewDomainNode("live.example.org", 1)
+		domainNode.Sources = strings.Split(sources, ",")
+		if score := defaultDomainScore(domainNode); score != expected {
+			t.Errorf("expected a domain found by %s to score %v, got %v", sources, expected, score)
+		}
+	}
+}
+
+// validityResult knows the NotBefore dates of its certificates and fails the test if a certificate is queried
+type validityResult struct {
+	fakeCertResult
+	t         *testing.T
+	notBefore map[fingerprint.Fingerprint]time.Time
+}
+
+func (r *validityResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	t, ok := r.notBefore[fp]
+	return t, ok
+}
+
+func (r *validityResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	r.t.Errorf("expected the NotBefore date of %s without querying the certificate", fp.HexString())
+	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+}
+
+func TestRecentFingerprints(t *testing.T) {
+	oldGraph := certGraph
+	defer func() { certGraph = oldGraph }()
+	certGraph = graph.NewCertGraph()
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := fingerprint.FromRawCertBytes([]byte("old"))
+	inGraph := fingerprint.FromRawCertBytes([]byte("in graph"))
+	newest := fingerprint.FromRawCertBytes([]byte("newest"))
+	unknown := fingerprint.FromRawCertBytes([]byte("unknown"))
+	certGraph.AddCert(&graph.CertNode{Fingerprint: inGraph, NotBefore: day.AddDate(0, 1, 0)})
+	results := &validityResult{t: t, notBefore: map[fingerprint.Fingerprint]time.Time{
+		old:    day,
+		newest: day.AddDate(0, 2, 0),
+	}}
+
+	sorted := recentFingerprints(results, []fingerprint.Fingerprint{unknown, old, inGraph, newest})
+	expected := []fingerprint.Fingerprint{newest, inGraph, old, unknown}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("expected fingerprints sorted newest first with unknown dates last, got %v", sorted)
+	}
+}
+
+func TestDefaultParallels(t *testing.T) {
+	defaults := strings.Join(defaultParallels(), ", ")
+	for _, name := range []string{"crtsh", "censys", "certspotter"} {
+		if !strings.Contains(defaults, name+": ") {
+			t.Errorf("expected a default concurrency for %s, got %q", name, defaults)
+		}
+	}
+	if strings.Contains(defaults, "http") {
+		t.Errorf("expected no default concurrency for the http driver, got %q", defaults)
+	}
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is the default amount of time DNS results are cached for
+const DefaultCacheTTL = 5 * time.Minute
+
+type cacheEntry struct {
+	hasDNS  bool
+	ips     []string
+	expires time.Time
+}
+
+// dnsCache is a concurrency safe cache of DNS results that expire after ttl
+// a ttl of 0 disables caching
+type dnsCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	c := new(dnsCache)
+	c.ttl = ttl
+	c.entries = make(map[string]cacheEntry)
+	return c
+}
+
+// getEntry returns the cache entry for the domain if it is in the cache and has not expired
+func (c *dnsCache) getEntry(domain string) (cacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, found := c.entries[domain]
+	if !found {
+		return entry, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, domain)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// setEntry saves the entry for the domain in the cache
+func (c *dnsCache) setEntry(domain string, entry cacheEntry) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry.expires = time.Now().Add(c.ttl)
+	c.entries[domain] = entry
+}
+
+// get returns (hasDNS, found) for the domain if it is in the cache and has not expired
+func (c *dnsCache) get(domain string) (bool, bool) {
+	entry, found := c.getEntry(domain)
+	return entry.hasDNS, found
+}
+
+// set saves the result for the domain in the cache
+func (c *dnsCache) set(domain string, hasDNS bool) {
+	c.setEntry(domain, cacheEntry{hasDNS: hasDNS})
+}
+
+// getIPs returns (ips, found) for the domain if it is in the cache and has not expired
+func (c *dnsCache) getIPs(domain string) ([]string, bool) {
+	entry, found := c.getEntry(domain)
+	return entry.ips, found
+}
+
+// setIPs saves the IP addresses of the domain in the cache
+func (c *dnsCache) setIPs(domain string, ips []string) {
+	c.setEntry(domain, cacheEntry{ips: ips})
+}
+
+// SetCacheTTL sets how long DNS results are cached for and clears the cache
+// a ttl of 0 disables caching
+func SetCacheTTL(ttl time.Duration) {
+	cache = newDNSCache(ttl)
+	ipCache = newDNSCache(ttl)
+}
@@ -0,0 +1,25 @@
+package dns
+
+import "strings"
+
+// DNS name length limits from RFC 1035
+const (
+	maxLabelLength    = 63
+	maxHostnameLength = 253
+)
+
+// ValidHostnameLength returns true if the domain is within the DNS length limits
+// labels must be 1 to 63 characters and the whole name at most 253 characters
+// a leading wildcard label and trailing root dot are allowed
+func ValidHostnameLength(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if len(domain) == 0 || len(domain) > maxHostnameLength {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > maxLabelLength {
+			return false
+		}
+	}
+	return true
+}
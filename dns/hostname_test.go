@@ -0,0 +1,28 @@
+package dns_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lanrat/certgraph/dns"
+)
+
+func TestValidHostnameLength(t *testing.T) {
+	maxLabel := strings.Repeat("a", 63)
+	longName := strings.Repeat(maxLabel+".", 4) + "com"
+	tests := map[string]bool{
+		"example.com":              true,
+		"*.example.com":            true,
+		"example.com.":             true,
+		maxLabel + ".example.com":  true,
+		maxLabel + "a.example.com": false,
+		longName:                   false,
+		"www..example.com":         false,
+		"":                         false,
+	}
+	for domain, valid := range tests {
+		if dns.ValidHostnameLength(domain) != valid {
+			t.Errorf("ValidHostnameLength(%q) = %t, expected %t", domain, !valid, valid)
+		}
+	}
+}
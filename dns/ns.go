@@ -4,11 +4,14 @@ package dns
 import (
 	"context"
 	"net"
+	"sort"
+	"strings"
 	"time"
 )
 
 var (
-	dnsCache    = make(map[string]bool)
+	cache       = newDNSCache(DefaultCacheTTL)
+	ipCache     = newDNSCache(DefaultCacheTTL) // A and AAAA records of full domains, cache holds apex domains
 	dnsResolver = &net.Resolver{}
 )
 
@@ -27,13 +30,18 @@ func noSuchHostDNSError(err error) bool {
 }
 
 // HasRecords does NS, CNAME, A, and AAAA lookups with a timeout
+// lookups that fail temporarily are retried, see SetRetries
 // returns error when no NS found, does not use alexDomain
 func HasRecords(domain string, timeout time.Duration) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	// first check for NS
-	ns, err := dnsResolver.LookupNS(ctx, domain)
+	var ns []*net.NS
+	err := retry(ctx, func() (err error) {
+		ns, err = dnsResolver.LookupNS(ctx, domain)
+		return err
+	})
 	if err != nil && !noSuchHostDNSError(err) {
 		//fmt.Println("NS error ", err)
 		return false, err
@@ -44,7 +52,11 @@ func HasRecords(domain string, timeout time.Duration) (bool, error) {
 	}
 
 	// next check for CNAME
-	cname, err := dnsResolver.LookupCNAME(ctx, domain)
+	var cname string
+	err = retry(ctx, func() (err error) {
+		cname, err = dnsResolver.LookupCNAME(ctx, domain)
+		return err
+	})
 	if err != nil && !noSuchHostDNSError(err) {
 		//fmt.Println("cname error ", err)
 		return false, err
@@ -55,7 +67,11 @@ func HasRecords(domain string, timeout time.Duration) (bool, error) {
 	}
 
 	// next check for IP
-	addrs, err := dnsResolver.LookupHost(ctx, domain)
+	var addrs []string
+	err = retry(ctx, func() (err error) {
+		addrs, err = dnsResolver.LookupHost(ctx, domain)
+		return err
+	})
 	if err != nil && !noSuchHostDNSError(err) {
 		//fmt.Println("ip error ", err)
 		return false, err
@@ -76,13 +92,104 @@ func HasRecordsCache(domain string, timeout time.Duration) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	hasDNS, found := dnsCache[domain]
+	hasDNS, found := cache.get(domain)
 	if found {
 		return hasDNS, nil
 	}
 	hasRecords, err := HasRecords(domain, timeout)
-	if err != nil {
-		dnsCache[domain] = hasRecords
+	if err == nil {
+		cache.set(domain, hasRecords)
 	}
 	return hasRecords, err
 }
+
+// LookupIPs returns the sorted IPv4 and IPv6 addresses of the domain's A and AAAA records
+// a domain that does not exist has no addresses and is not an error
+func LookupIPs(domain string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips := make([]string, 0, 2)
+	var addrs []net.IPAddr
+	err := retry(ctx, func() (err error) {
+		addrs, err = dnsResolver.LookupIPAddr(ctx, domain)
+		return err
+	})
+	if err != nil {
+		if noSuchHostDNSError(err) {
+			return ips, nil
+		}
+		return ips, err
+	}
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP.String())
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// LookupIPsCache returns the IP addresses of the domain using a cache to prevent repeated lookups
+func LookupIPsCache(domain string, timeout time.Duration) ([]string, error) {
+	ips, found := ipCache.getIPs(domain)
+	if found {
+		return ips, nil
+	}
+	ips, err := LookupIPs(domain, timeout)
+	if err == nil {
+		ipCache.setIPs(domain, ips)
+	}
+	return ips, err
+}
+
+// LookupSRVTargets returns the target hosts of the SRV records for each service prefix (ex: _sip._tls) of the domain
+func LookupSRVTargets(domain string, services []string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	targets := make([]string, 0, len(services))
+	for _, service := range services {
+		var addrs []*net.SRV
+		err := retry(ctx, func() (err error) {
+			_, addrs, err = dnsResolver.LookupSRV(ctx, "", "", strings.Trim(service, ".")+"."+domain)
+			return err
+		})
+		if err != nil {
+			if noSuchHostDNSError(err) {
+				continue
+			}
+			return targets, err
+		}
+		for _, addr := range addrs {
+			target := strings.TrimSuffix(addr.Target, ".")
+			// a target of "." means the service is not available
+			if len(target) > 0 {
+				targets = append(targets, strings.ToLower(target))
+			}
+		}
+	}
+	return targets, nil
+}
+
+// LookupMX returns the hosts of the MX records for the domain
+func LookupMX(domain string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	domains := make([]string, 0, 5)
+	var mx []*net.MX
+	err := retry(ctx, func() (err error) {
+		mx, err = dnsResolver.LookupMX(ctx, domain)
+		return err
+	})
+	if err != nil {
+		return domains, err
+	}
+	for _, v := range mx {
+		host := strings.TrimSuffix(v.Host, ".")
+		// a host of "." is a null MX, the domain does not accept mail
+		if len(host) > 0 {
+			domains = append(domains, strings.ToLower(host))
+		}
+	}
+	return domains, nil
+}
@@ -1,7 +1,14 @@
 package dns
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/weppos/publicsuffix-go/publicsuffix"
@@ -12,30 +19,139 @@ var (
 		IgnorePrivate: true,
 		DefaultRule:   publicsuffix.DefaultRule,
 	}
+	suffixListParseOptions = &publicsuffix.ParserOption{
+		PrivateDomains: !suffixListFindOptions.IgnorePrivate,
+	}
 	suffixListURL = "https://publicsuffix.org/list/public_suffix_list.dat"
 	suffixList    = publicsuffix.DefaultList
 )
 
+// DefaultPublicSuffixListMaxAge is the default age after which the cached public suffix list is downloaded again
+const DefaultPublicSuffixListMaxAge = 24 * time.Hour
+
 // UpdatePublicSuffixList gets a new copy of the public suffix list from the internat and updates the built in copy with the new rules
-func UpdatePublicSuffixList(timeout time.Duration) error {
-	suffixListParseOptions := &publicsuffix.ParserOption{
-		PrivateDomains: !suffixListFindOptions.IgnorePrivate,
+// the downloaded list is cached in the user's cache directory and only downloaded again once it is older than maxAge
+// if the download fails the cached list is used if present, otherwise the built in list is kept
+func UpdatePublicSuffixList(timeout, maxAge time.Duration) error {
+	cacheFile, cacheErr := suffixListCacheFile()
+	if cacheErr == nil {
+		info, err := os.Stat(cacheFile)
+		if err == nil && time.Since(info.ModTime()) < maxAge {
+			return loadSuffixListFile(cacheFile)
+		}
+	}
+
+	data, err := downloadSuffixList(timeout)
+	if err == nil {
+		err = loadSuffixList(data)
+	}
+	if err != nil {
+		// fall back to the cached list, even if it is old
+		if cacheErr == nil && loadSuffixListFile(cacheFile) == nil {
+			return nil
+		}
+		return err
+	}
+
+	if cacheErr != nil {
+		return cacheErr
+	}
+	err = os.MkdirAll(filepath.Dir(cacheFile), 0777)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cacheFile, data, 0666)
+}
+
+// suffixListCacheFile returns the path of the cached public suffix list
+func suffixListCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(dir, "certgraph", "public_suffix_list.dat"), nil
+}
+
+// downloadSuffixList returns the contents of the public suffix list from suffixListURL
+func downloadSuffixList(timeout time.Duration) ([]byte, error) {
 	client := http.Client{
 		Timeout: timeout,
 	}
 	resp, err := client.Get(suffixListURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading public suffix list, got Status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func loadSuffixListFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return loadSuffixList(data)
+}
+
+// loadSuffixList replaces the current public suffix list with the provided list if it parses
+func loadSuffixList(data []byte) error {
 	newSuffixList := publicsuffix.NewList()
-	_, err = newSuffixList.Load(resp.Body, suffixListParseOptions)
+	_, err := newSuffixList.Load(bytes.NewReader(data), suffixListParseOptions)
+	if err != nil {
+		return err
+	}
 	suffixList = newSuffixList
-	return err
+	return nil
 }
 
 // ApexDomain returns TLD+1 of domain
 func ApexDomain(domain string) (string, error) {
 	return publicsuffix.DomainFromListWithOptions(suffixList, domain, suffixListFindOptions)
 }
+
+// ApexDomainFallback returns TLD+1 of domain, or the last two labels of domain if the public suffix list can't find its apex
+// the domain is returned unchanged if it has two or fewer labels or is an IP address
+func ApexDomainFallback(domain string) string {
+	if net.ParseIP(domain) != nil {
+		return domain
+	}
+	domain = strings.Trim(strings.ToLower(domain), ".")
+	apex, err := ApexDomain(domain)
+	if err == nil && len(apex) > 0 {
+		return apex
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) <= 2 {
+		return domain
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// CheckPublicSuffixList returns an error if the public suffix list can't find the apex of a well known domain
+func CheckPublicSuffixList() error {
+	const domain, expected = "www.example.co.uk", "example.co.uk"
+	apex, err := ApexDomain(domain)
+	if err != nil {
+		return err
+	}
+	if apex != expected {
+		return fmt.Errorf("public suffix list returned apex %q for %q, expected %q", apex, domain, expected)
+	}
+	return nil
+}
+
+// TLD returns the public suffix of domain
+func TLD(domain string) (string, error) {
+	apex, err := ApexDomain(domain)
+	if err != nil {
+		return "", err
+	}
+	i := strings.Index(apex, ".")
+	if i < 0 {
+		return "", fmt.Errorf("no public suffix found for %q", domain)
+	}
+	return apex[i+1:], nil
+}
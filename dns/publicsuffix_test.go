@@ -0,0 +1,24 @@
+package dns_test
+
+import (
+	"testing"
+
+	"github.com/lanrat/certgraph/dns"
+)
+
+func TestApexDomainFallback(t *testing.T) {
+	tests := map[string]string{
+		"www.example.com":            "example.com",
+		"a.b.example.zz-exotic-tld":  "example.zz-exotic-tld",
+		"WWW.Example.ZZ-Exotic-TLD.": "example.zz-exotic-tld",
+		"localhost":                  "localhost",
+		"co.uk":                      "co.uk",
+		"192.0.2.10":                 "192.0.2.10",
+		"2001:db8::1":                "2001:db8::1",
+	}
+	for domain, expected := range tests {
+		if apex := dns.ApexDomainFallback(domain); apex != expected {
+			t.Errorf("ApexDomainFallback(%q) = %q, expected %q", domain, apex, expected)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultRetries is the default number of times a lookup is retried after a temporary failure
+const DefaultRetries = 2
+
+// retryBackoff is the time waited before the first retry, each following retry waits longer
+const retryBackoff = 250 * time.Millisecond
+
+var retries = DefaultRetries
+
+// SetRetries sets the number of times a lookup is retried after a temporary failure, such as SERVFAIL or a timeout
+// retries share the lookup's timeout
+func SetRetries(n int) {
+	retries = n
+}
+
+// retryable returns true if the lookup error may succeed when retried
+// a domain that does not exist is an authoritative answer and is never retried
+func retryable(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok || noSuchHostDNSError(err) {
+		return false
+	}
+	return dnsErr.IsTemporary || dnsErr.IsTimeout
+}
+
+// retry calls lookup until it returns an error that is not retryable, the retries are used, or ctx is done
+func retry(ctx context.Context, lookup func() error) error {
+	err := lookup()
+	for i := 0; i < retries && retryable(err); i++ {
+		timer := time.NewTimer(retryBackoff * time.Duration(i+1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		err = lookup()
+	}
+	return err
+}
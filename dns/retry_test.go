@@ -0,0 +1,33 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"success", nil, 1},
+		{"servfail", &net.DNSError{Err: "server misbehaving", IsTemporary: true}, DefaultRetries + 1},
+		{"timeout", &net.DNSError{Err: "i/o timeout", IsTimeout: true}, DefaultRetries + 1},
+		{"nxdomain", &net.DNSError{Err: "no such host"}, 1},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := retry(context.Background(), func() error {
+			calls++
+			return test.err
+		})
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if calls != test.calls {
+			t.Errorf("%s: expected %d lookups, got %d", test.name, test.calls, calls)
+		}
+	}
+}
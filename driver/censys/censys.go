@@ -7,6 +7,7 @@ package censys
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
@@ -14,7 +15,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/lanrat/certgraph/driver"
@@ -24,33 +27,46 @@ import (
 
 const driverName = "censys"
 
-var debug = false
+// defaultURL is the base URL of the public censys API
+const defaultURL = "https://search.censys.io/api/v1"
 
-// TODO support rate limits & pagination
+// DefaultMaxParallel is the default number of concurrent requests to the censys API, more quickly gets rate limited
+const DefaultMaxParallel = 2
+
+// TODO support pagination
 
 var (
 	defaultHTTPClient = &http.Client{}
 
 	appID  = flag.String("censys-appid", "", "censys API AppID")
 	secret = flag.String("censys-secret", "", "censys API Secret")
+	apiURL = flag.String("censys-url", defaultURL, "censys API base URL")
 )
 
 func init() {
-	driver.AddDriver(driverName)
+	driver.Register(driverName, driver.Info{CT: true, DefaultParallel: DefaultMaxParallel}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(o.SavePath, o.IncludeSubdomains, o.IncludeExpired, o.IncludeCN, o.AsOf, o.IssuedSince, o.DumpQueries)
+	})
 }
 
 type censys struct {
+	baseURL           string
 	appID             string
 	secret            string
 	save              bool
 	savePath          string
 	includeSubdomains bool
 	includeExpired    bool
+	includeCN         bool
+	asOf              time.Time
+	issuedSince       time.Time
+	debug             bool // log the requests sent and the responses
 }
 
 type censysCertDriver struct {
 	host         string
 	fingerprints driver.FingerprintMap
+	notBefore    map[fingerprint.Fingerprint]time.Time
 	driver       *censys
 }
 
@@ -58,6 +74,11 @@ func (c *censysCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
 	return c.fingerprints, nil
 }
 
+func (c *censysCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	t, ok := c.notBefore[fp]
+	return t, ok
+}
+
 func (c *censysCertDriver) GetStatus() status.Map {
 	return status.NewMap(c.host, status.New(status.CT))
 }
@@ -66,54 +87,94 @@ func (c *censysCertDriver) GetRelated() ([]string, error) {
 	return make([]string, 0), nil
 }
 
-func (c *censysCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
-	return c.driver.QueryCert(fp)
+func (c *censysCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	return c.driver.QueryCert(ctx, fp)
 }
 
 // TODO support pagination
-func domainSearchParam(domain string, includeExpired, includeSubdomain bool) certSearchParam {
+func domainSearchParam(domain string, includeExpired, includeSubdomain, includeCN bool, asOf, issuedSince time.Time) certSearchParam {
 	var s certSearchParam
+	namesField := "parsed.names"
+	if !includeCN {
+		namesField = "parsed.extensions.subject_alt_name.dns_names"
+	}
 	if includeSubdomain {
-		s.Query = fmt.Sprintf("(parsed.names: %s )", domain)
+		s.Query = fmt.Sprintf("(%s: %s )", namesField, domain)
 	} else {
-		s.Query = fmt.Sprintf("(parsed.names.raw: %s)", domain)
+		s.Query = fmt.Sprintf("(%s.raw: %s)", namesField, domain)
 	}
-	if !includeExpired {
-		dateStr := time.Now().Format("2006-01-02") // YYYY-MM-DD
+	if !includeExpired || !asOf.IsZero() {
+		validDate := asOf
+		if validDate.IsZero() {
+			validDate = time.Now()
+		}
+		dateStr := validDate.Format("2006-01-02") // YYYY-MM-DD
 		expQuery := fmt.Sprintf(" AND ((parsed.validity.end: [%s TO *]) AND (parsed.validity.start: [* TO %s]))", dateStr, dateStr)
 		s.Query = s.Query + expQuery
 	}
+	if !issuedSince.IsZero() {
+		s.Query = s.Query + fmt.Sprintf(" AND (parsed.validity.start: [%s TO *])", issuedSince.Format("2006-01-02"))
+	}
 	s.Page = 1
 	s.Flatten = true
-	s.Fields = []string{"parsed.fingerprint_sha256", "parsed.names"}
+	s.Fields = []string{"parsed.fingerprint_sha256", "parsed.names", "parsed.validity.start"}
 	return s
 }
 
 // Driver creates a new CT driver for censys
-func Driver(savePath string, includeSubdomains, includeExpired bool) (driver.Driver, error) {
+// if includeCN is false only dNSName SANs are searched and returned
+// if asOf is not zero only certificates valid at that time are returned, including expired certificates
+// if issuedSince is not zero only certificates issued on or after that day are returned
+// if debug is true the API requests and responses are logged
+func Driver(savePath string, includeSubdomains, includeExpired, includeCN bool, asOf, issuedSince time.Time, debug bool) (driver.Driver, error) {
 	if *appID == "" || *secret == "" {
 		return nil, fmt.Errorf("censys requires an appID and secret to run")
 	}
+	baseURL, err := parseBaseURL(*apiURL)
+	if err != nil {
+		return nil, err
+	}
 	d := new(censys)
+	d.baseURL = baseURL
 	d.appID = *appID
 	d.secret = *secret
 	d.savePath = savePath
 	d.includeSubdomains = includeSubdomains
 	d.includeExpired = includeExpired
+	d.includeCN = includeCN
+	d.asOf = asOf
+	d.issuedSince = issuedSince
+	d.debug = debug
 	return d, nil
 }
 
+// parseBaseURL validates the API base URL and returns it without a trailing slash
+// an empty URL returns the public censys API URL
+func parseBaseURL(baseURL string) (string, error) {
+	if len(baseURL) == 0 {
+		return defaultURL, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid censys URL: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return "", fmt.Errorf("invalid censys URL %q, must be an http or https URL", baseURL)
+	}
+	return strings.TrimSuffix(baseURL, "/"), nil
+}
+
 func (d *censys) GetName() string {
 	return driverName
 }
 
-func (d *censys) request(method, url string, request io.Reader) (*http.Response, error) {
+func (d *censys) request(ctx context.Context, method, url string, request io.Reader) (*http.Response, error) {
 	totalTrys := 3
 	var err error
 	var req *http.Request
 	var resp *http.Response
 	for try := 1; try <= totalTrys; try++ {
-		req, err = http.NewRequest(method, url, request)
+		req, err = http.NewRequestWithContext(ctx, method, url, request)
 		if err != nil {
 			return nil, err
 		}
@@ -132,14 +193,18 @@ func (d *censys) request(method, url string, request io.Reader) (*http.Response,
 
 		// sleep only if we will try again
 		if try < totalTrys {
-			time.Sleep(time.Second * 10)
+			select {
+			case <-time.After(time.Second * 10):
+			case <-ctx.Done():
+				return resp, err
+			}
 		}
 	}
 	return resp, err
 }
 
 // jsonRequest performs a request to the API endpoint sending and receiving JSON objects
-func (d *censys) jsonRequest(method, url string, request, response interface{}) error {
+func (d *censys) jsonRequest(ctx context.Context, method, url string, request, response interface{}) error {
 	var payloadReader io.Reader
 	if request != nil {
 		jsonPayload, err := json.Marshal(request)
@@ -149,15 +214,15 @@ func (d *censys) jsonRequest(method, url string, request, response interface{})
 		payloadReader = bytes.NewReader(jsonPayload)
 	}
 
-	if debug {
-		log.Printf("DEBUG: request to %s %s", method, url)
+	if d.debug {
+		log.Printf("censys: request to %s %s", method, redactURL(url))
 		if request != nil {
 			prettyJSONBytes, _ := json.MarshalIndent(request, "", "\t")
 			log.Printf("request payload:\n%s\n", string(prettyJSONBytes))
 		}
 	}
 
-	resp, err := d.request(method, url, payloadReader)
+	resp, err := d.request(ctx, method, url, payloadReader)
 	if err != nil {
 		return err
 	}
@@ -179,7 +244,7 @@ func (d *censys) jsonRequest(method, url string, request, response interface{})
 		if err != nil {
 			return err
 		}
-		if debug {
+		if d.debug {
 			prettyJSONBytes, _ := json.MarshalIndent(response, "", "\t")
 			log.Printf("response payload:\n%s\n", string(prettyJSONBytes))
 		}
@@ -188,16 +253,17 @@ func (d *censys) jsonRequest(method, url string, request, response interface{})
 	return nil
 }
 
-func (d *censys) QueryDomain(domain string) (driver.Result, error) {
+func (d *censys) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
 	results := &censysCertDriver{
 		host:         domain,
 		fingerprints: make(driver.FingerprintMap),
+		notBefore:    make(map[fingerprint.Fingerprint]time.Time),
 		driver:       d,
 	}
-	params := domainSearchParam(domain, d.includeExpired, d.includeSubdomains)
-	url := "https://search.censys.io/api/v1/search/certificates"
+	params := domainSearchParam(domain, d.includeExpired, d.includeSubdomains, d.includeCN, d.asOf, d.issuedSince)
+	url := d.baseURL + "/search/certificates"
 	var resp certSearchResponse
-	err := d.jsonRequest(http.MethodPost, url, params, &resp)
+	err := d.jsonRequest(ctx, http.MethodPost, url, params, &resp)
 	if err != nil {
 		return results, err
 	}
@@ -205,38 +271,68 @@ func (d *censys) QueryDomain(domain string) (driver.Result, error) {
 	for _, r := range resp.Results {
 		fp := fingerprint.FromHexHash(r.Fingerprint)
 		results.fingerprints.Add(domain, fp)
+		results.notBefore[fp] = r.ValidityStart
 	}
 
-	if debug {
+	if d.debug {
 		log.Printf("censys: got %d results for %s.", len(resp.Results), domain)
 	}
 
 	return results, nil
 }
 
-func (d *censys) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+func (d *censys) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
 	certNode := new(driver.CertResult)
 	certNode.Fingerprint = fp
 	certNode.Domains = make([]string, 0, 5)
 
-	url := fmt.Sprintf("https://search.censys.io/api/v1/view/certificates/%s", fp.HexString())
+	url := fmt.Sprintf("%s/view/certificates/%s", d.baseURL, fp.HexString())
 	var resp certViewResponse
-	err := d.jsonRequest(http.MethodGet, url, nil, &resp)
+	err := d.jsonRequest(ctx, http.MethodGet, url, nil, &resp)
 	if err != nil {
 		return certNode, err
 	}
 
-	if debug {
+	if d.debug {
 		log.Printf("DEBUG QueryCert(%s): %v", fp.HexString(), resp.Parsed.Names)
 	}
 
-	certNode.Domains = append(certNode.Domains, resp.Parsed.Names...)
+	if d.includeCN {
+		certNode.Domains = append(certNode.Domains, resp.Parsed.Names...)
+	} else {
+		certNode.Domains = append(certNode.Domains, resp.Parsed.Extensions.SubjectAltName.DNSNames...)
+	}
+	certNode.NotBefore = resp.Parsed.Validity.Start
+	certNode.NotAfter = resp.Parsed.Validity.End
+	certNode.Organizations = resp.Parsed.Subject.Organization
+	if len(resp.Parsed.Issuer.CommonName) > 0 {
+		certNode.IssuerCN = resp.Parsed.Issuer.CommonName[0]
+	}
+	certNode.IssuerOrgs = resp.Parsed.Issuer.Organization
+	certNode.Tags = resp.Tags
+	certNode.Precert = resp.Precert
+	certNode.AuthorityKeyID = strings.ToLower(resp.Parsed.Extensions.AuthorityKeyID)
+	certNode.SubjectKeyID = strings.ToLower(resp.Parsed.Extensions.SubjectKeyID)
+	certNode.KeyAlgorithm = resp.Parsed.SubjectKeyInfo.KeyAlgorithm.Name
+	certNode.SignatureAlgo = resp.Parsed.SignatureAlgorithm.Name
+	certNode.KeySize = resp.Parsed.SubjectKeyInfo.RsaPublicKey.Length
+	if certNode.KeySize == 0 {
+		certNode.KeySize = resp.Parsed.SubjectKeyInfo.EcdsaPublicKey.Length
+	}
 
-	if d.save {
-		rawCert, err := base64.StdEncoding.DecodeString(resp.Raw)
+	// the fingerprint is censys' SHA256 of the certificate, check it against the certificate it returned
+	rawCert, err := base64.StdEncoding.DecodeString(resp.Raw)
+	if err != nil {
+		return certNode, err
+	}
+	if len(rawCert) > 0 {
+		err = driver.VerifyRawCert(fp, rawCert)
 		if err != nil {
-			return certNode, err
+			return nil, err
 		}
+	}
+
+	if d.save && len(rawCert) > 0 {
 		err = driver.RawCertToPEMFile(rawCert, path.Join(d.savePath, fp.HexString())+".pem")
 		if err != nil {
 			return certNode, err
@@ -245,3 +341,12 @@ func (d *censys) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, erro
 
 	return certNode, nil
 }
+
+// redactURL returns the URL with any password replaced so it can be logged
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
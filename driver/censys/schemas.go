@@ -21,8 +21,9 @@ type certSearchResponse struct {
 		Pages       uint   `json:"pages"`
 	} `json:"metadata"`
 	Results []struct {
-		Names       []string `json:"parsed.names"`
-		Fingerprint string   `json:"parsed.fingerprint_sha256"`
+		Names         []string  `json:"parsed.names"`
+		Fingerprint   string    `json:"parsed.fingerprint_sha256"`
+		ValidityStart time.Time `json:"parsed.validity.start"`
 	} `json:"results"`
 }
 
@@ -66,7 +67,8 @@ type certViewResponse struct {
 			Length int       `json:"length"`
 		} `json:"validity"`
 		Subject struct {
-			CommonName []string `json:"common_name"`
+			CommonName   []string `json:"common_name"`
+			Organization []string `json:"organization"`
 		} `json:"subject"`
 		SubjectDn      string `json:"subject_dn"`
 		SubjectKeyInfo struct {
@@ -85,6 +87,9 @@ type certViewResponse struct {
 				X      string `json:"x"`
 				Y      string `json:"y"`
 			} `json:"ecdsa_public_key"`
+			RsaPublicKey struct {
+				Length int `json:"length"`
+			} `json:"rsa_public_key"`
 			FingerprintSha256 string `json:"fingerprint_sha256"`
 		} `json:"subject_key_info"`
 		Extensions struct {
@@ -0,0 +1,252 @@
+// Package certspotter implements a certgraph driver to search Certificate Transparency logs
+// with the SSLMate Cert Spotter API
+package certspotter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+const driverName = "certspotter"
+
+// DefaultMaxParallel is the default number of concurrent requests to the Cert Spotter API, the unauthenticated tier is heavily rate limited
+const DefaultMaxParallel = 1
+
+// maxPages is the maximum number of pages of issuances requested for a single domain
+const maxPages = 100
+
+var (
+	token  = flag.String("certspotter-token", "", "Cert Spotter API token for higher rate limits")
+	apiURL = flag.String("certspotter-url", "https://api.certspotter.com/v1/issuances", "Cert Spotter API issuances endpoint")
+)
+
+// linkNextRegex matches the URL of the next page in a Link header
+var linkNextRegex = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+func init() {
+	driver.Register(driverName, driver.Info{CT: true, DefaultParallel: DefaultMaxParallel}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(o.Timeout, o.SavePath, o.IncludeSubdomains, o.IncludeExpired, o.DumpQueries)
+	})
+}
+
+type certspotter struct {
+	client            *http.Client
+	token             string
+	save              bool
+	savePath          string
+	includeSubdomains bool
+	includeExpired    bool
+	debug             bool // log the requests sent and the number of results
+}
+
+// issuance is a certificate issuance returned by the Cert Spotter API
+type issuance struct {
+	ID         string    `json:"id"`
+	CertSHA256 string    `json:"cert_sha256"`
+	DNSNames   []string  `json:"dns_names"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+	Cert       struct {
+		Data string `json:"data"`
+	} `json:"cert"`
+}
+
+type certspotterCertDriver struct {
+	host         string
+	fingerprints driver.FingerprintMap
+	certs        map[fingerprint.Fingerprint]*driver.CertResult
+}
+
+func (c *certspotterCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
+	return c.fingerprints, nil
+}
+
+func (c *certspotterCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	cert, found := c.certs[fp]
+	if !found {
+		return time.Time{}, false
+	}
+	return cert.NotBefore, true
+}
+
+func (c *certspotterCertDriver) GetStatus() status.Map {
+	return status.NewMap(c.host, status.New(status.CT))
+}
+
+func (c *certspotterCertDriver) GetRelated() ([]string, error) {
+	return make([]string, 0), nil
+}
+
+// QueryCert returns the certificate found by QueryDomain, the issuances include the dns_names
+// so no additional request is needed
+func (c *certspotterCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	cert, found := c.certs[fp]
+	if found {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+}
+
+// Driver creates a new CT driver for Cert Spotter
+// requests are canceled once timeout has passed
+// if debug is true the API requests are logged
+func Driver(timeout time.Duration, savePath string, includeSubdomains, includeExpired, debug bool) (driver.Driver, error) {
+	d := new(certspotter)
+	d.client = &http.Client{Timeout: timeout}
+	d.token = *token
+	d.includeSubdomains = includeSubdomains
+	d.includeExpired = includeExpired
+	d.debug = debug
+	if len(savePath) > 0 {
+		d.save = true
+		d.savePath = savePath
+	}
+	return d, nil
+}
+
+func (d *certspotter) GetName() string {
+	return driverName
+}
+
+// issuancesURL returns the URL of the first page of issuances for domain
+func (d *certspotter) issuancesURL(domain string) string {
+	params := url.Values{}
+	params.Set("domain", domain)
+	params.Set("include_subdomains", fmt.Sprintf("%t", d.includeSubdomains))
+	params.Add("expand", "dns_names")
+	if d.save {
+		params.Add("expand", "cert")
+	}
+	return *apiURL + "?" + params.Encode()
+}
+
+// getPage returns the issuances at pageURL and the URL of the next page from the Link header, if any
+func (d *certspotter) getPage(ctx context.Context, pageURL string) ([]issuance, string, error) {
+	if d.debug {
+		log.Printf("certspotter: request to %s", pageURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(d.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("error on request %s, got Status %s", pageURL, resp.Status)
+		if retry := resp.Header.Get("Retry-After"); len(retry) > 0 {
+			err = fmt.Errorf("%w, retry after %s seconds", err, retry)
+		}
+		return nil, "", err
+	}
+
+	var issuances []issuance
+	err = json.NewDecoder(resp.Body).Decode(&issuances)
+	if err != nil {
+		return nil, "", err
+	}
+	return issuances, nextPage(pageURL, resp.Header.Values("Link")), nil
+}
+
+// nextPage returns the absolute URL of the rel="next" link in the Link headers, or an empty string if there is none
+func nextPage(pageURL string, links []string) string {
+	for _, link := range links {
+		for _, part := range strings.Split(link, ",") {
+			match := linkNextRegex.FindStringSubmatch(part)
+			if match == nil {
+				continue
+			}
+			base, err := url.Parse(pageURL)
+			if err != nil {
+				return ""
+			}
+			next, err := base.Parse(match[1])
+			if err != nil {
+				return ""
+			}
+			return next.String()
+		}
+	}
+	return ""
+}
+
+func (d *certspotter) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	results := &certspotterCertDriver{
+		host:         domain,
+		fingerprints: make(driver.FingerprintMap),
+		certs:        make(map[fingerprint.Fingerprint]*driver.CertResult),
+	}
+
+	now := time.Now()
+	pageURL := d.issuancesURL(domain)
+	for page := 0; len(pageURL) > 0 && page < maxPages; page++ {
+		issuances, next, err := d.getPage(ctx, pageURL)
+		if err != nil {
+			return results, err
+		}
+		for _, i := range issuances {
+			hash, err := hex.DecodeString(i.CertSHA256)
+			if err != nil || len(hash) != len(fingerprint.Fingerprint{}) {
+				continue
+			}
+			if !d.includeExpired && now.After(i.NotAfter) {
+				continue
+			}
+			fp := fingerprint.FromHashBytes(hash)
+			if _, found := results.certs[fp]; found {
+				continue
+			}
+			results.fingerprints.Add(domain, fp)
+			results.certs[fp] = &driver.CertResult{
+				Fingerprint: fp,
+				Domains:     i.DNSNames,
+				NotBefore:   i.NotBefore,
+				NotAfter:    i.NotAfter,
+			}
+			if d.save && len(i.Cert.Data) > 0 {
+				rawCert, err := base64.StdEncoding.DecodeString(i.Cert.Data)
+				if err != nil {
+					return results, err
+				}
+				// cert_sha256 is the hash of the certificate's DER bytes
+				err = driver.VerifyRawCert(fp, rawCert)
+				if err != nil {
+					return results, err
+				}
+				err = driver.RawCertToPEMFile(rawCert, path.Join(d.savePath, fp.HexString())+".pem")
+				if err != nil {
+					return results, err
+				}
+			}
+		}
+		pageURL = next
+	}
+
+	if d.debug {
+		log.Printf("certspotter: got %d results for %s.", len(results.certs), domain)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,56 @@
+package certspotter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/fingerprint"
+)
+
+func TestQueryDomainPages(t *testing.T) {
+	hashes := []string{strings.Repeat("aa", 32), strings.Repeat("bb", 32), strings.Repeat("cc", 32)}
+	notAfter := time.Now().Add(time.Hour).Format(time.RFC3339)
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("domain") != "example.com" {
+			t.Errorf("unexpected domain query %q", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("after") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?domain=example.com&after=1>; rel="next"`, r.URL.Path))
+			fmt.Fprintf(w, `[{"id":"1","cert_sha256":%q,"dns_names":["example.com","www.example.com"],"not_after":%q}]`, hashes[0], notAfter)
+			return
+		}
+		fmt.Fprintf(w, `[{"id":"2","cert_sha256":%q,"dns_names":["example.com"],"not_after":%q},{"id":"3","cert_sha256":%q,"not_after":%q}]`, hashes[1], notAfter, hashes[2], expired)
+	}))
+	defer server.Close()
+	*apiURL = server.URL + "/v1/issuances"
+
+	d, err := Driver(5*time.Second, "", true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the expired certificate on the second page is excluded
+	if len(fingerprints["example.com"]) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(fingerprints["example.com"]))
+	}
+	certResult, err := result.QueryCert(context.Background(), fingerprint.FromHexHash(hashes[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certResult.Domains) != 2 {
+		t.Errorf("expected 2 domains, got %v", certResult.Domains)
+	}
+}
@@ -0,0 +1,347 @@
+// Package certstream implements a certgraph driver to watch a live CertStream Certificate Transparency feed
+package certstream
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+	"golang.org/x/net/websocket"
+)
+
+const driverName = "certstream"
+
+// retryDelay is the time to wait before reconnecting to the feed after an error
+const retryDelay = 2 * time.Second
+
+// the full stream includes the raw certificate needed to compute the SHA256 fingerprint
+var feedURL = flag.String("certstream-url", "wss://certstream.calidog.io/full-stream", "CertStream compatible websocket feed to watch with the certstream driver")
+
+var window = flag.Duration("certstream-window", time.Minute, "time the certstream driver watches the live feed for certificates of each domain")
+
+func init() {
+	driver.Register(driverName, driver.Info{CT: true}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(o.Timeout, o.SavePath, o.IncludeSubdomains, o.DumpQueries)
+	})
+}
+
+type certstream struct {
+	url               string
+	window            time.Duration
+	timeout           time.Duration
+	save              bool
+	savePath          string
+	includeSubdomains bool
+	debug             bool // log the feed connections and the number of results
+
+	// the feed is shared by every domain being watched and
+	// is only connected while there is at least one subscriber
+	mu          sync.Mutex
+	subscribers map[*certstreamCertDriver]bool
+	running     bool
+	conn        *websocket.Conn
+	err         error // last error connecting to the feed, nil once connected
+}
+
+// message is a CertStream feed message, only the fields used by certgraph are decoded
+type message struct {
+	MessageType string `json:"message_type"`
+	Data        struct {
+		LeafCert struct {
+			AllDomains []string `json:"all_domains"`
+			NotBefore  float64  `json:"not_before"`
+			NotAfter   float64  `json:"not_after"`
+			AsDER      string   `json:"as_der"`
+			SHA256     string   `json:"sha256"`
+		} `json:"leaf_cert"`
+	} `json:"data"`
+}
+
+type certstreamCertDriver struct {
+	host              string
+	includeSubdomains bool
+	fingerprints      driver.FingerprintMap
+	certs             map[fingerprint.Fingerprint]*driver.CertResult
+	raw               map[fingerprint.Fingerprint][]byte
+}
+
+func (c *certstreamCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
+	return c.fingerprints, nil
+}
+
+func (c *certstreamCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	cert, found := c.certs[fp]
+	if !found {
+		return time.Time{}, false
+	}
+	return cert.NotBefore, true
+}
+
+func (c *certstreamCertDriver) GetStatus() status.Map {
+	return status.NewMap(c.host, status.New(status.CT))
+}
+
+func (c *certstreamCertDriver) GetRelated() ([]string, error) {
+	return make([]string, 0), nil
+}
+
+// QueryCert returns a certificate seen on the feed while watching for the domain
+func (c *certstreamCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	cert, found := c.certs[fp]
+	if found {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+}
+
+// matches returns true if the certificate name is the watched host, or one of its sub-domains when enabled
+func (c *certstreamCertDriver) matches(name string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	if name == c.host {
+		return true
+	}
+	return c.includeSubdomains && strings.HasSuffix(name, "."+c.host)
+}
+
+// add records the certificate if any of its domains match the watched host
+// it is only called by the feed with the driver's lock held
+func (c *certstreamCertDriver) add(cert *driver.CertResult, raw []byte) {
+	for _, name := range cert.Domains {
+		if !c.matches(name) {
+			continue
+		}
+		if _, found := c.certs[cert.Fingerprint]; found {
+			return
+		}
+		c.fingerprints.Add(c.host, cert.Fingerprint)
+		c.certs[cert.Fingerprint] = cert
+		if raw != nil {
+			c.raw[cert.Fingerprint] = raw
+		}
+		return
+	}
+}
+
+// Driver creates a new CT driver for a CertStream feed
+// each domain is watched for window on the live feed before its certificates are returned
+// connections to the feed time out after timeout
+// if debug is true connections to the feed are logged
+func Driver(timeout time.Duration, savePath string, includeSubdomains, debug bool) (driver.Driver, error) {
+	d := new(certstream)
+	d.url = *feedURL
+	d.window = *window
+	d.timeout = timeout
+	d.includeSubdomains = includeSubdomains
+	d.debug = debug
+	d.subscribers = make(map[*certstreamCertDriver]bool)
+	if len(savePath) > 0 {
+		d.save = true
+		d.savePath = savePath
+	}
+	return d, nil
+}
+
+func (d *certstream) GetName() string {
+	return driverName
+}
+
+// QueryDomain watches the feed for new certificates for domain until the window passes or ctx is done
+// the certificates seen before ctx is done are returned
+func (d *certstream) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	results := &certstreamCertDriver{
+		host:              strings.ToLower(domain),
+		includeSubdomains: d.includeSubdomains,
+		fingerprints:      make(driver.FingerprintMap),
+		certs:             make(map[fingerprint.Fingerprint]*driver.CertResult),
+		raw:               make(map[fingerprint.Fingerprint][]byte),
+	}
+
+	if d.debug {
+		log.Printf("certstream: watching %s for %s for %s", d.url, domain, d.window)
+	}
+	d.subscribe(results)
+	timer := time.NewTimer(d.window)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	err := d.unsubscribe(results)
+
+	// no more certificates can be added once unsubscribed
+	if len(results.certs) == 0 && err != nil {
+		return results, err
+	}
+	if d.save {
+		for fp, raw := range results.raw {
+			err := driver.RawCertToPEMFile(raw, path.Join(d.savePath, fp.HexString())+".pem")
+			if err != nil {
+				return results, err
+			}
+		}
+	}
+
+	if d.debug {
+		log.Printf("certstream: got %d results for %s.", len(results.certs), domain)
+	}
+
+	return results, nil
+}
+
+// subscribe adds the result to the certificates sent from the feed and connects to the feed if needed
+func (d *certstream) subscribe(results *certstreamCertDriver) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[results] = true
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+// unsubscribe stops adding feed certificates to the result, and disconnects from the feed
+// once nothing is subscribed, it returns the last error connecting to the feed if not connected
+func (d *certstream) unsubscribe(results *certstreamCertDriver) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.subscribers, results)
+	if len(d.subscribers) == 0 && d.conn != nil {
+		d.conn.Close()
+	}
+	return d.err
+}
+
+// run connects to the feed and sends the certificates to the subscribers
+// reconnecting on errors until there are no subscribers left
+func (d *certstream) run() {
+	for {
+		d.mu.Lock()
+		if len(d.subscribers) == 0 {
+			d.running = false
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		conn, err := d.dial()
+		d.mu.Lock()
+		d.err = err
+		if err != nil {
+			d.mu.Unlock()
+			if d.debug {
+				log.Printf("certstream: error connecting to %s: %s", d.url, err)
+			}
+			time.Sleep(retryDelay)
+			continue
+		}
+		if len(d.subscribers) == 0 {
+			conn.Close()
+			d.running = false
+			d.mu.Unlock()
+			return
+		}
+		d.conn = conn
+		d.mu.Unlock()
+
+		err = d.read(conn)
+		conn.Close()
+		d.mu.Lock()
+		d.conn = nil
+		d.mu.Unlock()
+		if d.debug {
+			log.Printf("certstream: disconnected from %s: %s", d.url, err)
+		}
+	}
+}
+
+// dial opens a websocket connection to the feed
+func (d *certstream) dial() (*websocket.Conn, error) {
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return nil, err
+	}
+	config, err := websocket.NewConfig(d.url, "https://"+u.Host)
+	if err != nil {
+		return nil, err
+	}
+	config.Dialer = &net.Dialer{Timeout: d.timeout}
+	return websocket.DialConfig(config)
+}
+
+// read sends every certificate received on conn to the subscribers until conn is closed
+func (d *certstream) read(conn *websocket.Conn) error {
+	for {
+		var msg message
+		err := websocket.JSON.Receive(conn, &msg)
+		if err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				continue
+			}
+			return err
+		}
+		if msg.MessageType != "certificate_update" {
+			continue
+		}
+		cert, raw, err := parseLeaf(&msg)
+		if err != nil {
+			if d.debug {
+				log.Printf("certstream: skipping certificate: %s", err)
+			}
+			continue
+		}
+		d.mu.Lock()
+		for results := range d.subscribers {
+			results.add(cert, raw)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// parseLeaf returns the certificate in the feed message and its raw bytes if they were sent
+func parseLeaf(msg *message) (*driver.CertResult, []byte, error) {
+	leaf := msg.Data.LeafCert
+	if len(leaf.AsDER) > 0 {
+		raw, err := base64.StdEncoding.DecodeString(leaf.AsDER)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		return driver.NewCertResult(cert), raw, nil
+	}
+
+	// feeds without the raw certificate must send its SHA256 hash
+	hash, err := hex.DecodeString(strings.ReplaceAll(leaf.SHA256, ":", ""))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(hash) != len(fingerprint.Fingerprint{}) {
+		return nil, nil, fmt.Errorf("certificate has no raw bytes or SHA256 fingerprint")
+	}
+	cert := &driver.CertResult{
+		Fingerprint: fingerprint.FromHashBytes(hash),
+		NotBefore:   time.Unix(int64(leaf.NotBefore), 0),
+		NotAfter:    time.Unix(int64(leaf.NotAfter), 0),
+	}
+	for _, domain := range leaf.AllDomains {
+		cert.Domains = append(cert.Domains, strings.ToLower(domain))
+	}
+	return cert, nil, nil
+}
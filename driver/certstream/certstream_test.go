@@ -0,0 +1,61 @@
+package certstream
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/fingerprint"
+	"golang.org/x/net/websocket"
+)
+
+const exampleSHA256 = "46A1FE1780FD9A05A5529906ED08A5FEA2CFE63567C9FDEB62C18BA74FAE35D5"
+
+var feedMessages = []string{
+	`{"message_type": "heartbeat", "timestamp": 1700000000.0}`,
+	`{"message_type": "certificate_update", "data": {"leaf_cert": {"all_domains": ["example.com", "www.example.com"], "not_before": 1700000000.0, "not_after": 1800000000.0, "sha256": "` + exampleSHA256 + `"}}}`,
+	`{"message_type": "certificate_update", "data": {"leaf_cert": {"all_domains": ["other.test"], "not_before": 1700000000.0, "not_after": 1800000000.0, "sha256": "0000000000000000000000000000000000000000000000000000000000000001"}}}`,
+}
+
+func TestQueryDomain(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		for _, msg := range feedMessages {
+			if err := websocket.Message.Send(conn, msg); err != nil {
+				return
+			}
+		}
+		// hold the connection open until the client disconnects
+		var discard string
+		websocket.Message.Receive(conn, &discard)
+	}))
+	defer server.Close()
+
+	d, err := Driver(5*time.Second, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*certstream).url = "ws" + strings.TrimPrefix(server.URL, "http")
+	d.(*certstream).window = time.Second
+
+	result, err := d.QueryDomain(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fps := fingerprints["www.example.com"]
+	if len(fps) != 1 || fps[0] != fingerprint.FromHexHash(exampleSHA256) {
+		t.Fatalf("expected only the example.com certificate, got %v", fingerprints)
+	}
+	cert, err := result.QueryCert(context.Background(), fps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Domains) != 2 || !cert.NotBefore.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected certificate details: %+v", cert)
+	}
+}
@@ -9,10 +9,13 @@
 package crtsh
 
 import (
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/lanrat/certgraph/driver"
@@ -24,25 +27,35 @@ import (
 const connStr = "postgresql://guest@crt.sh/certwatch?sslmode=disable&fallback_application_name=certgraph&binary_parameters=yes"
 const driverName = "crtsh"
 
-const debug = false
+// DefaultMaxParallel is the default number of concurrent queries to crt.sh, more quickly gets rate limited
+const DefaultMaxParallel = 4
 
 func init() {
-	driver.AddDriver(driverName)
+	driver.Register(driverName, driver.Info{CT: true, DefaultParallel: DefaultMaxParallel}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(1000, o.MaxCertSANs, o.Timeout, o.SavePath, o.IncludeSubdomains, o.IncludeExpired, o.IncludeCN, o.Ordered, o.AsOf, o.IssuedSince, o.DumpQueries)
+	})
 }
 
 type crtsh struct {
 	db                *sql.DB
 	queryLimit        int
+	certLimit         sql.NullInt64 // maximum number of domains returned for a certificate
 	timeout           time.Duration
 	save              bool
 	savePath          string
 	includeSubdomains bool
 	includeExpired    bool
+	includeCN         bool
+	ordered           bool
+	asOf              sql.NullTime
+	issuedSince       sql.NullTime
+	debug             bool // log the queries sent and the number of results
 }
 
 type crtshCertDriver struct {
 	host         string
 	fingerprints driver.FingerprintMap
+	notBefore    map[fingerprint.Fingerprint]time.Time
 	driver       *crtsh
 }
 
@@ -50,6 +63,11 @@ func (c *crtshCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
 	return c.fingerprints, nil
 }
 
+func (c *crtshCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	t, ok := c.notBefore[fp]
+	return t, ok
+}
+
 func (c *crtshCertDriver) GetStatus() status.Map {
 	return status.NewMap(c.host, status.New(status.CT))
 }
@@ -58,16 +76,37 @@ func (c *crtshCertDriver) GetRelated() ([]string, error) {
 	return make([]string, 0), nil
 }
 
-func (c *crtshCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
-	return c.driver.QueryCert(fp)
+func (c *crtshCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	return c.driver.QueryCert(ctx, fp)
 }
 
 // Driver creates a new CT driver for crt.sh
-func Driver(maxQueryResults int, timeout time.Duration, savePath string, includeSubdomains, includeExpired bool) (driver.Driver, error) {
+// if includeCN is false only dNSName SANs are searched and returned
+// if ordered is true the certificates returned when maxQueryResults is reached are always the oldest, at the cost of a slower query
+// if asOf is not zero only certificates valid at that time are returned, including expired certificates
+// if issuedSince is not zero only certificates issued at or after that time are returned
+// if maxCertSANs is not zero at most that many domains are returned for each certificate
+// if debug is true the SQL queries are logged
+func Driver(maxQueryResults, maxCertSANs int, timeout time.Duration, savePath string, includeSubdomains, includeExpired, includeCN, ordered bool, asOf, issuedSince time.Time, debug bool) (driver.Driver, error) {
 	d := new(crtsh)
+	d.debug = debug
 	d.queryLimit = maxQueryResults
+	d.timeout = timeout
+	if maxCertSANs > 0 {
+		// query one extra domain to know when the certificate's domains are truncated
+		d.certLimit = sql.NullInt64{Int64: int64(maxCertSANs) + 1, Valid: true}
+	}
 	d.includeSubdomains = includeSubdomains
 	d.includeExpired = includeExpired
+	d.includeCN = includeCN
+	d.ordered = ordered
+	if !asOf.IsZero() {
+		d.asOf = sql.NullTime{Time: asOf, Valid: true}
+		d.includeExpired = true
+	}
+	if !issuedSince.IsZero() {
+		d.issuedSince = sql.NullTime{Time: issuedSince, Valid: true}
+	}
 	var err error
 
 	if len(savePath) > 0 {
@@ -94,21 +133,39 @@ func (d *crtsh) setSQLTimeout(sec float64) error {
 	return err
 }
 
-func (d *crtsh) QueryDomain(domain string) (driver.Result, error) {
+// withTimeout returns a context for a single query which is canceled after the driver's timeout
+func (d *crtsh) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.timeout)
+}
+
+func (d *crtsh) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
 	results := &crtshCertDriver{
 		host:         domain,
 		fingerprints: make(driver.FingerprintMap),
+		notBefore:    make(map[fingerprint.Fingerprint]time.Time),
 		driver:       d,
 	}
 
-	queryStr := `WITH myconstants (include_expired, include_subdomains) as (
-		values ($1::bool, $2::bool)
+	// sort before limiting the results so the same certificates are returned every time
+	orderBy := ""
+	if d.ordered {
+		orderBy = "ORDER BY cai.CERTIFICATE_ID"
+	}
+
+	queryStr := `WITH myconstants (include_expired, include_subdomains, include_cn, as_of, issued_since) as (
+		values ($1::bool, $2::bool, $5::bool, $6::timestamp, $7::timestamp)
 	 ),
 	 ci AS (
 		 SELECT digest(sub.CERTIFICATE, 'sha256') sha256, -- added
 				min(sub.CERTIFICATE_ID) ID,
 				min(sub.ISSUER_CA_ID) ISSUER_CA_ID,
-				array_agg(DISTINCT sub.NAME_VALUE) NAME_VALUES
+				array_agg(DISTINCT sub.NAME_VALUE) NAME_VALUES,
+				x509_notBefore(sub.CERTIFICATE) NOT_BEFORE
 			 FROM (SELECT *
 					   FROM certificate_and_identities cai, myconstants
 					   WHERE plainto_tsquery('certwatch', $4) @@ identities(cai.CERTIFICATE)
@@ -121,7 +178,7 @@ func (d *crtsh) QueryDomain(domain string) (driver.Result, error) {
 						  )
 						   AND (
 							   -- added
-							   cai.NAME_TYPE = '2.5.4.3' -- commonName
+							   (myconstants.include_cn AND cai.NAME_TYPE = '2.5.4.3') -- commonName
 							   OR
 								 cai.NAME_TYPE = 'san:dNSName' -- dNSName
 							   )
@@ -129,12 +186,21 @@ func (d *crtsh) QueryDomain(domain string) (driver.Result, error) {
 							   -- include expired?
 							   (myconstants.include_expired OR (coalesce(x509_notAfter(cai.CERTIFICATE), 'infinity'::timestamp) >= date_trunc('year', now() AT TIME ZONE 'UTC')
 							   AND x509_notAfter(cai.CERTIFICATE) >= now() AT TIME ZONE 'UTC'))
+						   AND
+							   -- valid at a historical date?
+							   (myconstants.as_of IS NULL OR (x509_notBefore(cai.CERTIFICATE) <= myconstants.as_of
+							   AND x509_notAfter(cai.CERTIFICATE) >= myconstants.as_of))
+						   AND
+							   -- recently issued?
+							   (myconstants.issued_since IS NULL OR x509_notBefore(cai.CERTIFICATE) >= myconstants.issued_since)
+					   ` + orderBy + `
 					   LIMIT $3
 				  ) sub
 			 GROUP BY sub.CERTIFICATE
 	 )
 	 SELECT
-		 ci.sha256 -- added
+		 ci.sha256, -- added
+		 ci.NOT_BEFORE
 		 --array_to_string(ci.name_values, chr(10)) name_value,
 		 --ci.id id
 		 FROM ci;`
@@ -145,14 +211,14 @@ func (d *crtsh) QueryDomain(domain string) (driver.Result, error) {
 	for try < 5 {
 		// this is a hack while crt.sh gets there stuff togeather
 		try++
-		if debug {
-			log.Printf("QueryDomain try %d: %s", try, queryStr)
+		if d.debug {
+			log.Printf("crtsh: query for %s try %d: %s with args %v", domain, try, queryStr, []interface{}{d.includeExpired, d.includeSubdomains, d.queryLimit, domain, d.includeCN, d.asOf, d.issuedSince})
 		}
-		rows, err = d.db.Query(queryStr, d.includeExpired, d.includeSubdomains, d.queryLimit, domain)
-		if err == nil {
+		rows, err = d.db.QueryContext(ctx, queryStr, d.includeExpired, d.includeSubdomains, d.queryLimit, domain, d.includeCN, d.asOf, d.issuedSince)
+		if err == nil || ctx.Err() != nil {
 			break
 		}
-		if debug {
+		if d.debug {
 			log.Printf("crtsh pq error on domain %q: %s", domain, err.Error())
 		}
 	}
@@ -162,29 +228,40 @@ func (d *crtsh) QueryDomain(domain string) (driver.Result, error) {
 	if err != nil {
 		return results, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var hash []byte
-		err = rows.Scan(&hash)
+		var notBefore time.Time
+		err = rows.Scan(&hash, &notBefore)
 		if err != nil {
 			return results, err
 		}
-		results.fingerprints.Add(domain, fingerprint.FromHashBytes(hash))
+		fp, err := fingerprint.FromHashBytesChecked(hash)
+		if err != nil {
+			log.Printf("crtsh: skipping certificate for %s: %s", domain, err)
+			continue
+		}
+		results.fingerprints.Add(domain, fp)
+		results.notBefore[fp] = notBefore
 	}
 
-	if debug {
+	if d.debug {
 		log.Printf("crtsh: got %d results for %s.", len(results.fingerprints[domain]), domain)
 	}
 
-	return results, nil
+	return results, rows.Err()
 }
 
-func (d *crtsh) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+func (d *crtsh) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
 	certNode := new(driver.CertResult)
 	certNode.Fingerprint = fp
 	certNode.Domains = make([]string, 0, 5)
 
-	queryStr := `SELECT DISTINCT name_value FROM certificate_and_identities WHERE digest(certificate, 'sha256') = $1;`
+	// sort before limiting the domains so the same ones are returned every time
+	queryStr := `SELECT DISTINCT name_value, x509_notBefore(certificate), x509_notAfter(certificate), x509_keyAlgorithm(certificate), x509_keySize(certificate), x509_issuerName(certificate) FROM certificate_and_identities WHERE digest(certificate, 'sha256') = $1 AND ($2::bool OR name_type = 'san:dNSName') ORDER BY name_value LIMIT $3;`
 
 	try := 0
 	var err error
@@ -192,8 +269,8 @@ func (d *crtsh) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error
 	for try < 5 {
 		// this is a hack while crt.sh gets there stuff togeather
 		try++
-		rows, err = d.db.Query(queryStr, fp[:])
-		if err == nil {
+		rows, err = d.db.QueryContext(ctx, queryStr, fp[:], d.includeCN, d.certLimit)
+		if err == nil || ctx.Err() != nil {
 			break
 		}
 	}
@@ -203,24 +280,42 @@ func (d *crtsh) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error
 	if err != nil {
 		return certNode, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var domain string
-		err = rows.Scan(&domain)
+		var keySize sql.NullInt64
+		var keyAlgorithm sql.NullString
+		var issuer sql.NullString
+		err = rows.Scan(&domain, &certNode.NotBefore, &certNode.NotAfter, &keyAlgorithm, &keySize, &issuer)
 		if err != nil {
 			return nil, err
 		}
 		certNode.Domains = append(certNode.Domains, domain)
+		certNode.KeyAlgorithm = keyAlgorithm.String
+		certNode.KeySize = int(keySize.Int64)
+		certNode.IssuerCN, certNode.IssuerOrgs = parseIssuerName(issuer.String)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if d.certLimit.Valid && int64(len(certNode.Domains)) == d.certLimit.Int64 {
+		certNode.Domains = certNode.Domains[:len(certNode.Domains)-1]
+		log.Printf("crtsh: certificate %s has more than %d domains, truncating", fp.HexString(), len(certNode.Domains))
 	}
 
 	if d.save {
 		var rawCert []byte
-		queryStr = `SELECT certificate FORM certificate_and_identities WHERE digest(certificate, 'sha256') = $1;`
-		row := d.db.QueryRow(queryStr, fp[:])
+		queryStr = `SELECT certificate FROM certificate_and_identities WHERE digest(certificate, 'sha256') = $1 LIMIT 1;`
+		row := d.db.QueryRowContext(ctx, queryStr, fp[:])
 		err = row.Scan(&rawCert)
 		if err != nil {
 			return certNode, err
 		}
+		err = driver.VerifyRawCert(fp, rawCert)
+		if err != nil {
+			return certNode, err
+		}
 
 		err = driver.RawCertToPEMFile(rawCert, path.Join(d.savePath, fp.HexString())+".pem")
 		if err != nil {
@@ -230,3 +325,57 @@ func (d *crtsh) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error
 
 	return certNode, nil
 }
+
+// parseIssuerName returns the common name and organizations of a distinguished name
+// in the form returned by x509_issuerName, ex: `C=US, O="DigiCert, Inc.", CN=R3`
+func parseIssuerName(name string) (string, []string) {
+	var cn string
+	var orgs []string
+	for _, attribute := range splitName(name) {
+		parts := strings.SplitN(attribute, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "CN":
+			cn = parts[1]
+		case "O":
+			orgs = append(orgs, parts[1])
+		}
+	}
+	return cn, orgs
+}
+
+// splitName splits a distinguished name into its "type=value" attributes at the commas and pluses between them
+// values may be double quoted or escape characters with a backslash, the quotes and escapes are removed
+func splitName(name string) []string {
+	var attributes []string
+	var attribute strings.Builder
+	quoted := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '\\' && i+2 < len(name) && isHexPair(name[i+1:i+3]):
+			b, _ := hex.DecodeString(name[i+1 : i+3])
+			attribute.Write(b)
+			i += 2
+		case c == '\\' && i+1 < len(name):
+			attribute.WriteByte(name[i+1])
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ',' || c == '+'):
+			attributes = append(attributes, strings.TrimSpace(attribute.String()))
+			attribute.Reset()
+		default:
+			attribute.WriteByte(c)
+		}
+	}
+	return append(attributes, strings.TrimSpace(attribute.String()))
+}
+
+// isHexPair returns true if s is a hex encoded byte
+func isHexPair(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
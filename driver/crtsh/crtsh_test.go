@@ -0,0 +1,29 @@
+package crtsh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIssuerName(t *testing.T) {
+	tests := []struct {
+		name string
+		cn   string
+		orgs []string
+	}{
+		{"C=US, O=Let's Encrypt, CN=R3", "R3", []string{"Let's Encrypt"}},
+		{`C=US, O="DigiCert, Inc.", CN=DigiCert TLS RSA SHA256 2020 CA1`, "DigiCert TLS RSA SHA256 2020 CA1", []string{"DigiCert, Inc."}},
+		{`C=US, O=Example\, Inc., CN=Example CA`, "Example CA", []string{"Example, Inc."}},
+		{`O=Example \"Quoted\" Org, CN=a\=b`, "a=b", []string{`Example "Quoted" Org`}},
+		{`O=Caf\C3\A9, CN=CA`, "CA", []string{"Café"}},
+		{"O=One + O=Two, CN=CA", "CA", []string{"One", "Two"}},
+		{"C=US", "", nil},
+		{"", "", nil},
+	}
+	for _, test := range tests {
+		cn, orgs := parseIssuerName(test.name)
+		if cn != test.cn || !reflect.DeepEqual(orgs, test.orgs) {
+			t.Errorf("parseIssuerName(%q) = %q, %q, expected %q, %q", test.name, cn, orgs, test.cn, test.orgs)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package csvimport implements a certgraph driver serving certificates from a CSV file of fingerprint,domain rows
+// this allows external certificate datasets, such as CT log exports, to be graphed without querying the network
+package csvimport
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/driver/multi"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+const driverName = "csv"
+
+// Import is a driver for the certificates loaded from a CSV file
+type Import struct {
+	certs   map[fingerprint.Fingerprint]*driver.CertResult
+	domains map[string][]fingerprint.Fingerprint
+}
+
+type importResult struct {
+	host   string
+	parent *Import
+}
+
+func (c *importResult) GetFingerprints() (driver.FingerprintMap, error) {
+	fingerprints := make(driver.FingerprintMap)
+	for _, fp := range c.parent.domains[c.host] {
+		fingerprints.Add(c.host, fp)
+	}
+	return fingerprints, nil
+}
+
+func (c *importResult) GetStatus() status.Map {
+	return status.NewMap(c.host, status.New(status.UNKNOWN))
+}
+
+func (c *importResult) GetRelated() ([]string, error) {
+	return make([]string, 0), nil
+}
+
+func (c *importResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	cert, found := c.parent.certs[fp]
+	if !found {
+		return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+	}
+	certResult := *cert
+	certResult.Domains = append([]string(nil), cert.Domains...)
+	return &certResult, nil
+}
+
+// Driver creates a new driver for the CSV file at path
+// each row must contain a hex encoded SHA256 certificate fingerprint and a domain in the certificate
+// an optional header row starting with "fingerprint" is skipped
+func Driver(path string) (*Import, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads fingerprint,domain rows from r and returns a driver for them
+func Load(r io.Reader) (*Import, error) {
+	d := &Import{
+		certs:   make(map[fingerprint.Fingerprint]*driver.CertResult),
+		domains: make(map[string][]fingerprint.Fingerprint),
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && strings.EqualFold(record[0], "fingerprint") {
+			continue
+		}
+		hash, err := hex.DecodeString(strings.TrimSpace(record[0]))
+		if err != nil || len(hash) != len(fingerprint.Fingerprint{}) {
+			return nil, fmt.Errorf("invalid SHA256 fingerprint %q on row %d", record[0], row)
+		}
+		fp := fingerprint.FromHashBytes(hash)
+		domain := strings.ToLower(strings.TrimSpace(record[1]))
+		if len(domain) == 0 {
+			return nil, fmt.Errorf("missing domain on row %d", row)
+		}
+		d.add(fp, domain)
+	}
+	return d, nil
+}
+
+// add adds the domain to the certificate if it was not already added
+func (d *Import) add(fp fingerprint.Fingerprint, domain string) {
+	cert, found := d.certs[fp]
+	if !found {
+		cert = &driver.CertResult{Fingerprint: fp}
+		d.certs[fp] = cert
+	}
+	for _, certDomain := range cert.Domains {
+		if certDomain == domain {
+			return
+		}
+	}
+	cert.Domains = append(cert.Domains, domain)
+	// wildcard certificates are found by querying the domain they cover
+	domain = strings.TrimPrefix(domain, "*.")
+	for _, domainFP := range d.domains[domain] {
+		if domainFP == fp {
+			return
+		}
+	}
+	d.domains[domain] = append(d.domains[domain], fp)
+}
+
+// Domains returns a sorted list of all the domains imported
+func (d *Import) Domains() []string {
+	domains := make([]string, 0, len(d.domains))
+	for domain := range d.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// GetName returns the name of the driver
+func (d *Import) GetName() string {
+	return driverName
+}
+
+// QueryDomain returns the imported certificates for the domain
+func (d *Import) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	return &importResult{host: domain, parent: d}, nil
+}
+
+// Crawl returns a driver merging the imported certificates with the results of crawlDriver
+// domains that crawlDriver fails to query still return their imported certificates
+func (d *Import) Crawl(crawlDriver driver.Driver) driver.Driver {
+	return &crawlImport{
+		Driver: multi.Driver([]driver.Driver{d, crawlDriver}),
+		parent: d,
+	}
+}
+
+type crawlImport struct {
+	driver.Driver
+	parent *Import
+}
+
+func (c *crawlImport) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	result, err := c.Driver.QueryDomain(ctx, domain)
+	if err != nil {
+		return c.parent.QueryDomain(ctx, domain)
+	}
+	return result, nil
+}
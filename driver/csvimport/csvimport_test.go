@@ -0,0 +1,127 @@
+package csvimport_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/driver/csvimport"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+const fpHex = "46a1fe1780fd9a05a5529906ed08a5fea2cfe63567c9fdeb62c18ba74fae35d5"
+
+func TestLoad(t *testing.T) {
+	input := "fingerprint,domain\n" +
+		fpHex + ",example.com\n" +
+		fpHex + ",*.Example.com\n" +
+		fpHex + ",www.example.org\n"
+	d, err := csvimport.Load(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domains := d.Domains(); !reflect.DeepEqual(domains, []string{"example.com", "www.example.org"}) {
+		t.Errorf("unexpected domains: %v", domains)
+	}
+
+	result, err := d.QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := fingerprint.FromHexHash(fpHex)
+	if !reflect.DeepEqual(fingerprints["example.com"], []fingerprint.Fingerprint{fp}) {
+		t.Fatalf("unexpected fingerprints: %v", fingerprints)
+	}
+	cert, err := result.QueryCert(context.Background(), fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cert.Domains, []string{"example.com", "*.example.com", "www.example.org"}) {
+		t.Errorf("unexpected certificate domains: %v", cert.Domains)
+	}
+}
+
+func TestLoadInvalidFingerprint(t *testing.T) {
+	_, err := csvimport.Load(strings.NewReader("abcd,example.com\n"))
+	if err == nil {
+		t.Errorf("expected invalid fingerprint to return an error")
+	}
+}
+
+// crawlDriver returns a single certificate for every domain queried
+type crawlDriver struct {
+	cert *driver.CertResult
+}
+
+func (d *crawlDriver) GetName() string {
+	return "crawl"
+}
+
+func (d *crawlDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	return &crawlResult{host: domain, cert: d.cert}, nil
+}
+
+type crawlResult struct {
+	host string
+	cert *driver.CertResult
+}
+
+func (r *crawlResult) GetStatus() status.Map {
+	return status.NewMap(r.host, status.New(status.GOOD))
+}
+
+func (r *crawlResult) GetRelated() ([]string, error) {
+	return nil, nil
+}
+
+func (r *crawlResult) GetFingerprints() (driver.FingerprintMap, error) {
+	fpm := make(driver.FingerprintMap)
+	fpm.Add(r.host, r.cert.Fingerprint)
+	return fpm, nil
+}
+
+func (r *crawlResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	if fp != r.cert.Fingerprint {
+		return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+	}
+	return r.cert, nil
+}
+
+func TestCrawlPastImport(t *testing.T) {
+	d, err := csvimport.Load(strings.NewReader(fpHex + ",example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crawled := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("crawled")), Domains: []string{"example.com", "www.example.net"}}
+	result, err := d.Crawl(&crawlDriver{cert: crawled}).QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints["example.com"]) != 2 {
+		t.Fatalf("expected the imported and crawled fingerprints, got %v", fingerprints)
+	}
+	for _, fp := range fingerprints["example.com"] {
+		cert, err := result.QueryCert(context.Background(), fp)
+		if err != nil {
+			t.Fatalf("%s: %v", fp.HexString(), err)
+		}
+		if fp == crawled.Fingerprint && !reflect.DeepEqual(cert.Domains, crawled.Domains) {
+			t.Errorf("unexpected crawled certificate domains: %v", cert.Domains)
+		}
+	}
+	if _, err := result.QueryCert(context.Background(), fingerprint.FromRawCertBytes([]byte("missing"))); err == nil {
+		t.Errorf("expected an error for a certificate no driver found")
+	}
+}
@@ -2,20 +2,33 @@
 package driver
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/lanrat/certgraph/fingerprint"
 	"github.com/lanrat/certgraph/status"
 )
 
-// TODO add context instead of timeout on all requests
+// ctPoisonOID is the certificate transparency precertificate poison extension (RFC 6962 section 3.1)
+var ctPoisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// ErrFingerprintMismatch is returned when a certificate's DER bytes do not hash to the fingerprint returned for it
+var ErrFingerprintMismatch = errors.New("certificate fingerprint is not the SHA256 hash of its DER bytes")
 
 // Drivers contains all the drivers that have been registered
 var Drivers []string
 
-// AddDriver should be called in the init() function of every driver to register them here
+// AddDriver adds the driver's name to Drivers, it is called by Register
 func AddDriver(name string) {
 	Drivers = append(Drivers, name)
 }
@@ -25,7 +38,8 @@ type Driver interface {
 	// QueryDomain is the main entrypoint for Driver Searching
 	// The domain provided will return a CertDriver instance which can be used to query the
 	// certificates for the provided domain using the driver
-	QueryDomain(domain string) (Result, error)
+	// the query is canceled when ctx is done
+	QueryDomain(ctx context.Context, domain string) (Result, error)
 
 	// GetName returns the name of the driver
 	GetName() string
@@ -44,7 +58,47 @@ type Result interface {
 	GetFingerprints() (FingerprintMap, error)
 
 	// QueryCert returns the details of the provided certificate or an error if not found
-	QueryCert(fp fingerprint.Fingerprint) (*CertResult, error)
+	// the query is canceled when ctx is done
+	QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*CertResult, error)
+}
+
+// ValidityResult is an optional interface for Results that know the validity period of the
+// certificates they found without needing to call QueryCert
+type ValidityResult interface {
+	// GetNotBefore returns the NotBefore date of the certificate and true if it is known
+	GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool)
+}
+
+// SourceResult is an optional interface for Results combining multiple drivers that know
+// which of the drivers found each certificate and related domain
+type SourceResult interface {
+	// GetCertSources returns the names of the drivers that found the certificate for the domain
+	GetCertSources(domain string, fp fingerprint.Fingerprint) []string
+
+	// GetRelatedSources returns the names of the drivers that returned the related domain
+	GetRelatedSources(related string) []string
+}
+
+// CertSources returns the names of the drivers that found the certificate for the domain in the result
+// name is returned if the result does not implement SourceResult or does not know the drivers
+func CertSources(r Result, name string, domain string, fp fingerprint.Fingerprint) []string {
+	if sr, ok := r.(SourceResult); ok {
+		if sources := sr.GetCertSources(domain, fp); len(sources) > 0 {
+			return sources
+		}
+	}
+	return []string{name}
+}
+
+// RelatedSources returns the names of the drivers that returned the related domain in the result
+// name is returned if the result does not implement SourceResult or does not know the drivers
+func RelatedSources(r Result, name string, related string) []string {
+	if sr, ok := r.(SourceResult); ok {
+		if sources := sr.GetRelatedSources(related); len(sources) > 0 {
+			return sources
+		}
+	}
+	return []string{name}
 }
 
 // FingerprintMap stores a mapping of domains to Fingerprints returned from the driver
@@ -58,10 +112,24 @@ func (f FingerprintMap) Add(domain string, fp fingerprint.Fingerprint) {
 	f[domain] = append(f[domain], fp)
 }
 
-// CertResult is an object to hold the fingerprint and Domains for a returned certificate
+// CertResult is an object to hold the fingerprint, Domains, and validity period for a returned certificate
 type CertResult struct {
-	Fingerprint fingerprint.Fingerprint
-	Domains     []string
+	Fingerprint    fingerprint.Fingerprint
+	Domains        []string
+	NotBefore      time.Time
+	NotAfter       time.Time
+	Organizations  []string                // subject organizations, if known
+	KeyAlgorithm   string                  // public key algorithm, ex: RSA, ECDSA, Ed25519
+	KeySize        int                     // public key size in bits
+	SignatureAlgo  string                  // algorithm the issuer signed the certificate with, ex: SHA256-RSA
+	Tags           []string                // driver specific certificate tags, only set by censys
+	DuplicateSANs  int                     // number of SANs repeated or covered by a wildcard SAN, only known from the raw certificate
+	Precert        bool                    // true if the certificate is a CT precertificate
+	AuthorityKeyID string                  // hex encoded key identifier of the issuer's public key, if known
+	SubjectKeyID   string                  // hex encoded key identifier of the certificate's public key, if known
+	Issuer         fingerprint.Fingerprint // fingerprint of the certificate sent in the chain after this one, zero if unknown
+	IssuerCN       string                  // issuer's common name, if known
+	IssuerOrgs     []string                // issuer's organizations, if known
 }
 
 // NewCertResult creates a new CertResult struct from an x509 cert
@@ -71,6 +139,30 @@ func NewCertResult(cert *x509.Certificate) *CertResult {
 	// generate Fingerprint
 	certResult.Fingerprint = fingerprint.FromRawCertBytes(cert.Raw)
 
+	// validity
+	certResult.NotBefore = cert.NotBefore
+	certResult.NotAfter = cert.NotAfter
+
+	// organizations
+	certResult.Organizations = cert.Subject.Organization
+
+	// issuer
+	certResult.IssuerCN = cert.Issuer.CommonName
+	certResult.IssuerOrgs = cert.Issuer.Organization
+
+	// public key
+	certResult.KeyAlgorithm = cert.PublicKeyAlgorithm.String()
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		certResult.KeySize = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		certResult.KeySize = pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		certResult.KeySize = 8 * ed25519.PublicKeySize
+	}
+
+	certResult.SignatureAlgo = cert.SignatureAlgorithm.String()
+
 	// domains
 	// used to ensure uniq entries in domains array
 	domainMap := make(map[string]bool)
@@ -89,6 +181,59 @@ func NewCertResult(cert *x509.Certificate) *CertResult {
 		certResult.Domains = append(certResult.Domains, domain)
 	}
 	sort.Strings(certResult.Domains)
+	certResult.DuplicateSANs = duplicateSANs(cert.DNSNames)
+
+	// key identifiers link the certificate to its issuer
+	certResult.AuthorityKeyID = hex.EncodeToString(cert.AuthorityKeyId)
+	certResult.SubjectKeyID = hex.EncodeToString(cert.SubjectKeyId)
+
+	// precertificates carry the CT poison extension
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ctPoisonOID) {
+			certResult.Precert = true
+			break
+		}
+	}
 
 	return certResult
 }
+
+// VerifyRawCert returns ErrFingerprintMismatch if the DER encoded certificate does not hash to fp
+// every driver identifies certificates by the SHA256 hash of the DER bytes of the certificate it found,
+// drivers given the hash by an API use this to check it when the API also returns the certificate
+// so the same certificate found by different drivers is only added to the graph once
+func VerifyRawCert(fp fingerprint.Fingerprint, raw []byte) error {
+	if !fp.MatchesRawCert(raw) {
+		return fmt.Errorf("%w: %s", ErrFingerprintMismatch, fp.HexString())
+	}
+	return nil
+}
+
+// Expired returns true if the certificate's validity ended before now
+// certificates with an unknown validity are never expired
+func (c *CertResult) Expired(now time.Time) bool {
+	return !c.NotAfter.IsZero() && now.After(c.NotAfter)
+}
+
+// duplicateSANs returns the number of names that are repeated
+// or are redundant with a wildcard name in the same certificate
+func duplicateSANs(names []string) int {
+	duplicates := 0
+	sans := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if sans[name] {
+			duplicates++
+		}
+		sans[name] = true
+	}
+	for name := range sans {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		if i := strings.Index(name, "."); i > 0 && sans["*"+name[i:]] {
+			duplicates++
+		}
+	}
+	return duplicates
+}
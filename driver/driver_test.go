@@ -0,0 +1,181 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	_ "github.com/lanrat/certgraph/driver/censys"      // register the censys driver
+	_ "github.com/lanrat/certgraph/driver/certspotter" // register the certspotter driver
+	"github.com/lanrat/certgraph/driver/csvimport"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/internal/testcert"
+)
+
+func TestNewCertResultDuplicateSANs(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com", "www.example.com", "WWW.example.com", "*.example.com", "mail.example.org"},
+	}
+	certResult := driver.NewCertResult(cert)
+	// www.example.com is repeated and also covered by *.example.com
+	if certResult.DuplicateSANs != 2 {
+		t.Errorf("expected 2 duplicate SANs, got %d", certResult.DuplicateSANs)
+	}
+	if len(certResult.Domains) != 4 {
+		t.Errorf("expected 4 unique domains, got %v", certResult.Domains)
+	}
+}
+
+func TestNewCertResultPrecert(t *testing.T) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	for _, precert := range []bool{false, true} {
+		template.ExtraExtensions = nil
+		if precert {
+			// CT poison extension: critical with an ASN.1 NULL value
+			template.ExtraExtensions = []pkix.Extension{{
+				Id:       asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3},
+				Critical: true,
+				Value:    []byte{0x05, 0x00},
+			}}
+		}
+		cert, _ := testcert.New(t, template)
+		if certResult := driver.NewCertResult(cert); certResult.Precert != precert {
+			t.Errorf("expected Precert %v, got %v", precert, certResult.Precert)
+		}
+	}
+}
+
+func TestNewCertResultAlgorithms(t *testing.T) {
+	notBefore := time.Now().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(time.Hour),
+	}
+	cert, _ := testcert.New(t, template)
+	certResult := driver.NewCertResult(cert)
+	if certResult.SignatureAlgo != "ECDSA-SHA256" || certResult.KeyAlgorithm != "ECDSA" || certResult.KeySize != 256 {
+		t.Errorf("unexpected algorithms: signature %s, key %s-%d", certResult.SignatureAlgo, certResult.KeyAlgorithm, certResult.KeySize)
+	}
+	if !certResult.NotBefore.Equal(notBefore) || !certResult.NotAfter.Equal(notBefore.Add(time.Hour)) {
+		t.Errorf("unexpected validity %s to %s", certResult.NotBefore, certResult.NotAfter)
+	}
+}
+
+// TestFingerprintConsistency checks the drivers parsing certificates and the drivers given hashes
+// fingerprint the same certificate the same way, as the SHA256 of its DER bytes
+// the censys and certspotter drivers are run against fake APIs returning the hash and certificate
+func TestFingerprintConsistency(t *testing.T) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, _ := testcert.New(t, template)
+	der := cert.Raw
+	hash := sha256.Sum256(der)
+
+	// the http, smtp, imap, and pop3 drivers
+	live := driver.NewCertResult(cert).Fingerprint
+
+	hexHash := hex.EncodeToString(hash[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(r.URL.Path) {
+		case "/censys/search/certificates":
+			fmt.Fprintf(w, `{"status":"ok","results":[{"parsed.fingerprint_sha256":%q}]}`, hexHash)
+		case "/censys/view/certificates/" + hexHash:
+			fmt.Fprintf(w, `{"raw":%q,"fingerprint_sha256":%q}`, base64.StdEncoding.EncodeToString(der), hexHash)
+		case "/certspotter/v1/issuances":
+			fmt.Fprintf(w, `[{"id":"1","cert_sha256":%q,"dns_names":["example.com"],"not_after":%q}]`, hexHash, template.NotAfter.Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	for name, value := range map[string]string{
+		"censys-appid":    "id",
+		"censys-secret":   "secret",
+		"censys-url":      server.URL + "/censys",
+		"certspotter-url": server.URL + "/certspotter/v1/issuances",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the drivers given a hash by an API or file
+	fingerprints := make(map[string]fingerprint.Fingerprint)
+	crtsh, err := fingerprint.FromHashBytesChecked(hash[:]) // crtsh returns the digest bytes
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints["crtsh"] = crtsh
+	imported, err := csvimport.Load(strings.NewReader(hexHash + ",example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drivers := map[string]driver.Driver{"csv": imported}
+	for _, name := range []string{"censys", "certspotter"} {
+		d, err := driver.New(name, driver.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		drivers[name] = d
+	}
+	for name, d := range drivers {
+		result, err := d.QueryDomain(context.Background(), "example.com")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		domainFingerprints, err := result.GetFingerprints()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(domainFingerprints["example.com"]) != 1 {
+			t.Fatalf("%s: expected 1 certificate, got %v", name, domainFingerprints)
+		}
+		fingerprints[name] = domainFingerprints["example.com"][0]
+		// censys checks its hash against the certificate it returns
+		if _, err := result.QueryCert(context.Background(), fingerprints[name]); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	for name, fp := range fingerprints {
+		if fp != live {
+			t.Errorf("%s fingerprint %s does not match the live fingerprint %s", name, fp.HexString(), live.HexString())
+		}
+		if err := driver.VerifyRawCert(fp, der); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	// a hash over different bytes, such as the precertificate, must not be accepted for the certificate
+	if err := driver.VerifyRawCert(fingerprint.FromRawCertBytes(append(der, 0)), der); !errors.Is(err, driver.ErrFingerprintMismatch) {
+		t.Errorf("expected ErrFingerprintMismatch, got %v", err)
+	}
+}
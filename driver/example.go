@@ -1,10 +1,13 @@
 package driver
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Example provides a simple entrypoint to test a driver on an individual domain
 func Example(domain string, driver Driver) error {
-	certDriver, err := driver.QueryDomain(domain)
+	certDriver, err := driver.QueryDomain(context.Background(), domain)
 	if err != nil {
 		return err
 	}
@@ -27,7 +30,7 @@ func Example(domain string, driver Driver) error {
 	for domain, fingerprints := range fingerprintMap {
 		for i := range fingerprints {
 			fmt.Printf("%s: %s\n", domain, fingerprints[i].HexString())
-			cert, err := certDriver.QueryCert(fingerprints[i])
+			cert, err := certDriver.QueryCert(context.Background(), fingerprints[i])
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,82 @@
+// Package filter implements a certgraph driver wrapper that only returns the certificates accepted by filter functions
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+)
+
+// ErrFiltered is returned by QueryCert for certificates rejected by a filter
+var ErrFiltered = errors.New("certificate rejected by filter")
+
+// Func returns true if the certificate should be included in the graph
+type Func func(*driver.CertResult) bool
+
+// Driver wraps a driver rejecting the certificates from its QueryCert calls that do not pass every filter
+// rejected certificates are not added to the graph, so the domains on them are not crawled
+type Driver struct {
+	driver.Driver
+	filters []Func
+}
+
+// Wrap returns a new Driver only returning the certificates from d accepted by every filter
+func Wrap(d driver.Driver, filters ...Func) *Driver {
+	return &Driver{Driver: d, filters: filters}
+}
+
+// QueryDomain calls the wrapped driver's QueryDomain
+func (d *Driver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	result, err := d.Driver.QueryDomain(ctx, domain)
+	if result == nil {
+		return result, err
+	}
+	return &filterResult{Result: result, parent: d}, err
+}
+
+type filterResult struct {
+	driver.Result
+	parent *Driver
+}
+
+// QueryCert calls the wrapped result's QueryCert returning ErrFiltered if the certificate does not pass every filter
+func (r *filterResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	certResult, err := r.Result.QueryCert(ctx, fp)
+	if err != nil {
+		return certResult, err
+	}
+	for _, filter := range r.parent.filters {
+		if !filter(certResult) {
+			return nil, fmt.Errorf("%w: %s", ErrFiltered, fp.HexString())
+		}
+	}
+	return certResult, nil
+}
+
+// GetNotBefore passes through to the wrapped result if it implements driver.ValidityResult
+func (r *filterResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	if vr, ok := r.Result.(driver.ValidityResult); ok {
+		return vr.GetNotBefore(fp)
+	}
+	return time.Time{}, false
+}
+
+// GetCertSources passes through to the wrapped result if it implements driver.SourceResult
+func (r *filterResult) GetCertSources(domain string, fp fingerprint.Fingerprint) []string {
+	if sr, ok := r.Result.(driver.SourceResult); ok {
+		return sr.GetCertSources(domain, fp)
+	}
+	return nil
+}
+
+// GetRelatedSources passes through to the wrapped result if it implements driver.SourceResult
+func (r *filterResult) GetRelatedSources(related string) []string {
+	if sr, ok := r.Result.(driver.SourceResult); ok {
+		return sr.GetRelatedSources(related)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package filter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/driver/filter"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+// fakeDriver is a driver and result returning the certificates in certs
+type fakeDriver struct {
+	certs map[fingerprint.Fingerprint]*driver.CertResult
+}
+
+func (d *fakeDriver) GetName() string {
+	return "fake"
+}
+
+func (d *fakeDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	return d, nil
+}
+
+func (d *fakeDriver) GetStatus() status.Map {
+	return make(status.Map)
+}
+
+func (d *fakeDriver) GetRelated() ([]string, error) {
+	return nil, nil
+}
+
+func (d *fakeDriver) GetFingerprints() (driver.FingerprintMap, error) {
+	return make(driver.FingerprintMap), nil
+}
+
+func (d *fakeDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	return d.certs[fp], nil
+}
+
+func TestQueryCertFiltered(t *testing.T) {
+	rsa := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("rsa")), KeyAlgorithm: "RSA"}
+	ecdsa := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("ecdsa")), KeyAlgorithm: "ECDSA"}
+	d := filter.Wrap(&fakeDriver{certs: map[fingerprint.Fingerprint]*driver.CertResult{
+		rsa.Fingerprint:   rsa,
+		ecdsa.Fingerprint: ecdsa,
+	}}, func(c *driver.CertResult) bool {
+		return c.KeyAlgorithm == "ECDSA"
+	})
+
+	result, err := d.QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := result.QueryCert(context.Background(), rsa.Fingerprint); !errors.Is(err, filter.ErrFiltered) {
+		t.Errorf("expected ErrFiltered for the RSA certificate, got %v", err)
+	}
+	if cert, err := result.QueryCert(context.Background(), ecdsa.Fingerprint); err != nil || cert != ecdsa {
+		t.Errorf("expected the ECDSA certificate, got %v %v", cert, err)
+	}
+}
+
+func TestQueryCertEveryFilter(t *testing.T) {
+	cert := &driver.CertResult{Fingerprint: fingerprint.FromRawCertBytes([]byte("cert")), KeyAlgorithm: "ECDSA", IssuerCN: "R3"}
+	fake := &fakeDriver{certs: map[fingerprint.Fingerprint]*driver.CertResult{cert.Fingerprint: cert}}
+	ecdsa := func(c *driver.CertResult) bool { return c.KeyAlgorithm == "ECDSA" }
+	for issuer, expected := range map[string]bool{"R3": true, "E1": false} {
+		issuer := issuer
+		d := filter.Wrap(fake, ecdsa, func(c *driver.CertResult) bool { return c.IssuerCN == issuer })
+		result, err := d.QueryDomain(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = result.QueryCert(context.Background(), cert.Fingerprint)
+		if (err == nil) != expected {
+			t.Errorf("expected the certificate to pass both filters to be %t for issuer %s, got %v", expected, issuer, err)
+		}
+	}
+}
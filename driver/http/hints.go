@@ -0,0 +1,111 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lanrat/certgraph/status"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxHintBodySize is the maximum number of bytes of a page read looking for link hints in its head
+const maxHintBodySize = 256 * 1024
+
+// hintRels are the link relations naming origins a page will connect to
+var hintRels = map[string]bool{
+	"preconnect":   true,
+	"dns-prefetch": true,
+}
+
+// linkHeaderRegex matches a link in a Link header and its parameters
+var linkHeaderRegex = regexp.MustCompile(`<([^>]*)>([^<]*)`)
+
+// linkRelRegex matches the rel parameter of a link in a Link header
+var linkRelRegex = regexp.MustCompile(`(?i);\s*rel\s*=\s*(?:"([^"]*)"|([^\s;,]+))`)
+
+// addLinkHints adds the hosts of the preconnect and dns-prefetch links in the response's
+// Link headers and HTML head as related domains
+func (c *httpCertDriver) addLinkHints(resp *http.Response) {
+	for _, header := range resp.Header.Values("Link") {
+		for _, match := range linkHeaderRegex.FindAllStringSubmatch(header, -1) {
+			rel := linkRelRegex.FindStringSubmatch(match[2])
+			if rel == nil {
+				continue
+			}
+			c.addLinkHint(resp.Request.URL, match[1], rel[1]+rel[2])
+		}
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		for _, link := range headLinks(io.LimitReader(resp.Body, maxHintBodySize)) {
+			c.addLinkHint(resp.Request.URL, link[0], link[1])
+		}
+	}
+}
+
+// addLinkHint adds the host of href relative to base as a related domain if rel is a hint
+// the status of hosts already found, such as redirects, is not changed
+func (c *httpCertDriver) addLinkHint(base *url.URL, href, rel string) {
+	hint := ""
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if hintRels[r] {
+			hint = r
+			break
+		}
+	}
+	if len(hint) == 0 {
+		return
+	}
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(host) == 0 || host == base.Hostname() {
+		return
+	}
+	if _, found := c.status[host]; found {
+		return
+	}
+	c.status.Set(host, status.NewMeta(status.HINT, hint))
+	c.related = append(c.related, host)
+}
+
+// headLinks returns the href and rel of each link element in the head of the HTML document
+func headLinks(r io.Reader) [][2]string {
+	links := make([][2]string, 0)
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.Head {
+				return links
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return links
+			case atom.Link:
+				var link [2]string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "href":
+						link[0] = string(val)
+					case "rel":
+						link[1] = string(val)
+					}
+				}
+				links = append(links, link)
+			}
+		}
+	}
+}
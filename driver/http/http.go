@@ -2,30 +2,53 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/lanrat/certgraph/dns"
 	"github.com/lanrat/certgraph/driver"
 	"github.com/lanrat/certgraph/fingerprint"
 	"github.com/lanrat/certgraph/status"
+	"golang.org/x/net/http/httpguts"
 )
 
 const driverName = "http"
 
 func init() {
-	driver.AddDriver(driverName)
+	driver.Register(driverName, driver.Info{Live: true}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(o.Timeout, o.SavePath, o.Headers, o.SNIAddresses, o.Ports, o.HostPorts, o.Chain, o.LinkHints, o.IncludeExpired, o.RespectRobots, o.Verbose)
+	})
 }
 
+// defaultPort is the port queried when no ports are provided
+const defaultPort = "443"
+
 type httpDriver struct {
-	port      string
-	save      bool
-	savePath  string
-	tlsConfig *tls.Config
-	timeout   time.Duration
+	ports          []string
+	hostPorts      map[string][]string // ports to connect to for a hostname instead of ports
+	save           bool
+	savePath       string
+	tlsConfig      *tls.Config
+	timeout        time.Duration
+	headers        http.Header
+	addresses      map[string]string // IP address to connect to for a hostname instead of resolving it
+	chain          bool              // return the intermediate certificates sent by the server, not only the leaf
+	linkHints      bool              // return the hosts of preconnect and dns-prefetch links as related domains
+	includeExpired bool              // return expired leaf certificates
+	robots         *robotsCache      // robots.txt rules of each host:port, nil to ignore robots.txt
+	debug          bool              // log the errors ignored after the TLS handshake
 }
 
 type httpCertDriver struct {
@@ -35,6 +58,11 @@ type httpCertDriver struct {
 	status       status.Map
 	related      []string
 	certs        map[fingerprint.Fingerprint]*driver.CertResult
+	lastHost     string            // host of the last completed TLS handshake
+	redirects    map[string]string // type of the redirect that led to each host
+	expired      map[string]bool   // hosts that served an expired certificate
+	disallowed   map[string]bool   // hosts not requested because robots.txt disallows it
+	tls          map[string]string // negotiated TLS version and cipher suite of each host and host:port connected to
 }
 
 func (c *httpCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
@@ -49,7 +77,7 @@ func (c *httpCertDriver) GetRelated() ([]string, error) {
 	return c.related, nil
 }
 
-func (c *httpCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+func (c *httpCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
 	cert, found := c.certs[fp]
 	if found {
 		return cert, nil
@@ -57,10 +85,64 @@ func (c *httpCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResu
 	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
 }
 
+func (c *httpCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	cert, found := c.certs[fp]
+	if !found {
+		return time.Time{}, false
+	}
+	return cert.NotBefore, true
+}
+
+// ParseHeader returns the name and value of a header in the form "Name: Value"
+func ParseHeader(header string) (string, string, error) {
+	i := strings.Index(header, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid header %q, must be in the form \"Name: Value\"", header)
+	}
+	name, value := header[:i], strings.TrimSpace(header[i+1:])
+	if !httpguts.ValidHeaderFieldName(name) {
+		return "", "", fmt.Errorf("invalid header name %q", name)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", "", fmt.Errorf("invalid header value for %s", name)
+	}
+	return name, value, nil
+}
+
 // Driver creates a new SSL driver for HTTP Connections
-func Driver(timeout time.Duration, savePath string) (driver.Driver, error) {
+// headers in the form "Name: Value" are added to every request
+// hostnames in sniAddresses are connected to at the mapped IP address with the hostname sent as the SNI
+// every port in ports is queried, if ports is empty only 443 is queried
+// hostnames in hostPorts are only queried on their mapped ports
+// if chain is true the rest of the certificate chain sent by the server can be queried from the leaf's Issuer
+// if linkHints is true the hosts of preconnect and dns-prefetch links in the response are related domains
+// expired leaf certificates are only returned if includeExpired is true, either way the host's status is marked expired
+// if respectRobots is true the root path is only requested if robots.txt allows it, waiting for its Crawl-delay
+// hosts disallowing it are only connected to for the certificate
+// if debug is true errors ignored after the TLS handshake are logged
+func Driver(timeout time.Duration, savePath string, headers []string, sniAddresses map[string]string, ports []string, hostPorts map[string][]string, chain, linkHints, includeExpired, respectRobots, debug bool) (driver.Driver, error) {
 	d := new(httpDriver)
-	d.port = "443"
+	d.debug = debug
+	if respectRobots {
+		d.robots = &robotsCache{hosts: make(map[string]*robotsEntry)}
+	}
+	d.includeExpired = includeExpired
+	d.chain = chain
+	d.linkHints = linkHints
+	d.ports = ports
+	if len(d.ports) == 0 {
+		d.ports = []string{defaultPort}
+	}
+	d.hostPorts = hostPorts
+	d.addresses = sniAddresses
+	d.headers = make(http.Header)
+	for _, header := range headers {
+		name, value, err := ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		d.headers.Add(name, value)
+	}
 	if len(savePath) > 0 {
 		d.save = true
 		d.savePath = savePath
@@ -83,6 +165,10 @@ func (d *httpDriver) newHTTPCertDriver() *httpCertDriver {
 		status:       make(status.Map),
 		fingerprints: make(driver.FingerprintMap),
 		certs:        make(map[fingerprint.Fingerprint]*driver.CertResult),
+		expired:      make(map[string]bool),
+		disallowed:   make(map[string]bool),
+		tls:          make(map[string]string),
+		redirects:    make(map[string]string),
 	}
 	// set client & client.Transport separately so that dialTLS checkRedirect can be referenced
 	result.client = &http.Client{
@@ -94,26 +180,173 @@ func (d *httpDriver) newHTTPCertDriver() *httpCertDriver {
 		TLSHandshakeTimeout:   d.timeout,
 		ResponseHeaderTimeout: d.timeout,
 		ExpectContinueTimeout: d.timeout,
-		DialTLS:               result.dialTLS,
+		DialTLSContext:        result.dialTLS,
 	}
 	return result
 }
 
 // GetCert gets the certificates found for a given domain
-func (d *httpDriver) QueryDomain(host string) (driver.Result, error) {
+// when querying multiple ports the status of each port is set as host:port
+// an error is only returned if every port failed
+func (d *httpDriver) QueryDomain(ctx context.Context, host string) (driver.Result, error) {
 	results := d.newHTTPCertDriver()
 
-	resp, err := results.client.Get(fmt.Sprintf("https://%s", host))
+	ports := d.ports
+	if hostPorts, ok := d.hostPorts[host]; ok {
+		ports = hostPorts
+	}
+	var firstErr error
+	failed := 0
+	for _, port := range ports {
+		err := results.queryPort(ctx, host, port)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if len(ports) > 1 || port != defaultPort {
+			results.status.Set(net.JoinHostPort(host, port), status.New(status.CheckNetErr(err)))
+		}
+	}
+	for hostPort, tls := range results.tls {
+		results.status.SetTLS(hostPort, tls)
+	}
+	for host, redirect := range results.redirects {
+		results.status.SetRedirect(host, redirect)
+	}
+	if failed == len(ports) {
+		return results, firstErr
+	}
+	return results, nil
+}
+
+// queryPort requests https://host:port adding the certificates found to the results
+func (c *httpCertDriver) queryPort(ctx context.Context, host, port string) error {
+	target := host
+	if port != defaultPort {
+		target = net.JoinHostPort(host, port)
+	}
+	if c.parent.robots != nil {
+		c.lastHost = ""
+		rules, fetched := c.parent.robots.get(target, func() *robots {
+			rules := c.fetchRobots(ctx, target)
+			// fetching robots.txt is the first request to the host
+			rules.next = time.Now().Add(rules.crawlDelay)
+			return rules
+		})
+		if rules.disallowRoot {
+			// the certificate is captured by the TLS handshake in dialTLS without requesting the root path
+			if !fetched || len(c.lastHost) == 0 {
+				u := url.URL{Host: target}
+				addr := net.JoinHostPort(u.Hostname(), defaultPort)
+				if len(u.Port()) > 0 {
+					addr = u.Host
+				}
+				conn, err := c.dialTLS(ctx, "tcp", addr)
+				if err != nil {
+					return err
+				}
+				conn.Close()
+			}
+			c.disallowed[c.lastHost] = true
+			c.setGood(c.lastHost)
+			return nil
+		}
+		err := rules.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s", target), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.parent.headers.Clone()
+	c.lastHost = ""
+	resp, err := c.client.Do(req)
+	// the handshake must be with this port, other ports of the host are recorded in c.tls too
+	handshakePort := req.URL.Port()
+	if len(handshakePort) == 0 {
+		handshakePort = defaultPort
+	}
+	if _, handshake := c.tls[net.JoinHostPort(req.URL.Hostname(), handshakePort)]; err != nil && handshake {
+		// the certificate was already captured during the TLS handshake in dialTLS
+		// so a redirect to a host that fails or a slow or broken response after the handshake is not fatal
+		if redirect, ok := redirectError(req, err); ok {
+			if _, handshake := c.tls[redirect]; handshake && responseError(err) {
+				c.setGood(redirect)
+			} else {
+				c.status.Set(redirect, status.New(status.CheckNetErr(err)))
+			}
+		} else if !responseError(err) {
+			return err
+		}
+		if c.parent.debug {
+			log.Printf("http: ignoring error after TLS handshake with %s: %s", req.URL.Hostname(), err)
+		}
+		c.setGood(req.URL.Hostname())
+		return nil
+	}
 	fullStatus := status.CheckNetErr(err)
 	if fullStatus != status.GOOD {
-		return results, err // in some rare cases this error can be ignored
+		return err // in some rare cases this error can be ignored
 	}
 	defer resp.Body.Close()
 
 	// set final domain status
-	results.status.Set(resp.Request.URL.Hostname(), status.New(status.GOOD))
+	c.setGood(resp.Request.URL.Hostname())
+	if c.parent.linkHints {
+		c.addLinkHints(resp)
+	}
 	// no need to add certificate to c.certs and c.fingerprints here, handled in dialTLS method
-	return results, nil
+	return nil
+}
+
+// redirectError returns the host of the redirect that failed if err is from following a redirect from req
+func redirectError(req *http.Request, err error) (string, bool) {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || urlErr.URL == req.URL.String() {
+		return "", false
+	}
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil || u.Hostname() == req.URL.Hostname() {
+		return "", false
+	}
+	return u.Hostname(), true
+}
+
+// responseError returns true if err is from a response that timed out or was cut short
+func responseError(err error) bool {
+	return status.CheckNetErr(err) == status.TIMEOUT || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// setGood sets the status of host to GOOD unless it was already set by a redirect
+// hosts serving an expired certificate have the meta "expired"
+// and hosts whose robots.txt disallows requesting them have the meta "robots"
+func (c *httpCertDriver) setGood(host string) {
+	if s, ok := c.status[host]; !ok || s.Status != status.REDIRECT {
+		meta := make([]string, 0, 2)
+		if c.expired[host] {
+			meta = append(meta, "expired")
+		}
+		if c.disallowed[host] {
+			meta = append(meta, "robots")
+		}
+		c.status.Set(host, status.NewMeta(status.GOOD, strings.Join(meta, " ")))
+	}
+}
+
+// redirectType returns the scheme of the redirect's target and "same-site" if both hosts
+// share an apex domain, otherwise "cross-site", ex: "https same-site"
+func redirectType(from, to *url.URL) string {
+	site := "cross-site"
+	fromApex, fromErr := dns.ApexDomain(from.Hostname())
+	toApex, toErr := dns.ApexDomain(to.Hostname())
+	if fromErr == nil && toErr == nil && fromApex == toApex {
+		site = "same-site"
+	}
+	return fmt.Sprintf("%s %s", to.Scheme, site)
 }
 
 // only called after a redirect is detected
@@ -122,8 +355,14 @@ func (d *httpDriver) QueryDomain(host string) (driver.Result, error) {
 func (c *httpCertDriver) checkRedirect(req *http.Request, via []*http.Request) error {
 	//fmt.Printf("Redirect %s -> %s\n", via[0].URL, req.URL)
 	// set both domain's status's
+	// the redirected domain's status is set once it responds, recording the type of redirect that found it
 	c.status.Set(via[0].URL.Hostname(), status.NewMeta(status.REDIRECT, req.URL.Hostname()))
-	c.status.Set(req.URL.Hostname(), status.New(status.UNKNOWN))
+	if req.URL.Hostname() != via[0].URL.Hostname() {
+		if _, ok := c.status[req.URL.Hostname()]; !ok {
+			c.status.Set(req.URL.Hostname(), status.New(status.UNKNOWN))
+		}
+		c.redirects[req.URL.Hostname()] = redirectType(via[len(via)-1].URL, req.URL)
+	}
 	c.related = append(c.related, req.URL.Hostname())
 	if len(via) >= 10 { // stop after 10 redirects
 		// this stops the redirect
@@ -132,21 +371,62 @@ func (c *httpCertDriver) checkRedirect(req *http.Request, via []*http.Request) e
 	return nil
 }
 
-func (c *httpCertDriver) dialTLS(network, addr string) (net.Conn, error) {
+// addChain adds the chain of certificates issuing the leaf to the results, each issued by the next
+func (c *httpCertDriver) addChain(leaf *driver.CertResult, chain []*x509.Certificate) {
+	subject := leaf
+	for _, cert := range chain {
+		issuer := driver.NewCertResult(cert)
+		subject.Issuer = issuer.Fingerprint
+		if _, found := c.certs[issuer.Fingerprint]; !found {
+			c.certs[issuer.Fingerprint] = issuer
+		}
+		subject = issuer
+	}
+}
+
+func (c *httpCertDriver) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
 	dialer := &net.Dialer{Timeout: c.client.Timeout}
-	conn, err := tls.DialWithDialer(dialer, network, addr, c.parent.tlsConfig)
-	if conn == nil {
-		return conn, err
+	// always send the host being dialed as the SNI so virtual hosts return their own certificate
+	// each redirect to a new host is dialed separately so the SNI follows the redirects
+	tlsConfig := c.parent.tlsConfig.Clone()
+	tlsConfig.ServerName = host
+	if ip, ok := c.parent.addresses[host]; ok {
+		// connect to the provided IP, sending the hostname as the SNI
+		addr = net.JoinHostPort(ip, port)
+	}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+	netConn, err := tlsDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
 	}
+	conn := netConn.(*tls.Conn)
 	// get certs passing by
 	connState := conn.ConnectionState()
+	c.tls[host] = status.TLSString(connState)
+	c.tls[net.JoinHostPort(host, port)] = c.tls[host]
 
-	// only look at leaf certificate which is valid for domain, rest of cert chain is ignored
+	// only the leaf certificate is valid for domain, the rest of the chain can be queried from its Issuer with chain
 	certResult := driver.NewCertResult(connState.PeerCertificates[0])
+	c.lastHost = host
+	if certResult.Expired(time.Now()) {
+		c.expired[host] = true
+		if !c.parent.includeExpired {
+			return conn, nil
+		}
+	}
 	c.certs[certResult.Fingerprint] = certResult
-	host, _, err := net.SplitHostPort(addr)
-	if err != nil {
-		return conn, err
+	if c.parent.chain {
+		c.addChain(certResult, connState.PeerCertificates[1:])
+	}
+	// the same certificate may be served on multiple ports of the host
+	for _, fp := range c.fingerprints[host] {
+		if fp == certResult.Fingerprint {
+			return conn, nil
+		}
 	}
 	c.fingerprints.Add(host, certResult.Fingerprint)
 
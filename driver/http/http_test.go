@@ -0,0 +1,532 @@
+package http_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	certhttp "github.com/lanrat/certgraph/driver/http"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/internal/testcert"
+	"github.com/lanrat/certgraph/status"
+)
+
+func TestQueryDomainSlowResponse(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	d, err := certhttp.Driver(500*time.Millisecond, "", nil, nil, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatalf("expected slow response after handshake to not be an error, got: %s", err)
+	}
+
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fps := fingerprints["127.0.0.1"]
+	if len(fps) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(fps))
+	}
+	if _, err := result.QueryCert(context.Background(), fps[0]); err != nil {
+		t.Error(err)
+	}
+	if s := result.GetStatus()["127.0.0.1"]; s.Status != status.GOOD {
+		t.Errorf("expected status Good, got %s", s.Status)
+	}
+	if s := result.GetStatus()["127.0.0.1"]; !strings.HasPrefix(s.TLS, "TLS1.") {
+		t.Errorf("expected the negotiated TLS version and cipher suite, got %q", s.TLS)
+	}
+}
+
+func TestQueryDomainFailedRedirect(t *testing.T) {
+	// find a closed port by listening on a free port and closing it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+net.JoinHostPort("closed.test", closedPort)+"/", http.StatusFound)
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, map[string]string{"a.test": "127.0.0.1", "closed.test": "127.0.0.1"}, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), net.JoinHostPort("a.test", port))
+	if err != nil {
+		t.Fatalf("expected a failed redirect after the handshake to not be an error, got: %s", err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints["a.test"]) != 1 {
+		t.Errorf("expected 1 certificate for a.test, got %v", fingerprints)
+	}
+	statuses := result.GetStatus()
+	if s := statuses["a.test"]; s.Status != status.REDIRECT {
+		t.Errorf("expected a.test status Redirect, got %s", s.String())
+	}
+	if s := statuses["closed.test"]; s.Status != status.REFUSED {
+		t.Errorf("expected closed.test status Refused, got %s", s.String())
+	}
+}
+
+func TestQueryDomainMalformedResponse(t *testing.T) {
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	defer server.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("not http\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.QueryDomain(context.Background(), l.Addr().String())
+	if err == nil {
+		t.Error("expected a malformed response after the handshake to be an error")
+	}
+}
+
+func TestQueryDomainHeaders(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Certgraph-Test")
+	}))
+	defer server.Close()
+
+	d, err := certhttp.Driver(5*time.Second, "", []string{"X-Certgraph-Test: hello"}, nil, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := <-got; header != "hello" {
+		t.Errorf("expected header value %q, got %q", "hello", header)
+	}
+
+	_, err = certhttp.Driver(5*time.Second, "", []string{"X-Missing-Colon"}, nil, nil, nil, false, false, false, false, false)
+	if err == nil {
+		t.Errorf("expected malformed header to return an error")
+	}
+}
+
+func TestQueryDomainSNIAddresses(t *testing.T) {
+	serverName := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName <- r.TLS.ServerName
+	}))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := certhttp.Driver(5*time.Second, "", nil, map[string]string{"sni.test": "127.0.0.1"}, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), net.JoinHostPort("sni.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sni := <-serverName; sni != "sni.test" {
+		t.Errorf("expected SNI %q, got %q", "sni.test", sni)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints["sni.test"]) != 1 {
+		t.Errorf("expected 1 certificate for sni.test, got %v", fingerprints)
+	}
+}
+
+func TestQueryDomainRedirectType(t *testing.T) {
+	var port string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.TLS.ServerName {
+		case "a.example.test":
+			http.Redirect(w, r, "https://"+net.JoinHostPort("b.example.test", port)+"/", http.StatusFound)
+		case "b.example.test":
+			http.Redirect(w, r, "https://"+net.JoinHostPort("other.test", port)+"/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	var err error
+	_, port, err = net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := certhttp.Driver(5*time.Second, "", nil, map[string]string{
+		"a.example.test": "127.0.0.1",
+		"b.example.test": "127.0.0.1",
+		"other.test":     "127.0.0.1",
+	}, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), net.JoinHostPort("a.example.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := result.GetStatus()
+	for host, redirect := range map[string]string{"b.example.test": "https same-site", "other.test": "https cross-site"} {
+		if s := statuses[host]; s.Redirect != redirect {
+			t.Errorf("expected %s to be found by a %q redirect, got %q", host, redirect, s.Redirect)
+		}
+	}
+	if s := statuses["other.test"]; s.Status != status.GOOD {
+		t.Errorf("expected the landing host to be Good, got %s", s.String())
+	}
+	if s := statuses["a.example.test"]; s.Status != status.REDIRECT || s.Meta != "other.test" {
+		t.Errorf("expected a.example.test status Redirect(other.test), got %s", s.String())
+	}
+}
+
+func TestQueryDomainPorts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, openPort, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// find a closed port by listening on a free port and closing it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, nil, []string{closedPort, openPort}, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("expected a closed port to not be an error when another port is open, got: %s", err)
+	}
+	statuses := result.GetStatus()
+	if s := statuses[net.JoinHostPort("127.0.0.1", openPort)]; s.Status != status.GOOD {
+		t.Errorf("expected open port status Good, got %s", s.Status)
+	}
+	if s := statuses[net.JoinHostPort("127.0.0.1", closedPort)]; s.Status == status.GOOD {
+		t.Errorf("expected closed port status to not be Good")
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints["127.0.0.1"]) != 1 {
+		t.Errorf("expected 1 certificate for 127.0.0.1, got %v", fingerprints)
+	}
+}
+
+func TestQueryDomainStalledPort(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, openPort, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a port accepting connections that never completes the TLS handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, stalledPort, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := certhttp.Driver(500*time.Millisecond, "", nil, nil, []string{openPort, stalledPort}, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("expected a stalled port to not be an error when another port is open, got: %s", err)
+	}
+	statuses := result.GetStatus()
+	if s := statuses[net.JoinHostPort("127.0.0.1", openPort)]; s.Status != status.GOOD {
+		t.Errorf("expected open port status Good, got %s", s.Status)
+	}
+	// the handshake with the open port must not make the stalled port Good
+	if s := statuses[net.JoinHostPort("127.0.0.1", stalledPort)]; s.Status == status.GOOD {
+		t.Errorf("expected stalled port status to not be Good")
+	}
+}
+
+func TestQueryDomainCanceled(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, false, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.QueryDomain(ctx, strings.TrimPrefix(server.URL, "https://"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestQueryDomainChain(t *testing.T) {
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca, key := testcert.New(t, caTemplate)
+	caDER := ca.Raw
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER, caDER}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, true, false, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fps := fingerprints["127.0.0.1"]
+	if len(fps) != 1 || fps[0] != fingerprint.FromRawCertBytes(leafDER) {
+		t.Fatalf("expected only the leaf certificate for 127.0.0.1, got %v", fps)
+	}
+	leaf, err := result.QueryCert(context.Background(), fps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Issuer != fingerprint.FromRawCertBytes(caDER) {
+		t.Errorf("expected the leaf's issuer to be the CA certificate")
+	}
+	if _, err := result.QueryCert(context.Background(), leaf.Issuer); err != nil {
+		t.Errorf("expected the CA certificate to be queryable: %s", err)
+	}
+}
+
+func TestQueryDomainLinkHints(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://cdn.example.test>; rel=preconnect, <https://example.test/next>; rel="next"`)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><link rel="dns-prefetch" href="//fonts.example.test"><link rel="stylesheet" href="https://css.example.test/a.css"></head>
+<body><link rel="preconnect" href="https://body.example.test"></body></html>`))
+	}))
+	defer server.Close()
+
+	d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, false, true, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	related, err := result.GetRelated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cdn.example.test", "fonts.example.test"}
+	if !reflect.DeepEqual(related, expected) {
+		t.Errorf("expected related domains %v, got %v", expected, related)
+	}
+	if s := result.GetStatus()["fonts.example.test"]; s.Status != status.HINT || s.Meta != "dns-prefetch" {
+		t.Errorf("expected dns-prefetch hint status, got %s", s.String())
+	}
+}
+
+func TestQueryDomainExpired(t *testing.T) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+	}
+	cert, key := testcert.New(t, template)
+	der := cert.Raw
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, includeExpired := range []bool{false, true} {
+		d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, false, false, includeExpired, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints, err := result.GetFingerprints()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := len(fingerprints["127.0.0.1"]) > 0; found != includeExpired {
+			t.Errorf("includeExpired %t: expected the expired certificate found to be %t, got %v", includeExpired, includeExpired, fingerprints)
+		}
+		if s := result.GetStatus()["127.0.0.1"]; s.Status != status.GOOD || s.Meta != "expired" {
+			t.Errorf("includeExpired %t: expected status Good(expired), got %s", includeExpired, s.String())
+		}
+	}
+}
+
+func TestQueryDomainRespectRobots(t *testing.T) {
+	tests := map[string]bool{
+		"User-agent: *\nDisallow: /\n":                                     false,
+		"User-agent: *\nDisallow: /private\n":                              true,
+		"User-agent: certgraph\nAllow: /$\n\nUser-agent: *\nDisallow: /\n": true,
+		"User-agent: other\nUser-agent: CertGraph\nDisallow: /\n":          false,
+		"User-agent: certgraph\nDisallow:\n\nUser-agent: *\nDisallow: /\n": true,
+	}
+	for robotsTxt, allowed := range tests {
+		var rootRequests int32
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				w.Write([]byte(robotsTxt))
+				return
+			}
+			atomic.AddInt32(&rootRequests, 1)
+		}))
+
+		d, err := certhttp.Driver(5*time.Second, "", nil, nil, nil, nil, false, false, false, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requested := atomic.LoadInt32(&rootRequests) > 0; requested != allowed {
+			t.Errorf("%q: expected the root path requested to be %t", robotsTxt, allowed)
+		}
+		fingerprints, err := result.GetFingerprints()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fingerprints["127.0.0.1"]) != 1 {
+			t.Errorf("%q: expected the certificate to be found, got %v", robotsTxt, fingerprints)
+		}
+		if s := result.GetStatus()["127.0.0.1"]; s.Status != status.GOOD || (s.Meta == "robots") == allowed {
+			t.Errorf("%q: unexpected status %s", robotsTxt, s.String())
+		}
+	}
+}
+
+func TestQueryDomainRobotsCrawlDelay(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 100000\n"))
+		}
+	}))
+	defer server.Close()
+
+	// the Crawl-delay is capped at the driver's timeout
+	const timeout = time.Second
+	d, err := certhttp.Driver(timeout, "", nil, nil, nil, nil, false, false, false, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = d.QueryDomain(context.Background(), strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 3*timeout {
+		t.Errorf("expected to wait the capped Crawl-delay of %s before the request, took %s", timeout, elapsed)
+	}
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsAgent is the robots.txt user agent matched when the User-Agent header is not set
+const robotsAgent = "certgraph"
+
+// maxRobotsSize is the maximum number of bytes of a robots.txt file read
+const maxRobotsSize = 500 * 1024
+
+// robots holds the rules of a host's robots.txt for the driver's user agent
+type robots struct {
+	disallowRoot bool          // the root path the driver requests is disallowed
+	crawlDelay   time.Duration // time to wait between requests to the host
+
+	mu   sync.Mutex
+	next time.Time // earliest time of the next request to the host
+}
+
+// wait blocks until the host's crawl delay since the last request has passed or ctx is done
+func (r *robots) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	start := r.next
+	if start.Before(now) {
+		start = now
+	}
+	r.next = start.Add(r.crawlDelay)
+	r.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// robotsCache holds the robots.txt rules of each host:port for the duration of the crawl
+type robotsCache struct {
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	once   sync.Once
+	robots *robots
+}
+
+// get returns the rules for target, calling fetch to get them the first time target is requested
+// the second return value is true if fetch was called
+func (rc *robotsCache) get(target string, fetch func() *robots) (*robots, bool) {
+	rc.mu.Lock()
+	entry, found := rc.hosts[target]
+	if !found {
+		entry = new(robotsEntry)
+		rc.hosts[target] = entry
+	}
+	rc.mu.Unlock()
+
+	fetched := false
+	entry.once.Do(func() {
+		entry.robots = fetch()
+		fetched = true
+	})
+	return entry.robots, fetched
+}
+
+// fetchRobots requests https://target/robots.txt with the result's client so the certificate is captured
+// redirects are not followed and a missing or unreadable robots.txt allows everything
+func (c *httpCertDriver) fetchRobots(ctx context.Context, target string) *robots {
+	client := &http.Client{
+		Timeout:   c.client.Timeout,
+		Transport: c.client.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+target+"/robots.txt", nil)
+	if err != nil {
+		return new(robots)
+	}
+	req.Header = c.parent.headers.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		return new(robots)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return new(robots)
+	}
+	rules := parseRobots(io.LimitReader(resp.Body, maxRobotsSize), c.parent.robotsAgent())
+	// a long Crawl-delay would block a worker for the rest of the crawl, wait at most the driver's timeout
+	if rules.crawlDelay > c.parent.timeout {
+		rules.crawlDelay = c.parent.timeout
+	}
+	return rules
+}
+
+// robotsAgent returns the product token of the User-Agent header, or robotsAgent if it is not set
+func (d *httpDriver) robotsAgent() string {
+	ua := d.headers.Get("User-Agent")
+	if i := strings.IndexAny(ua, "/ "); i >= 0 {
+		ua = ua[:i]
+	}
+	if len(ua) == 0 {
+		return robotsAgent
+	}
+	return ua
+}
+
+// robotsRule is an Allow or Disallow line of a robots.txt group
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// parseRobots returns the rules from the robots.txt for agent (RFC 9309)
+// the groups naming agent are used even if they allow everything, otherwise the * groups, agents are compared case-insensitively
+func parseRobots(r io.Reader, agent string) *robots {
+	agent = strings.ToLower(agent)
+	var agentRules, defaultRules []robotsRule
+	var agentDelay, defaultDelay time.Duration
+	var forAgent, forDefault, inRules, agentMatched bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			// user agent lines after rules start a new group
+			if inRules {
+				forAgent, forDefault, inRules = false, false, false
+			}
+			value = strings.ToLower(value)
+			if value == "*" {
+				forDefault = true
+			} else if value == agent {
+				forAgent = true
+				agentMatched = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if len(value) == 0 {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			if forAgent {
+				agentRules = append(agentRules, rule)
+			}
+			if forDefault {
+				defaultRules = append(defaultRules, rule)
+			}
+		case "crawl-delay":
+			inRules = true
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			delay := time.Duration(seconds * float64(time.Second))
+			if forAgent {
+				agentDelay = delay
+			}
+			if forDefault {
+				defaultDelay = delay
+			}
+		}
+	}
+
+	rules, delay := defaultRules, defaultDelay
+	if agentMatched {
+		rules, delay = agentRules, agentDelay
+	}
+	return &robots{
+		disallowRoot: !robotsAllowed(rules, "/"),
+		crawlDelay:   delay,
+	}
+}
+
+// robotsAllowed returns true if the most specific rule matching path allows it, allow wins ties
+func robotsAllowed(rules []robotsRule, path string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			longest = len(rule.pattern)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch returns true if the robots.txt path pattern matches path
+// * matches any characters and a trailing $ anchors the end of the path
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	matched, err := regexp.MatchString(expr, path)
+	return err == nil && matched
+}
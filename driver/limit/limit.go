@@ -0,0 +1,65 @@
+// Package limit implements a certgraph driver wrapper that limits the number of concurrent queries to another driver
+package limit
+
+import (
+	"context"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+)
+
+// Driver wraps a driver allowing at most a fixed number of its QueryDomain and QueryCert calls to run at once
+type Driver struct {
+	driver.Driver
+	pass chan bool
+}
+
+// Wrap returns a new Driver allowing at most parallel concurrent queries to d
+func Wrap(d driver.Driver, parallel uint) *Driver {
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &Driver{Driver: d, pass: make(chan bool, parallel)}
+}
+
+// QueryDomain calls the wrapped driver's QueryDomain once there are fewer than the maximum queries running
+// it returns ctx's error if ctx is done before then
+func (d *Driver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	select {
+	case d.pass <- true:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	result, err := d.Driver.QueryDomain(ctx, domain)
+	<-d.pass
+	if result == nil {
+		return result, err
+	}
+	return &limitResult{Result: result, parent: d}, err
+}
+
+type limitResult struct {
+	driver.Result
+	parent *Driver
+}
+
+// QueryCert calls the wrapped result's QueryCert once there are fewer than the maximum queries running
+// it returns ctx's error if ctx is done before then
+func (r *limitResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	select {
+	case r.parent.pass <- true:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-r.parent.pass }()
+	return r.Result.QueryCert(ctx, fp)
+}
+
+// GetNotBefore passes through to the wrapped result if it implements driver.ValidityResult
+func (r *limitResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	if vr, ok := r.Result.(driver.ValidityResult); ok {
+		return vr.GetNotBefore(fp)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,65 @@
+package limit
+
+import (
+	"context"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+	"golang.org/x/time/rate"
+)
+
+// RateDriver wraps a driver allowing its QueryDomain and QueryCert calls to start at most a fixed number of times per second
+// the rate is shared by every goroutine using the RateDriver
+type RateDriver struct {
+	driver.Driver
+	limiter *rate.Limiter
+}
+
+// Rate returns a new RateDriver allowing at most perSecond queries to d to start each second
+// a perSecond of 0 or less has no limit
+func Rate(d driver.Driver, perSecond float64) *RateDriver {
+	limit := rate.Limit(perSecond)
+	if perSecond <= 0 {
+		limit = rate.Inf
+	}
+	// a burst of 1 spaces the queries out evenly
+	return &RateDriver{Driver: d, limiter: rate.NewLimiter(limit, 1)}
+}
+
+// QueryDomain calls the wrapped driver's QueryDomain once the rate allows it
+// it returns ctx's error if ctx is done before then
+func (d *RateDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	err := d.limiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := d.Driver.QueryDomain(ctx, domain)
+	if result == nil {
+		return result, err
+	}
+	return &rateResult{Result: result, parent: d}, err
+}
+
+type rateResult struct {
+	driver.Result
+	parent *RateDriver
+}
+
+// QueryCert calls the wrapped result's QueryCert once the rate allows it
+// it returns ctx's error if ctx is done before then
+func (r *rateResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	err := r.parent.limiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Result.QueryCert(ctx, fp)
+}
+
+// GetNotBefore passes through to the wrapped result if it implements driver.ValidityResult
+func (r *rateResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	if vr, ok := r.Result.(driver.ValidityResult); ok {
+		return vr.GetNotBefore(fp)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,60 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	l := Rate(nil, 20).limiter
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first event is immediate, the next two are 50ms apart
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected 3 events at 20 per second to take at least 100ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled waiting with a canceled context, got %v", err)
+	}
+}
+
+func TestRateLimiterCancelReturnsSlot(t *testing.T) {
+	l := Rate(nil, 10).limiter
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	// a waiter canceled before its turn does not use up the next slot
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Fatal("expected the canceled waiter to return an error")
+	}
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 180*time.Millisecond {
+		t.Errorf("expected the next event within one 100ms interval, took %s", elapsed)
+	}
+}
+
+func TestRateUnlimited(t *testing.T) {
+	l := Rate(nil, 0).limiter
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected no limit with a rate of 0, took %s", elapsed)
+	}
+}
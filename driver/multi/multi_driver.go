@@ -2,10 +2,12 @@
 package multi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lanrat/certgraph/driver"
 	"github.com/lanrat/certgraph/fingerprint"
@@ -32,28 +34,32 @@ func (d *multiDriver) GetName() string {
 	return fmt.Sprintf("multi[%s]", strings.Join(names, ","))
 }
 
-func (d *multiDriver) QueryDomain(domain string) (driver.Result, error) {
-	r := newResult(domain)
+func (d *multiDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	// query in parallel, but merge the results in driver order so the merged result is always the same
+	results := make([]driver.Result, len(d.drivers))
 	var group errgroup.Group
-	for _, d := range d.drivers {
-		goFunc := func(localDriver driver.Driver) func() error {
-			return func() error {
-				return func(localDriver driver.Driver) error {
-					result, err := localDriver.QueryDomain(domain)
-					if err != nil {
-						return err
-					}
-					return r.add(result)
-				}(localDriver)
+	for i, localDriver := range d.drivers {
+		i, localDriver := i, localDriver
+		group.Go(func() error {
+			result, err := localDriver.QueryDomain(ctx, domain)
+			if err != nil {
+				return err
 			}
-		}
-
-		group.Go(goFunc(d))
+			results[i] = result
+			return nil
+		})
 	}
 	err := group.Wait()
 	if err != nil {
 		return nil, err
 	}
+	r := newResult(domain)
+	for i, result := range results {
+		err = r.add(d.drivers[i].GetName(), result)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return r, nil
 }
 
@@ -62,17 +68,20 @@ func newResult(host string) *multiResult {
 	r.host = host
 	r.results = make([]driver.Result, 0, 2)
 	r.fingerprints = make(driver.FingerprintMap)
+	r.sources = make(map[string]map[fingerprint.Fingerprint][]string)
 	return r
 }
 
 type multiResult struct {
 	host         string
 	results      []driver.Result
-	resultLock   sync.Mutex // also protects fingerprints
+	names        []string   // name of the driver of each result
+	resultLock   sync.Mutex // protects fingerprints and sources
 	fingerprints driver.FingerprintMap
+	sources      map[string]map[fingerprint.Fingerprint][]string // drivers that found each fingerprint for each domain
 }
 
-func (c *multiResult) add(r driver.Result) error {
+func (c *multiResult) add(name string, r driver.Result) error {
 	c.resultLock.Lock()
 	defer c.resultLock.Unlock()
 	fpm, err := r.GetFingerprints()
@@ -80,29 +89,97 @@ func (c *multiResult) add(r driver.Result) error {
 		return err
 	}
 	for domain := range fpm {
+		if c.sources[domain] == nil {
+			c.sources[domain] = make(map[fingerprint.Fingerprint][]string)
+		}
 		for _, fp := range fpm[domain] {
-			// TODO does not dedupe across drivers
-			c.fingerprints.Add(domain, fp)
+			// the same certificate may be found by multiple drivers
+			sources, seen := c.sources[domain][fp]
+			if !seen {
+				c.fingerprints.Add(domain, fp)
+			}
+			if len(sources) == 0 || sources[len(sources)-1] != name {
+				c.sources[domain][fp] = append(sources, name)
+			}
 		}
 	}
 
 	c.results = append(c.results, r)
+	c.names = append(c.names, name)
 	return nil
 }
 
-func (c *multiResult) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
-	for _, result := range c.results {
-		cr, err := result.QueryCert(fp)
+// GetCertSources returns the names of the drivers that found the certificate for the domain
+func (c *multiResult) GetCertSources(domain string, fp fingerprint.Fingerprint) []string {
+	c.resultLock.Lock()
+	defer c.resultLock.Unlock()
+	return append([]string(nil), c.sources[domain][fp]...)
+}
+
+// GetRelatedSources returns the names of the drivers that returned the related domain
+func (c *multiResult) GetRelatedSources(related string) []string {
+	var sources []string
+	for i, result := range c.results {
+		domains, err := result.GetRelated()
 		if err != nil {
-			return nil, err
+			continue
+		}
+		for _, domain := range domains {
+			if domain == related {
+				sources = append(sources, c.names[i])
+				break
+			}
+		}
+	}
+	return sources
+}
+
+// QueryCert returns the certificate from the first result that has it
+// only the results of the drivers that found the certificate are queried, or every result if no driver found it
+// a result that does not have the certificate returns an error, so errors are only returned if no result has it
+func (c *multiResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	c.resultLock.Lock()
+	found := make(map[string]bool)
+	for domain := range c.sources {
+		for _, name := range c.sources[domain][fp] {
+			found[name] = true
+		}
+	}
+	c.resultLock.Unlock()
+
+	var firstErr error
+	for i, result := range c.results {
+		if len(found) > 0 && !found[c.names[i]] {
+			continue
+		}
+		cr, err := result.QueryCert(ctx, fp)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 		if cr != nil {
 			return cr, nil
 		}
 	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
 	return nil, errors.New("unable to find working driver with QueryCert()")
 }
 
+func (c *multiResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	for _, result := range c.results {
+		if vr, ok := result.(driver.ValidityResult); ok {
+			if t, found := vr.GetNotBefore(fp); found {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
 func (c *multiResult) GetFingerprints() (driver.FingerprintMap, error) {
 	return c.fingerprints, nil
 }
@@ -113,19 +190,20 @@ func (c *multiResult) GetStatus() status.Map {
 }
 
 func (c *multiResult) GetRelated() ([]string, error) {
+	// related domains are returned in driver order without duplicates
 	relatedMap := make(map[string]bool)
+	related := make([]string, 0)
 	for _, result := range c.results {
-		related, err := result.GetRelated()
+		domains, err := result.GetRelated()
 		if err != nil {
 			return nil, err
 		}
-		for _, r := range related {
-			relatedMap[r] = true
+		for _, r := range domains {
+			if !relatedMap[r] {
+				relatedMap[r] = true
+				related = append(related, r)
+			}
 		}
 	}
-	related := make([]string, 0, len(relatedMap))
-	for r := range relatedMap {
-		related = append(related, r)
-	}
 	return related, nil
 }
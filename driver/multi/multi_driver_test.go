@@ -0,0 +1,133 @@
+package multi_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/driver/multi"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+// fakeDriver returns the same fingerprints for every domain queried
+type fakeDriver struct {
+	name         string
+	fingerprints []fingerprint.Fingerprint
+	related      []string
+	delay        time.Duration // time to wait before returning, to finish after other drivers
+	queried      int32         // number of certificates queried from the driver's results
+}
+
+func (d *fakeDriver) GetName() string {
+	return d.name
+}
+
+func (d *fakeDriver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	time.Sleep(d.delay)
+	fpm := make(driver.FingerprintMap)
+	for _, fp := range d.fingerprints {
+		fpm.Add(domain, fp)
+	}
+	return &fakeResult{host: domain, fingerprints: fpm, related: d.related, queried: &d.queried}, nil
+}
+
+type fakeResult struct {
+	host         string
+	fingerprints driver.FingerprintMap
+	related      []string
+	queried      *int32
+}
+
+func (r *fakeResult) GetStatus() status.Map {
+	return status.NewMap(r.host, status.New(status.GOOD))
+}
+
+func (r *fakeResult) GetRelated() ([]string, error) {
+	return r.related, nil
+}
+
+func (r *fakeResult) GetFingerprints() (driver.FingerprintMap, error) {
+	return r.fingerprints, nil
+}
+
+// QueryCert returns the certificate if the result found it
+func (r *fakeResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	atomic.AddInt32(r.queried, 1)
+	for _, found := range r.fingerprints[r.host] {
+		if found == fp {
+			return &driver.CertResult{Fingerprint: fp}, nil
+		}
+	}
+	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+}
+
+func TestQueryDomainDedupe(t *testing.T) {
+	a := fingerprint.FromRawCertBytes([]byte("a"))
+	b := fingerprint.FromRawCertBytes([]byte("b"))
+	c := fingerprint.FromRawCertBytes([]byte("c"))
+	d := multi.Driver([]driver.Driver{
+		// the first driver finishes last, the merged result is still in driver order
+		&fakeDriver{name: "one", fingerprints: []fingerprint.Fingerprint{a, b}, related: []string{"x.example.com", "y.example.com"}, delay: 50 * time.Millisecond},
+		&fakeDriver{name: "two", fingerprints: []fingerprint.Fingerprint{b, c, b}, related: []string{"z.example.com", "x.example.com"}},
+	})
+
+	result, err := d.QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := result.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fingerprints["example.com"], []fingerprint.Fingerprint{a, b, c}) {
+		t.Errorf("expected fingerprints a, b, c in driver order, got %v", fingerprints["example.com"])
+	}
+	related, err := result.GetRelated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(related, []string{"x.example.com", "y.example.com", "z.example.com"}) {
+		t.Errorf("expected related domains in driver order, got %v", related)
+	}
+	sr := result.(driver.SourceResult)
+	if sources := sr.GetCertSources("example.com", b); !reflect.DeepEqual(sources, []string{"one", "two"}) {
+		t.Errorf("expected b to be found by one and two, got %v", sources)
+	}
+}
+
+func TestQueryCertSources(t *testing.T) {
+	a := fingerprint.FromRawCertBytes([]byte("a"))
+	b := fingerprint.FromRawCertBytes([]byte("b"))
+	one := &fakeDriver{name: "one", fingerprints: []fingerprint.Fingerprint{a}}
+	two := &fakeDriver{name: "two", fingerprints: []fingerprint.Fingerprint{b}}
+	result, err := multi.Driver([]driver.Driver{one, two}).QueryDomain(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the driver that found the certificate is queried for it
+	cr, err := result.QueryCert(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Fingerprint != b {
+		t.Errorf("expected certificate b, got %s", cr.Fingerprint.HexString())
+	}
+	if one.queried != 0 || two.queried != 1 {
+		t.Errorf("expected only two to be queried for b, got one: %d, two: %d", one.queried, two.queried)
+	}
+
+	// a certificate no driver found is queried from every driver
+	_, err = result.QueryCert(context.Background(), fingerprint.FromRawCertBytes([]byte("c")))
+	if err == nil {
+		t.Error("expected an error for a certificate no driver has")
+	}
+	if one.queried != 1 || two.queried != 2 {
+		t.Errorf("expected every driver to be queried for c, got one: %d, two: %d", one.queried, two.queried)
+	}
+}
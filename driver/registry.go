@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options holds the configuration for creating a driver
+// each driver uses the options relevant to it and ignores the rest
+type Options struct {
+	Timeout           time.Duration
+	SavePath          string              // folder to save certificates to, empty to not save
+	IncludeSubdomains bool                // CT drivers: include sub-domains in the search
+	IncludeExpired    bool                // CT, http, smtp, imap, and pop3 drivers: include expired certificates
+	IncludeCN         bool                // CT drivers: include the CommonName, not only dNSName SANs
+	Ordered           bool                // CT drivers: return the same results every time when limited
+	AsOf              time.Time           // CT drivers: only certificates valid at this time, if not zero
+	IssuedSince       time.Time           // CT drivers: only certificates issued after this time, if not zero
+	MaxCertSANs       int                 // crtsh driver: maximum number of domains to return for a certificate, 0 has no limit
+	Headers           []string            // http driver: headers in the form "Name: Value" to add to every request
+	SNIAddresses      map[string]string   // http driver: IP address to connect to for each hostname, sent as the SNI
+	Ports             []string            // http, smtp, imap, and pop3 drivers: ports to connect to, empty uses the driver's default port
+	HostPorts         map[string][]string // http, smtp, imap, and pop3 drivers: ports to connect to for each hostname instead of Ports
+	Chain             bool                // http driver: return the intermediate certificates sent by the server as the leaf's issuers
+	DumpQueries       bool                // CT drivers: log the queries sent for each domain
+	LinkHints         bool                // http driver: return the hosts of preconnect and dns-prefetch links as related domains
+	RespectRobots     bool                // http driver: only request the root path if robots.txt allows it, waiting for its Crawl-delay
+	Verbose           bool                // http driver: log the errors ignored after the TLS handshake
+}
+
+// Info describes a registered driver
+type Info struct {
+	Live            bool // connects to the hosts for the certificates they serve now, instead of searching the issued certificates
+	CT              bool // searches certificate transparency logs for the issued certificates
+	DefaultParallel uint // default maximum concurrent queries to avoid rate limits, 0 has no limit
+}
+
+// Factory creates a new Driver from the provided options
+type Factory func(Options) (Driver, error)
+
+var factories = make(map[string]Factory)
+var infos = make(map[string]Info)
+
+// Register adds the driver's name, info, and factory to the registry
+// it should be called in the init() function of every driver
+func Register(name string, info Info, factory Factory) {
+	AddDriver(name)
+	factories[name] = factory
+	infos[name] = info
+}
+
+// GetInfo returns the info the driver was registered with, unknown drivers have an empty Info
+func GetInfo(name string) Info {
+	return infos[name]
+}
+
+// New creates a new instance of the registered driver with the provided options
+func New(name string, options Options) (Driver, error) {
+	factory, found := factories[name]
+	if !found {
+		return nil, fmt.Errorf("unknown driver name: %s", name)
+	}
+	return factory(options)
+}
@@ -4,7 +4,6 @@ package smtp
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net"
 	"net/smtp"
@@ -12,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lanrat/certgraph/dns"
 	"github.com/lanrat/certgraph/driver"
 	"github.com/lanrat/certgraph/fingerprint"
 	"github.com/lanrat/certgraph/status"
@@ -20,15 +20,25 @@ import (
 const driverName = "smtp"
 
 func init() {
-	driver.AddDriver(driverName)
+	driver.Register(driverName, driver.Info{Live: true}, func(o driver.Options) (driver.Driver, error) {
+		return Driver(o.Timeout, o.SavePath, o.Ports, o.HostPorts, o.IncludeExpired)
+	})
 }
 
+// defaultPort is the port queried when no ports are provided
+const defaultPort = "25"
+
+// implicitTLSPort is the SMTPS port which uses TLS from the start of the connection instead of STARTTLS
+const implicitTLSPort = "465"
+
 type smtpDriver struct {
-	port      string
-	save      bool
-	savePath  string
-	tlsConfig *tls.Config
-	timeout   time.Duration
+	ports          []string
+	hostPorts      map[string][]string // ports to connect to for a hostname instead of ports
+	save           bool
+	savePath       string
+	tlsConfig      *tls.Config
+	timeout        time.Duration
+	includeExpired bool // return expired certificates
 }
 
 type smtpCertDriver struct {
@@ -51,7 +61,7 @@ func (c *smtpCertDriver) GetRelated() ([]string, error) {
 	return c.mx, nil
 }
 
-func (c *smtpCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+func (c *smtpCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
 	cert, found := c.certs[fp]
 	if found {
 		return cert, nil
@@ -59,10 +69,26 @@ func (c *smtpCertDriver) QueryCert(fp fingerprint.Fingerprint) (*driver.CertResu
 	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
 }
 
+func (c *smtpCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	cert, found := c.certs[fp]
+	if !found {
+		return time.Time{}, false
+	}
+	return cert.NotBefore, true
+}
+
 // Driver creates a new SSL driver for SMTP Connections
-func Driver(timeout time.Duration, savePath string) (driver.Driver, error) {
+// every port in ports is queried, if ports is empty only 25 is queried
+// hostnames in hostPorts are only queried on their mapped ports
+// expired certificates are only returned if includeExpired is true, either way the status is marked expired
+func Driver(timeout time.Duration, savePath string, ports []string, hostPorts map[string][]string, includeExpired bool) (driver.Driver, error) {
 	d := new(smtpDriver)
-	d.port = "25"
+	d.includeExpired = includeExpired
+	d.ports = ports
+	if len(d.ports) == 0 {
+		d.ports = []string{defaultPort}
+	}
+	d.hostPorts = hostPorts
 	if len(savePath) > 0 {
 		d.save = true
 		d.savePath = savePath
@@ -79,33 +105,51 @@ func (d *smtpDriver) GetName() string {
 	return driverName
 }
 
-func (d *smtpDriver) smtpGetCerts(host string) ([]*x509.Certificate, error) {
-	var certs []*x509.Certificate
-	addr := net.JoinHostPort(host, d.port)
+// smtpGetConnState returns the state of the TLS connection to the host's port
+func (d *smtpDriver) smtpGetConnState(ctx context.Context, host, port string) (tls.ConnectionState, error) {
+	var connState tls.ConnectionState
+	addr := net.JoinHostPort(host, port)
 	dialer := &net.Dialer{Timeout: d.timeout}
 
-	conn, err := dialer.Dial("tcp", addr)
+	if port == implicitTLSPort {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: d.tlsConfig}
+		conn, err := tlsDialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return connState, err
+		}
+		defer conn.Close()
+		return conn.(*tls.Conn).ConnectionState(), nil
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return certs, err
+		return connState, err
 	}
 	defer conn.Close()
+	// interrupt the SMTP conversation if ctx is done after connecting
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
 	smtp, err := smtp.NewClient(conn, host)
 	if err != nil {
-		return certs, err
+		return connState, err
 	}
 	err = smtp.StartTLS(d.tlsConfig)
 	if err != nil {
-		return certs, err
+		return connState, err
 	}
-	connState, ok := smtp.TLSConnectionState()
-	if !ok {
-		return certs, err
-	}
-	return connState.PeerCertificates, err
+	connState, _ = smtp.TLSConnectionState()
+	return connState, nil
 }
 
 // QueryDomain gets the certificates found for a given domain
-func (d *smtpDriver) QueryDomain(host string) (driver.Result, error) {
+func (d *smtpDriver) QueryDomain(ctx context.Context, host string) (driver.Result, error) {
 	results := &smtpCertDriver{
 		host:         host,
 		status:       make(status.Map),
@@ -114,44 +158,71 @@ func (d *smtpDriver) QueryDomain(host string) (driver.Result, error) {
 	}
 
 	// get related in different query
-	results.mx, _ = d.getMX(host)
-
-	certs, err := d.smtpGetCerts(host)
-	smtpStatus := status.CheckNetErr(err)
-	metaStatus := ""
-	if len(results.mx) > 0 {
-		metaStatus = fmt.Sprintf("MX(%s)", strings.Join(results.mx, " "))
+	results.mx, _ = dns.LookupMX(host, d.timeout)
+
+	// the host's status is good if any port is, when querying multiple ports the status of each port is set as host:port
+	var smtpStatus status.DomainStatus = status.UNKNOWN
+	smtpTLS := "" // TLS of the first port connected to
+	expired := false
+	ports := d.ports
+	if hostPorts, ok := d.hostPorts[host]; ok {
+		ports = hostPorts
 	}
-	results.status.Set(host, status.NewMeta(smtpStatus, metaStatus))
-
-	if smtpStatus != status.GOOD {
-		return results, nil
-	}
-
-	// only look at leaf certificate which is valid for domain, rest of cert chain is ignored
-	certResult := driver.NewCertResult(certs[0])
-	results.certs[certResult.Fingerprint] = certResult
-	results.fingerprints.Add(host, certResult.Fingerprint)
-
-	// save
-	if d.save && len(certs) > 0 {
-		err = driver.CertsToPEMFile(certs, path.Join(d.savePath, certResult.Fingerprint.HexString())+".pem")
+	for _, port := range ports {
+		connState, err := d.smtpGetConnState(ctx, host, port)
+		certs := connState.PeerCertificates
+		portStatus := status.CheckNetErr(err)
+		// only look at leaf certificate which is valid for domain, rest of cert chain is ignored
+		var certResult *driver.CertResult
+		portMeta, portTLS := "", ""
+		if portStatus == status.GOOD && connState.HandshakeComplete {
+			portTLS = status.TLSString(connState)
+			if len(smtpTLS) == 0 {
+				smtpTLS = portTLS
+			}
+		}
+		if portStatus == status.GOOD && len(certs) > 0 {
+			certResult = driver.NewCertResult(certs[0])
+			if certResult.Expired(time.Now()) {
+				expired = true
+				portMeta = "expired"
+			}
+		}
+		if len(ports) > 1 || port != defaultPort {
+			results.status.Set(net.JoinHostPort(host, port), status.NewMeta(portStatus, portMeta))
+			results.status.SetTLS(net.JoinHostPort(host, port), portTLS)
+		}
+		if smtpStatus != status.GOOD {
+			smtpStatus = portStatus
+		}
+		if certResult == nil || (len(portMeta) > 0 && !d.includeExpired) {
+			continue
+		}
+
+		if _, found := results.certs[certResult.Fingerprint]; found {
+			continue
+		}
+		results.certs[certResult.Fingerprint] = certResult
+		results.fingerprints.Add(host, certResult.Fingerprint)
+
+		// save
+		if d.save {
+			err = driver.CertsToPEMFile(certs, path.Join(d.savePath, certResult.Fingerprint.HexString())+".pem")
+			if err != nil {
+				return results, err
+			}
+		}
 	}
-
-	return results, err
-}
-
-// getMX returns the MX records for the provided domain
-func (d *smtpDriver) getMX(domain string) ([]string, error) {
-	domains := make([]string, 0, 5)
-	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
-	defer cancel()
-	mx, err := net.DefaultResolver.LookupMX(ctx, domain)
-	if err != nil {
-		return domains, err
+	meta := make([]string, 0, 2)
+	if expired {
+		meta = append(meta, "expired")
 	}
-	for _, v := range mx {
-		domains = append(domains, strings.TrimSuffix(v.Host, "."))
+	if len(results.mx) > 0 {
+		meta = append(meta, fmt.Sprintf("MX(%s)", strings.Join(results.mx, " ")))
 	}
-	return domains, nil
+	metaStatus := strings.Join(meta, " ")
+	results.status.Set(host, status.NewMeta(smtpStatus, metaStatus))
+	results.status.SetTLS(host, smtpTLS)
+
+	return results, nil
 }
@@ -0,0 +1,329 @@
+// Package starttls implements certgraph drivers for obtaining SSL certificates from mail servers over IMAP and POP3 with STARTTLS
+package starttls
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lanrat/certgraph/dns"
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
+)
+
+// protocol describes how to upgrade a connection to TLS for a mail protocol
+type protocol struct {
+	defaultPort     string   // port queried when no ports are provided
+	implicitTLSPort string   // port using TLS from the start of the connection instead of STARTTLS
+	srvServices     []string // RFC 6186 SRV services naming the protocol's servers for a domain
+	// startTLS reads the server greeting and issues the STARTTLS command
+	// returning once the server is ready for the TLS handshake
+	startTLS func(r *bufio.Reader, w net.Conn) error
+}
+
+var protocols = map[string]protocol{
+	"imap": {
+		defaultPort:     "143",
+		implicitTLSPort: "993",
+		srvServices:     []string{"_imap._tcp", "_imaps._tcp"},
+		startTLS:        imapStartTLS,
+	},
+	"pop3": {
+		defaultPort:     "110",
+		implicitTLSPort: "995",
+		srvServices:     []string{"_pop3._tcp", "_pop3s._tcp"},
+		startTLS:        pop3StartTLS,
+	},
+}
+
+func init() {
+	// registered in a fixed order so the list of drivers is always the same
+	for _, name := range []string{"imap", "pop3"} {
+		name := name
+		driver.Register(name, driver.Info{Live: true}, func(o driver.Options) (driver.Driver, error) {
+			return Driver(name, o.Timeout, o.SavePath, o.Ports, o.HostPorts, o.IncludeExpired)
+		})
+	}
+}
+
+// imapTag is the tag of the STARTTLS command sent to IMAP servers
+const imapTag = "certgraph"
+
+// imapStartTLS upgrades an IMAP connection (RFC 3501 section 6.2.1)
+func imapStartTLS(r *bufio.Reader, w net.Conn) error {
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected IMAP greeting: %q", greeting)
+	}
+	_, err = fmt.Fprintf(w, "%s STARTTLS\r\n", imapTag)
+	if err != nil {
+		return err
+	}
+	// skip untagged responses until the command completes
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, imapTag+" ") {
+			continue
+		}
+		if !strings.HasPrefix(line, imapTag+" OK") {
+			return fmt.Errorf("IMAP STARTTLS failed: %q", line)
+		}
+		return nil
+	}
+}
+
+// pop3StartTLS upgrades a POP3 connection (RFC 2595 section 4)
+func pop3StartTLS(r *bufio.Reader, w net.Conn) error {
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected POP3 greeting: %q", greeting)
+	}
+	_, err = fmt.Fprint(w, "STLS\r\n")
+	if err != nil {
+		return err
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("POP3 STLS failed: %q", line)
+	}
+	return nil
+}
+
+// readLine reads a line from the server without the trailing CRLF
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+type starttlsDriver struct {
+	name           string
+	protocol       protocol
+	ports          []string
+	hostPorts      map[string][]string // ports to connect to for a hostname instead of ports
+	save           bool
+	savePath       string
+	tlsConfig      *tls.Config
+	timeout        time.Duration
+	includeExpired bool // return expired certificates
+}
+
+type starttlsCertDriver struct {
+	host         string
+	fingerprints driver.FingerprintMap
+	status       status.Map
+	related      []string
+	certs        map[fingerprint.Fingerprint]*driver.CertResult
+}
+
+func (c *starttlsCertDriver) GetFingerprints() (driver.FingerprintMap, error) {
+	return c.fingerprints, nil
+}
+
+func (c *starttlsCertDriver) GetStatus() status.Map {
+	return c.status
+}
+
+func (c *starttlsCertDriver) GetRelated() ([]string, error) {
+	return c.related, nil
+}
+
+func (c *starttlsCertDriver) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	cert, found := c.certs[fp]
+	if found {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("certificate with Fingerprint %s not found", fp.HexString())
+}
+
+func (c *starttlsCertDriver) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	cert, found := c.certs[fp]
+	if !found {
+		return time.Time{}, false
+	}
+	return cert.NotBefore, true
+}
+
+// Driver creates a new SSL driver for the mail protocol, imap or pop3
+// every port in ports is queried, if ports is empty only the protocol's standard STARTTLS port is queried
+// hostnames in hostPorts are only queried on their mapped ports
+// expired certificates are only returned if includeExpired is true, either way the status is marked expired
+func Driver(name string, timeout time.Duration, savePath string, ports []string, hostPorts map[string][]string, includeExpired bool) (driver.Driver, error) {
+	p, ok := protocols[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown STARTTLS protocol: %s", name)
+	}
+	d := new(starttlsDriver)
+	d.name = name
+	d.protocol = p
+	d.includeExpired = includeExpired
+	d.ports = ports
+	if len(d.ports) == 0 {
+		d.ports = []string{p.defaultPort}
+	}
+	d.hostPorts = hostPorts
+	if len(savePath) > 0 {
+		d.save = true
+		d.savePath = savePath
+	}
+	d.tlsConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	d.timeout = timeout
+
+	return d, nil
+}
+
+func (d *starttlsDriver) GetName() string {
+	return d.name
+}
+
+// getConnState returns the state of the TLS connection to the host's port
+func (d *starttlsDriver) getConnState(ctx context.Context, host, port string) (tls.ConnectionState, error) {
+	addr := net.JoinHostPort(host, port)
+	dialer := &net.Dialer{Timeout: d.timeout}
+	tlsConfig := d.tlsConfig.Clone()
+	tlsConfig.ServerName = host
+
+	if port == d.protocol.implicitTLSPort {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err := tlsDialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.(*tls.Conn).ConnectionState(), nil
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	// interrupt the conversation if ctx is done after connecting
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	err = conn.SetDeadline(time.Now().Add(d.timeout))
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	err = d.protocol.startTLS(bufio.NewReader(conn), conn)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	err = tlsConn.Handshake()
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	return tlsConn.ConnectionState(), nil
+}
+
+// QueryDomain gets the certificates found for a given domain
+// the domain's SRV records for the protocol are returned as related domains
+func (d *starttlsDriver) QueryDomain(ctx context.Context, host string) (driver.Result, error) {
+	results := &starttlsCertDriver{
+		host:         host,
+		status:       make(status.Map),
+		fingerprints: make(driver.FingerprintMap),
+		certs:        make(map[fingerprint.Fingerprint]*driver.CertResult),
+	}
+
+	// get related in different query
+	targets, _ := dns.LookupSRVTargets(host, d.protocol.srvServices, d.timeout)
+	for _, target := range targets {
+		if target == host {
+			continue
+		}
+		if _, found := results.status[target]; !found {
+			results.status.Set(target, status.New(status.SRV))
+			results.related = append(results.related, target)
+		}
+	}
+
+	ports := d.ports
+	if hostPorts, ok := d.hostPorts[host]; ok {
+		ports = hostPorts
+	}
+	// the host's status is good if any port is, when querying multiple ports the status of each port is set as host:port
+	var hostStatus status.DomainStatus = status.UNKNOWN
+	hostTLS := "" // TLS of the first port connected to
+	expired := false
+	for _, port := range ports {
+		connState, err := d.getConnState(ctx, host, port)
+		certs := connState.PeerCertificates
+		portStatus := status.CheckNetErr(err)
+		// only look at leaf certificate which is valid for domain, rest of cert chain is ignored
+		var certResult *driver.CertResult
+		portMeta, portTLS := "", ""
+		if err == nil {
+			portTLS = status.TLSString(connState)
+			if len(hostTLS) == 0 {
+				hostTLS = portTLS
+			}
+		}
+		if err == nil && len(certs) > 0 {
+			certResult = driver.NewCertResult(certs[0])
+			if certResult.Expired(time.Now()) {
+				expired = true
+				portMeta = "expired"
+			}
+		}
+		if len(ports) > 1 || port != d.protocol.defaultPort {
+			results.status.Set(net.JoinHostPort(host, port), status.NewMeta(portStatus, portMeta))
+			results.status.SetTLS(net.JoinHostPort(host, port), portTLS)
+		}
+		if hostStatus != status.GOOD {
+			hostStatus = portStatus
+		}
+		if certResult == nil || (len(portMeta) > 0 && !d.includeExpired) {
+			continue
+		}
+
+		if _, found := results.certs[certResult.Fingerprint]; found {
+			continue
+		}
+		results.certs[certResult.Fingerprint] = certResult
+		results.fingerprints.Add(host, certResult.Fingerprint)
+
+		// save
+		if d.save {
+			err = driver.CertsToPEMFile(certs, path.Join(d.savePath, certResult.Fingerprint.HexString())+".pem")
+			if err != nil {
+				return results, err
+			}
+		}
+	}
+	if expired {
+		results.status.Set(host, status.NewMeta(hostStatus, "expired"))
+	} else {
+		results.status.Set(host, status.New(hostStatus))
+	}
+	results.status.SetTLS(host, hostTLS)
+
+	return results, nil
+}
@@ -0,0 +1,91 @@
+package starttls_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/driver/starttls"
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/internal/testcert"
+	"github.com/lanrat/certgraph/status"
+)
+
+// serve accepts a single connection on l, answers the greeting and STARTTLS command with the replies, then starts TLS
+func serve(t *testing.T, l net.Listener, cert tls.Certificate, greeting, reply string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte(greeting + "\r\n"))
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Write([]byte(reply + "\r\n"))
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	tlsConn.Handshake()
+}
+
+func TestQueryDomain(t *testing.T) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mail.example.test"},
+		DNSNames:     []string{"mail.example.test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	x509Cert, key := testcert.New(t, template)
+	der := x509Cert.Raw
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	tests := map[string][2]string{
+		"imap": {"* OK IMAP4rev1 ready", "certgraph OK Begin TLS negotiation now"},
+		"pop3": {"+OK POP3 ready", "+OK Begin TLS negotiation"},
+	}
+	for name, replies := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go serve(t, l, cert, replies[0], replies[1])
+		_, port, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := starttls.Driver(name, 2*time.Second, "", nil, map[string][]string{"127.0.0.1": {port}}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := d.QueryDomain(context.Background(), "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints, err := result.GetFingerprints()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fps := fingerprints["127.0.0.1"]
+		if len(fps) != 1 || fps[0] != fingerprint.FromRawCertBytes(der) {
+			t.Errorf("%s: expected the server's certificate, got %v", name, fps)
+		}
+		if s := result.GetStatus()["127.0.0.1"]; s.Status != status.GOOD || !strings.HasPrefix(s.TLS, "TLS1.") {
+			t.Errorf("%s: expected status Good with the negotiated TLS, got %s %q", name, s.String(), s.TLS)
+		}
+	}
+
+	if _, err := starttls.Driver("ftp", time.Second, "", nil, nil, false); err == nil {
+		t.Error("expected an unknown protocol to return an error")
+	}
+}
@@ -0,0 +1,157 @@
+// Package timing implements a certgraph driver wrapper that records the latency of another driver's queries
+package timing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/lanrat/certgraph/driver"
+	"github.com/lanrat/certgraph/fingerprint"
+)
+
+// subBuckets is the number of histogram buckets per power of two, giving a precision of 1/subBuckets
+const subBuckets = 64
+
+// histogram is a concurrency safe HDR style histogram of durations with microsecond resolution
+type histogram struct {
+	sync.Mutex
+	counts [65 * subBuckets]uint64
+	total  uint64
+}
+
+func bucket(us uint64) int {
+	if us < 2*subBuckets {
+		return int(us)
+	}
+	exp := bits.Len64(us) - bits.Len64(subBuckets)
+	return exp*subBuckets + int(us>>exp)
+}
+
+// bucketValue returns the lower bound in microseconds of the bucket
+func bucketValue(i int) uint64 {
+	if i < 2*subBuckets {
+		return uint64(i)
+	}
+	exp := i/subBuckets - 1
+	return uint64(i-exp*subBuckets) << exp
+}
+
+func (h *histogram) record(d time.Duration) {
+	us := d.Microseconds()
+	if us < 0 {
+		us = 0
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.counts[bucket(uint64(us))]++
+	h.total++
+}
+
+// percentile returns the duration at the provided percentile (0-100)
+func (h *histogram) percentile(p float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(p / 100 * float64(h.total))
+	if target < 1 {
+		target = 1
+	}
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= target {
+			return time.Duration(bucketValue(i)) * time.Microsecond
+		}
+	}
+	return 0
+}
+
+func (h *histogram) count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.total
+}
+
+// Driver wraps a driver recording the latency of its QueryDomain and QueryCert calls
+type Driver struct {
+	driver.Driver
+	queryDomain histogram
+	queryCert   histogram
+}
+
+// Wrap returns a new timing Driver for the provided driver
+func Wrap(d driver.Driver) *Driver {
+	return &Driver{Driver: d}
+}
+
+// QueryDomain calls the wrapped driver's QueryDomain recording its latency
+func (d *Driver) QueryDomain(ctx context.Context, domain string) (driver.Result, error) {
+	start := time.Now()
+	result, err := d.Driver.QueryDomain(ctx, domain)
+	d.queryDomain.record(time.Since(start))
+	if result == nil {
+		return result, err
+	}
+	return &timingResult{Result: result, parent: d}, err
+}
+
+// Stats holds the number of queries of one kind and their latency percentiles
+type Stats struct {
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+}
+
+func (h *histogram) stats() Stats {
+	return Stats{
+		Count: h.count(),
+		P50:   h.percentile(50),
+		P90:   h.percentile(90),
+		P99:   h.percentile(99),
+	}
+}
+
+// Stats returns the latency stats of the driver's QueryDomain and QueryCert calls
+func (d *Driver) Stats() map[string]Stats {
+	return map[string]Stats{
+		"QueryDomain": d.queryDomain.stats(),
+		"QueryCert":   d.queryCert.stats(),
+	}
+}
+
+// PrintStats writes the latency percentiles of the driver's queries to w
+func (d *Driver) PrintStats(w io.Writer) {
+	stats := d.Stats()
+	for _, name := range []string{"QueryDomain", "QueryCert"} {
+		s := stats[name]
+		fmt.Fprintf(w, "%s\t%s\tcount: %d\tp50: %s\tp90: %s\tp99: %s\n", d.GetName(), name, s.Count, s.P50, s.P90, s.P99)
+	}
+}
+
+type timingResult struct {
+	driver.Result
+	parent *Driver
+}
+
+// QueryCert calls the wrapped result's QueryCert recording its latency
+func (r *timingResult) QueryCert(ctx context.Context, fp fingerprint.Fingerprint) (*driver.CertResult, error) {
+	start := time.Now()
+	certResult, err := r.Result.QueryCert(ctx, fp)
+	r.parent.queryCert.record(time.Since(start))
+	return certResult, err
+}
+
+// GetNotBefore passes through to the wrapped result if it implements driver.ValidityResult
+func (r *timingResult) GetNotBefore(fp fingerprint.Fingerprint) (time.Time, bool) {
+	if vr, ok := r.Result.(driver.ValidityResult); ok {
+		return vr.GetNotBefore(fp)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,32 @@
+package timing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketValue(t *testing.T) {
+	for _, us := range []uint64{0, 1, 127, 128, 129, 1000, 123456, 1 << 40} {
+		low := bucketValue(bucket(us))
+		if low > us {
+			t.Errorf("bucket lower bound %d greater than value %d", low, us)
+		}
+		if us-low > us/subBuckets {
+			t.Errorf("bucket lower bound %d too far from value %d", low, us)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	for _, p := range []float64{50, 90, 99} {
+		expected := time.Duration(p) * time.Millisecond
+		got := h.percentile(p)
+		if got > expected || expected-got > expected/subBuckets {
+			t.Errorf("p%.0f expected %s got %s", p, expected, got)
+		}
+	}
+}
@@ -17,23 +17,34 @@ func (fp *Fingerprint) HexString() string {
 }
 
 // FromHashBytes returns a Fingerprint generated by the first len(Fingerprint) bytes
+// shorter hashes are zero padded, use FromHashBytesChecked for hashes that may be the wrong length
 func FromHashBytes(data []byte) Fingerprint {
 	var fp Fingerprint
-	// if len(data) != len(fp) {
-	// 	// TODO this should error....
-	// }
 	for i := 0; i < len(data) && i < len(fp); i++ {
 		fp[i] = data[i]
 	}
 	return fp
 }
 
+// FromHashBytesChecked returns a Fingerprint of the hash, or an error if the hash is not a SHA256 hash
+func FromHashBytesChecked(data []byte) (Fingerprint, error) {
+	if len(data) != sha256.Size {
+		return Fingerprint{}, fmt.Errorf("invalid SHA256 hash length %d, expected %d", len(data), sha256.Size)
+	}
+	return FromHashBytes(data), nil
+}
+
 // FromRawCertBytes returns a Fingerprint generated by the provided bytes
 func FromRawCertBytes(data []byte) Fingerprint {
 	fp := sha256.Sum256(data)
 	return fp
 }
 
+// MatchesRawCert returns true if the Fingerprint is the SHA256 hash of the DER encoded certificate
+func (fp *Fingerprint) MatchesRawCert(data []byte) bool {
+	return *fp == FromRawCertBytes(data)
+}
+
 // FromB64Hash returns a Fingerprint from a base64 encoded hash string
 func FromB64Hash(hash string) Fingerprint {
 	data, err := base64.StdEncoding.DecodeString(hash)
@@ -52,6 +63,21 @@ func FromHexHash(hash string) Fingerprint {
 	return FromHashBytes(decoded)
 }
 
+// MarshalText encodes the Fingerprint as uppercase hex, so it can be a json map key
+func (fp Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(fp.HexString()), nil
+}
+
+// UnmarshalText decodes a hex encoded Fingerprint
+func (fp *Fingerprint) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*fp, err = FromHashBytesChecked(data)
+	return err
+}
+
 // B64Encode returns the b64 string of a Fingerprint
 func (fp *Fingerprint) B64Encode() string {
 	return base64.StdEncoding.EncodeToString(fp[:])
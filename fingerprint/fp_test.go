@@ -87,3 +87,23 @@ func TestFromHexHash(t *testing.T) {
 		t.Errorf("fingerprint error, expected b64 hash [%s] got [%s]", fpHashHex, hashB64)
 	}
 }
+
+func TestFromHashBytesChecked(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(fpHashB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := fingerprint.FromHashBytesChecked(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.B64Encode() != fpHashB64 {
+		t.Errorf("fingerprint error, expected b64 hash [%s] got [%s]", fpHashB64, fp.B64Encode())
+	}
+
+	for _, length := range []int{0, 16, 33} {
+		if _, err := fingerprint.FromHashBytesChecked(make([]byte, length)); err == nil {
+			t.Errorf("expected an error for a %d byte hash", length)
+		}
+	}
+}
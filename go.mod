@@ -3,7 +3,9 @@ module github.com/lanrat/certgraph
 require (
 	github.com/lib/pq v1.10.7
 	github.com/weppos/publicsuffix-go v0.30.0
+	golang.org/x/net v0.7.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/time v0.3.0
 )
 
 go 1.16
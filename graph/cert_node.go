@@ -2,29 +2,85 @@ package graph
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lanrat/certgraph/dns"
 	"github.com/lanrat/certgraph/fingerprint"
 )
 
+// certificates issued within these ages are considered new or recent by Freshness
+const (
+	newCertAge    = 30 * 24 * time.Hour
+	recentCertAge = 365 * 24 * time.Hour
+)
+
 // CertNode graph node to store certificate information
 type CertNode struct {
-	Fingerprint  fingerprint.Fingerprint
-	Domains      []string
-	foundMap     map[string]bool
-	foundMapLock sync.Mutex
+	Fingerprint    fingerprint.Fingerprint
+	Domains        []string
+	NotBefore      time.Time
+	NotAfter       time.Time
+	Organizations  []string
+	KeyAlgorithm   string
+	KeySize        int
+	SignatureAlgo  string
+	Tags           []string
+	DuplicateSANs  int
+	Precert        bool
+	AuthorityKeyID string
+	SubjectKeyID   string
+	Issuer         fingerprint.Fingerprint // certificate issuing this one from the chain sent by a server, zero if unknown
+	Intermediate   bool                    // true if the certificate was found in a chain instead of for a domain
+	IssuerCN       string
+	IssuerOrgs     []string
+	found          *foundSet
+	foundOnce      sync.Once
+}
+
+// foundSet is the set of drivers that found a certificate
+// it is shared by a CertNode and the node replacing it when certificates are merged so no driver is lost
+type foundSet struct {
+	sync.Mutex
+	drivers map[string]bool
+}
+
+// foundSet returns the set of drivers that found the certificate, creating it if needed
+func (c *CertNode) foundSet() *foundSet {
+	c.foundOnce.Do(func() {
+		if c.found == nil {
+			c.found = &foundSet{drivers: make(map[string]bool)}
+		}
+	})
+	return c.found
+}
+
+// maxSANsPrint is the maximum number of domains String prints for a certificate, 0 has no limit
+var maxSANsPrint int
+
+// SetMaxSANsPrint sets the maximum number of domains printed for each certificate by String
+// the remaining domains are summarized as "(+M more)", 0 prints every domain
+func SetMaxSANsPrint(max int) {
+	maxSANsPrint = max
 }
 
 func (c *CertNode) String() string {
-	return fmt.Sprintf("%s\t%s\t%v", c.Fingerprint.HexString(), c.Found(), c.Domains)
+	domains := outputDomains(c.Domains)
+	if maxSANsPrint > 0 && len(domains) > maxSANsPrint {
+		return fmt.Sprintf("%s\t%s\t%v (+%d more)", c.Fingerprint.HexString(), c.Found(), domains[:maxSANsPrint], len(domains)-maxSANsPrint)
+	}
+	return fmt.Sprintf("%s\t%s\t%v", c.Fingerprint.HexString(), c.Found(), domains)
 }
 
 // Found returns a list of drivers that found this cert
 func (c *CertNode) Found() []string {
-	found := make([]string, 0, len(c.foundMap))
-	for i := range c.foundMap {
+	s := c.foundSet()
+	s.Lock()
+	defer s.Unlock()
+	found := make([]string, 0, len(s.drivers))
+	for i := range s.drivers {
 		found = append(found, i)
 	}
 	return found
@@ -32,12 +88,120 @@ func (c *CertNode) Found() []string {
 
 // AddFound adds a driver name to the source of the certificate
 func (c *CertNode) AddFound(driver string) {
-	c.foundMapLock.Lock()
-	defer c.foundMapLock.Unlock()
-	if c.foundMap == nil {
-		c.foundMap = make(map[string]bool)
+	s := c.foundSet()
+	s.Lock()
+	defer s.Unlock()
+	s.drivers[driver] = true
+}
+
+// Key returns the certificate's public key algorithm and size, ex: RSA-2048
+func (c *CertNode) Key() string {
+	if c.KeySize == 0 {
+		return c.KeyAlgorithm
+	}
+	return fmt.Sprintf("%s-%d", c.KeyAlgorithm, c.KeySize)
+}
+
+// merged returns a new CertNode with the domains and drivers found of c and other
+// and any fields unknown to c set from other, c is not modified as it may be read without a lock
+// the returned node shares the drivers found with c
+func (c *CertNode) merged(other *CertNode) *CertNode {
+	for _, driver := range other.Found() {
+		c.AddFound(driver)
+	}
+
+	m := &CertNode{
+		Fingerprint:    c.Fingerprint,
+		NotBefore:      c.NotBefore,
+		NotAfter:       c.NotAfter,
+		Organizations:  c.Organizations,
+		KeyAlgorithm:   c.KeyAlgorithm,
+		KeySize:        c.KeySize,
+		SignatureAlgo:  c.SignatureAlgo,
+		DuplicateSANs:  c.DuplicateSANs,
+		Precert:        c.Precert,
+		AuthorityKeyID: c.AuthorityKeyID,
+		SubjectKeyID:   c.SubjectKeyID,
+		Issuer:         c.Issuer,
+		Intermediate:   c.Intermediate,
+		IssuerCN:       c.IssuerCN,
+		IssuerOrgs:     c.IssuerOrgs,
+		found:          c.foundSet(),
+	}
+	domains := make([]string, len(c.Domains), len(c.Domains)+len(other.Domains))
+	copy(domains, c.Domains)
+	m.Domains = appendUniq(domains, other.Domains...)
+	if m.NotBefore.IsZero() {
+		m.NotBefore = other.NotBefore
+	}
+	if m.NotAfter.IsZero() {
+		m.NotAfter = other.NotAfter
+	}
+	if len(m.Organizations) == 0 {
+		m.Organizations = other.Organizations
+	}
+	if len(m.KeyAlgorithm) == 0 {
+		m.KeyAlgorithm = other.KeyAlgorithm
+		m.KeySize = other.KeySize
+	}
+	if len(m.SignatureAlgo) == 0 {
+		m.SignatureAlgo = other.SignatureAlgo
+	}
+	if m.DuplicateSANs == 0 {
+		m.DuplicateSANs = other.DuplicateSANs
+	}
+	m.Precert = m.Precert || other.Precert
+	if len(m.AuthorityKeyID) == 0 {
+		m.AuthorityKeyID = other.AuthorityKeyID
+	}
+	if len(m.SubjectKeyID) == 0 {
+		m.SubjectKeyID = other.SubjectKeyID
+	}
+	if m.Issuer == (fingerprint.Fingerprint{}) {
+		m.Issuer = other.Issuer
+	}
+	m.Intermediate = m.Intermediate || other.Intermediate
+	if len(m.IssuerCN) == 0 && len(m.IssuerOrgs) == 0 {
+		m.IssuerCN = other.IssuerCN
+		m.IssuerOrgs = other.IssuerOrgs
+	}
+	tags := make([]string, len(c.Tags), len(c.Tags)+len(other.Tags))
+	copy(tags, c.Tags)
+	m.Tags = appendUniq(tags, other.Tags...)
+	return m
+}
+
+// Expired returns true if the certificate's NotAfter date has passed
+// certificates with an unknown validity period are not considered expired
+func (c *CertNode) Expired() bool {
+	return !c.NotAfter.IsZero() && time.Now().After(c.NotAfter)
+}
+
+// Age returns how long ago the certificate was issued
+// and false if the certificate's NotBefore date is unknown
+func (c *CertNode) Age() (time.Duration, bool) {
+	if c.NotBefore.IsZero() {
+		return 0, false
+	}
+	return time.Since(c.NotBefore), true
+}
+
+// Freshness returns a category of the certificate's age: new, recent, old, or expired
+// an empty string is returned if the certificate's NotBefore date is unknown
+func (c *CertNode) Freshness() string {
+	age, ok := c.Age()
+	switch {
+	case c.Expired():
+		return "expired"
+	case !ok:
+		return ""
+	case age < newCertAge:
+		return "new"
+	case age < recentCertAge:
+		return "recent"
+	default:
+		return "old"
 	}
-	c.foundMap[driver] = true
 }
 
 // CDNCert returns true if we think the certificate belongs to a CDN
@@ -65,11 +229,8 @@ func (c *CertNode) CDNCert() bool {
 func (c *CertNode) ApexCount() int {
 	apexDomains := make(map[string]bool)
 	for _, domain := range c.Domains {
-		apexDomain, err := dns.ApexDomain(domain)
-		if err != nil {
-			continue
-		}
-		apexDomains[apexDomain] = true
+		// count domains the public suffix list can't parse by their last two labels instead of skipping them
+		apexDomains[dns.ApexDomainFallback(domain)] = true
 	}
 	return len(apexDomains)
 }
@@ -80,5 +241,49 @@ func (c *CertNode) ToMap() map[string]string {
 	m["type"] = "certificate"
 	m["id"] = c.Fingerprint.HexString()
 	m["found"] = strings.Join(c.Found(), " ")
+	if len(c.KeyAlgorithm) > 0 {
+		m["key"] = c.Key()
+	}
+	if len(c.SignatureAlgo) > 0 {
+		m["signature_algorithm"] = c.SignatureAlgo
+	}
+	if !c.NotBefore.IsZero() {
+		m["not_before"] = c.NotBefore.UTC().Format(time.RFC3339)
+	}
+	if !c.NotAfter.IsZero() {
+		m["not_after"] = c.NotAfter.UTC().Format(time.RFC3339)
+	}
+	if c.DuplicateSANs > 0 {
+		m["duplicate_sans"] = strconv.Itoa(c.DuplicateSANs)
+	}
+	if c.Precert {
+		m["precert"] = "true"
+	}
+	if len(c.AuthorityKeyID) > 0 {
+		m["authority_key_id"] = c.AuthorityKeyID
+	}
+	if len(c.SubjectKeyID) > 0 {
+		m["subject_key_id"] = c.SubjectKeyID
+	}
+	if c.Intermediate {
+		m["chain"] = "intermediate"
+	} else if c.Issuer != (fingerprint.Fingerprint{}) {
+		m["chain"] = "leaf"
+	}
+	if len(c.IssuerCN) > 0 {
+		m["issuer_cn"] = c.IssuerCN
+	}
+	if len(c.IssuerOrgs) > 0 {
+		m["issuer_org"] = strings.Join(c.IssuerOrgs, ", ")
+	}
+	if len(c.Tags) > 0 {
+		m["tags"] = strings.Join(c.Tags, " ")
+	}
+	if age, ok := c.Age(); ok {
+		m["age_days"] = fmt.Sprintf("%d", int(age.Hours()/24))
+	}
+	if freshness := c.Freshness(); len(freshness) > 0 {
+		m["freshness"] = freshness
+	}
 	return m
 }
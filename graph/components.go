@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"sort"
+
+	"github.com/lanrat/certgraph/fingerprint"
+)
+
+// Components returns the graph split into its connected components, each as its own CertGraph
+// domains are connected to the certificates they serve and to the domains they redirect or point to with MX and SRV records,
+// certificates to the domains in their SANs and to their issuing certificates, the direction of the links is ignored
+// the components with the most domains are returned first
+func (graph *CertGraph) Components() []*CertGraph {
+	uf := unionFind{parent: make(map[string]string)}
+	domainNodes := graph.GetDomains()
+	certNodes := make([]*CertNode, 0, graph.NumCerts())
+	graph.certs.Range(func(key, value interface{}) bool {
+		certNodes = append(certNodes, value.(*CertNode))
+		return true
+	})
+
+	for _, domainNode := range domainNodes {
+		uf.find(domainKey(domainNode.Domain))
+		for fp := range domainNode.Certs {
+			if _, ok := graph.GetCert(fp); ok {
+				uf.union(domainKey(domainNode.Domain), certKey(fp))
+			}
+		}
+		// the same related domains GenerateMap links to
+		for _, relatedDomain := range domainNode.GetRelatedDomains() {
+			if _, _, ok := relatedLinkType(domainNode.RelatedDomains[relatedDomain]); !ok {
+				continue
+			}
+			if _, ok := graph.GetDomain(relatedDomain); ok {
+				uf.union(domainKey(domainNode.Domain), domainKey(relatedDomain))
+			}
+		}
+	}
+	for _, certNode := range certNodes {
+		uf.find(certKey(certNode.Fingerprint))
+		for _, domain := range certNode.Domains {
+			domain = nonWildcard(domain)
+			if _, ok := graph.GetDomain(domain); ok {
+				uf.union(certKey(certNode.Fingerprint), domainKey(domain))
+			}
+		}
+		if _, ok := graph.GetCert(certNode.Issuer); ok && certNode.Issuer != (fingerprint.Fingerprint{}) {
+			uf.union(certKey(certNode.Fingerprint), certKey(certNode.Issuer))
+		}
+	}
+
+	components := make(map[string]*CertGraph)
+	component := func(key string) *CertGraph {
+		root := uf.find(key)
+		if _, ok := components[root]; !ok {
+			components[root] = NewCertGraph()
+		}
+		return components[root]
+	}
+	for _, domainNode := range domainNodes {
+		component(domainKey(domainNode.Domain)).AddDomain(domainNode)
+	}
+	for _, certNode := range certNodes {
+		component(certKey(certNode.Fingerprint)).AddCert(certNode)
+	}
+
+	// sort by size then name so the order is the same every time
+	names := make(map[*CertGraph]string, len(components))
+	sorted := make([]*CertGraph, 0, len(components))
+	for root, g := range components {
+		for _, domainNode := range g.GetDomains() {
+			if name := domainKey(domainNode.Domain); len(names[g]) == 0 || name < names[g] {
+				names[g] = name
+			}
+		}
+		if len(names[g]) == 0 {
+			names[g] = root
+		}
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].NumDomains() != sorted[j].NumDomains() {
+			return sorted[i].NumDomains() > sorted[j].NumDomains()
+		}
+		return names[sorted[i]] < names[sorted[j]]
+	})
+	return sorted
+}
+
+// domainKey and certKey name the domain and certificate nodes uniquely for the unionFind
+func domainKey(domain string) string {
+	return "domain " + domain
+}
+
+func certKey(fp fingerprint.Fingerprint) string {
+	return "cert " + fp.HexString()
+}
+
+// unionFind is a disjoint set of node names
+type unionFind struct {
+	parent map[string]string
+}
+
+// find returns the root name of the set holding name, adding it as its own set if it is new
+func (uf *unionFind) find(name string) string {
+	parent, ok := uf.parent[name]
+	if !ok {
+		uf.parent[name] = name
+		return name
+	}
+	if parent == name {
+		return name
+	}
+	root := uf.find(parent)
+	uf.parent[name] = root
+	return root
+}
+
+// union joins the sets holding a and b
+func (uf *unionFind) union(a, b string) {
+	rootA, rootB := uf.find(a), uf.find(b)
+	if rootA != rootB {
+		uf.parent[rootB] = rootA
+	}
+}
@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteEdgeCSV writes the graph's links as csv rows of source, target, type, driver, and depth to w
+// with the same links as GenerateMap, rows are written as each node is walked instead of building every link first
+// links from a domain to its certificates have the type "certificate" and the drivers that found the certificate
+// depth is the depth of the domain in the link, or empty for links between certificates
+func (graph *CertGraph) WriteEdgeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"source", "target", "type", "driver", "depth"})
+	if err != nil {
+		return err
+	}
+
+	graph.domains.Range(func(key, value interface{}) bool {
+		domainNode := value.(*DomainNode)
+		depth := strconv.FormatUint(uint64(domainNode.Depth), 10)
+		for fingerprint, found := range domainNode.Certs {
+			err = cw.Write([]string{OutputDomain(domainNode.Domain), fingerprint.HexString(), "certificate", strings.Join(found, " "), depth})
+			if err != nil {
+				return false
+			}
+		}
+		for _, link := range graph.relatedLinks(domainNode) {
+			err = cw.Write([]string{link["source"], link["target"], link["type"], "", depth})
+			if err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	subjectKeyIDs := graph.subjectKeyIDs()
+	graph.certs.Range(func(key, value interface{}) bool {
+		certNode := value.(*CertNode)
+		found := strings.Join(certNode.Found(), " ")
+		for _, link := range graph.certLinks(certNode, subjectKeyIDs) {
+			depth := ""
+			if link["type"] == "sans" {
+				if domainNode, ok := graph.GetDomain(nonWildcard(link["target"])); ok {
+					depth = strconv.FormatUint(uint64(domainNode.Depth), 10)
+				}
+			}
+			err = cw.Write([]string{link["source"], link["target"], link["type"], found, depth})
+			if err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteNodeCSV writes the graph's domain and certificate nodes as csv rows of id, type, status, and root to w
+// status and root are empty for certificates
+func (graph *CertGraph) WriteNodeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"id", "type", "status", "root"})
+	if err != nil {
+		return err
+	}
+
+	graph.domains.Range(func(key, value interface{}) bool {
+		domainNode := value.(*DomainNode)
+		err = cw.Write([]string{OutputDomain(domainNode.Domain), "domain", domainNode.Status.String(), strconv.FormatBool(domainNode.Root)})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	graph.certs.Range(func(key, value interface{}) bool {
+		certNode := value.(*CertNode)
+		err = cw.Write([]string{certNode.Fingerprint.HexString(), "certificate", "", ""})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -2,6 +2,7 @@ package graph
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,27 +18,38 @@ type DomainNode struct {
 	Depth          uint
 	Certs          map[fingerprint.Fingerprint][]string
 	RelatedDomains status.Map
+	RelatedSources map[string][]string // drivers that found each related domain, only set when it is not the driver of the crawl
+	Ports          status.Map          // status of each port queried, only set when a driver queried non-default ports
 	Status         status.Status
 	Root           bool
 	HasDNS         bool
+	IPs            []string // addresses of the domain's A and AAAA records, if resolved
+	Sources        []string // drivers that discovered the domain
+	Parents        []string // domains at the previous depth that discovered the domain
 }
 
-// NewDomainNode constructor for DomainNode, converts domain to lower nonWildcard
+// NewDomainNode constructor for DomainNode, converts domain to a normalized nonWildcard
 func NewDomainNode(domain string, depth uint) *DomainNode {
 	domainNode := new(DomainNode)
-	domainNode.Domain = nonWildcard(strings.ToLower(domain))
+	domainNode.Domain = nonWildcard(domain)
 	domainNode.Depth = depth
 	domainNode.Certs = make(map[fingerprint.Fingerprint][]string)
 	domainNode.RelatedDomains = make(status.Map)
+	domainNode.Ports = make(status.Map)
 	return domainNode
 }
 
+// AddParents adds the provided domains to the domainNode's BFS parents if they are not already present
+func (d *DomainNode) AddParents(domains ...string) {
+	d.Parents = appendUniq(d.Parents, domains...)
+}
+
 // AddRelatedDomains adds the domains in the provided array to the domainNode's
 // related domains status map with an unknown status if they are not already
 // in the map
 func (d *DomainNode) AddRelatedDomains(domains []string) {
 	for _, domain := range domains {
-		domain = strings.ToLower(domain)
+		domain = NormalizeDomain(domain)
 		if _, ok := d.RelatedDomains[domain]; ok {
 			continue
 		}
@@ -45,6 +57,15 @@ func (d *DomainNode) AddRelatedDomains(domains []string) {
 	}
 }
 
+// AddRelatedSources records the drivers that found the related domain
+func (d *DomainNode) AddRelatedSources(domain string, sources ...string) {
+	domain = NormalizeDomain(domain)
+	if d.RelatedSources == nil {
+		d.RelatedSources = make(map[string][]string)
+	}
+	d.RelatedSources[domain] = appendUniq(d.RelatedSources[domain], sources...)
+}
+
 // CheckForDNS checks for the existence of DNS records for the domain's apex
 // sets the value to the node and returns the result as well
 func (d *DomainNode) CheckForDNS(timeout time.Duration) (bool, error) {
@@ -54,6 +75,15 @@ func (d *DomainNode) CheckForDNS(timeout time.Duration) (bool, error) {
 	return hasDNS, err
 }
 
+// ResolveIPs looks up the A and AAAA records of the domain
+// sets the addresses to the node and returns them as well
+func (d *DomainNode) ResolveIPs(timeout time.Duration) ([]string, error) {
+	ips, err := dns.LookupIPsCache(d.Domain, timeout)
+
+	d.IPs = ips
+	return ips, err
+}
+
 // AddStatusMap adds the status' in the map to the DomainNode
 // also sets the Node's own status if it is in the Map
 // side effect: will delete its own status from the provided map
@@ -62,7 +92,12 @@ func (d *DomainNode) AddStatusMap(m status.Map) {
 		d.Status = status
 		delete(m, d.Domain)
 	}
+	portPrefix := d.Domain + ":"
 	for domain, status := range m {
+		if strings.HasPrefix(domain, portPrefix) {
+			d.Ports[strings.TrimPrefix(domain, portPrefix)] = status
+			continue
+		}
 		d.RelatedDomains[domain] = status
 	}
 }
@@ -85,7 +120,16 @@ func (d *DomainNode) String() string {
 			certString = fmt.Sprintf("%s %s", certString, fingerprint.HexString())
 		}
 	}
-	return fmt.Sprintf("%s\t%d\t%s\t%s", d.Domain, d.Depth, d.Status.String(), certString)
+	statusString := d.Status.String()
+	if len(d.Status.TLS) > 0 {
+		statusString = fmt.Sprintf("%s %s", statusString, d.Status.TLS)
+	}
+	str := fmt.Sprintf("%s\t%d\t%s\t%s", OutputDomain(d.Domain), d.Depth, statusString, certString)
+	// Related
+	if len(d.RelatedDomains) > 0 {
+		str = fmt.Sprintf("%s\t%s", str, strings.Join(outputDomains(d.GetRelatedDomains()), " "))
+	}
+	return str
 }
 
 // AddCertFingerprint appends a Fingerprint to the DomainNode's list of certificates
@@ -93,20 +137,41 @@ func (d *DomainNode) AddCertFingerprint(fp fingerprint.Fingerprint, certSource s
 	d.Certs[fp] = append(d.Certs[fp], certSource)
 }
 
-// ToMap returns a map of the DomainNode's fields (weak serialization)
-func (d *DomainNode) ToMap() map[string]string {
+// GetRelatedDomains returns a sorted list of the domain's related domains
+func (d *DomainNode) GetRelatedDomains() []string {
 	related := make([]string, 0, len(d.RelatedDomains))
 	for domain := range d.RelatedDomains {
 		related = append(related, domain)
 	}
-	relatedString := strings.Join(related, " ")
+	sort.Strings(related)
+	return related
+}
+
+// ToMap returns a map of the DomainNode's fields (weak serialization)
+func (d *DomainNode) ToMap() map[string]string {
+	relatedString := strings.Join(outputDomains(d.GetRelatedDomains()), " ")
 	m := make(map[string]string)
 	m["type"] = "domain"
-	m["id"] = d.Domain
+	m["id"] = OutputDomain(d.Domain)
 	m["status"] = d.Status.String()
 	m["root"] = strconv.FormatBool(d.Root)
 	m["depth"] = strconv.FormatUint(uint64(d.Depth), 10)
 	m["related"] = relatedString
+	m["parents"] = strings.Join(outputDomains(d.Parents), " ")
 	m["hasDNS"] = strconv.FormatBool(d.HasDNS)
+	if len(d.Status.TLS) > 0 {
+		m["tls"] = d.Status.TLS
+	}
+	if len(d.IPs) > 0 {
+		m["ips"] = strings.Join(d.IPs, " ")
+	}
+	if len(d.Ports) > 0 {
+		ports := make([]string, 0, len(d.Ports))
+		for port, portStatus := range d.Ports {
+			ports = append(ports, port+":"+portStatus.String())
+		}
+		sort.Strings(ports)
+		m["ports"] = strings.Join(ports, " ")
+	}
 	return m
 }
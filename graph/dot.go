@@ -0,0 +1,70 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dotShapes are the GraphViz shapes of each type of node
+var dotShapes = map[string]string{
+	"domain":       "ellipse",
+	"certificate":  "box",
+	"organization": "hexagon",
+	"issuer":       "diamond",
+}
+
+// GenerateDOT returns a GraphViz digraph of the certificate graph with the same nodes and links as GenerateMap
+// links are labeled with their type, which is the drivers that found the certificate for domain to certificate links
+// root domains are filled and domains include their depth as an attribute
+func (graph *CertGraph) GenerateDOT() string {
+	m := graph.GenerateMap()
+	nodes := m["nodes"].([]map[string]string)
+	links := m["links"].([]map[string]string)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i]["id"] < nodes[j]["id"]
+	})
+	sort.Slice(links, func(i, j int) bool {
+		if links[i]["source"] != links[j]["source"] {
+			return links[i]["source"] < links[j]["source"]
+		}
+		return links[i]["target"] < links[j]["target"]
+	})
+
+	var b strings.Builder
+	b.WriteString("digraph certgraph {\n")
+	for _, node := range nodes {
+		label := node["id"]
+		if node["type"] == "certificate" && len(label) > 16 {
+			label = label[:16]
+		}
+		fmt.Fprintf(&b, "  %s [shape=%s label=%s", dotQuote(node["id"]), dotShape(node["type"]), dotQuote(label))
+		if depth, ok := node["depth"]; ok {
+			fmt.Fprintf(&b, " depth=%s", depth)
+		}
+		if node["root"] == "true" {
+			b.WriteString(" style=filled fillcolor=lightblue")
+		}
+		b.WriteString("]\n")
+	}
+	for _, link := range links {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s]\n", dotQuote(link["source"]), dotQuote(link["target"]), dotQuote(link["type"]))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotShape(nodeType string) string {
+	if shape, ok := dotShapes[nodeType]; ok {
+		return shape
+	}
+	return "ellipse"
+}
+
+// dotQuote returns s as a quoted DOT ID
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -4,15 +4,19 @@ package graph
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/status"
 )
 
 // CertGraph main graph storage engine
 type CertGraph struct {
 	domains    sync.Map
 	certs      sync.Map
-	numDomains int
+	certsLock  sync.Mutex // serializes merging certificates
+	numDomains int64
+	numCerts   int64
 	depth      uint
 }
 
@@ -22,16 +26,62 @@ func NewCertGraph() *CertGraph {
 	return graph
 }
 
-// AddCert add a CertNode to the graph
-func (graph *CertGraph) AddCert(certNode *CertNode) {
-	// save the cert to the graph
-	// if it already exists we overwrite, it is simpler than checking first.
-	graph.certs.Store(certNode.Fingerprint, certNode)
+// AddCert add a CertNode to the graph and returns the CertNode stored in the graph
+// if the certificate is already in the graph a new CertNode merging the provided and existing CertNodes replaces it
+// stored CertNodes are never modified other than the drivers found, so they can be read while the graph grows
+func (graph *CertGraph) AddCert(certNode *CertNode) *CertNode {
+	certNode, _ = graph.AddCertLimit(certNode, 0)
+	return certNode
+}
+
+// AddCertLimit is like AddCert but does not add new certificates once the graph has max certificates, a max of 0 has no limit
+// returns false if the CertNode was not added, certificates already in the graph are always merged
+// the check and add are atomic so concurrent calls never exceed max
+func (graph *CertGraph) AddCertLimit(certNode *CertNode, max int) (*CertNode, bool) {
+	graph.certsLock.Lock()
+	defer graph.certsLock.Unlock()
+	node, loaded := graph.certs.Load(certNode.Fingerprint)
+	if !loaded {
+		if max > 0 && atomic.LoadInt64(&graph.numCerts) >= int64(max) {
+			return nil, false
+		}
+		graph.certs.Store(certNode.Fingerprint, certNode)
+		atomic.AddInt64(&graph.numCerts, 1)
+		return certNode, true
+	}
+	existing := node.(*CertNode)
+	if existing == certNode {
+		return existing, true
+	}
+	merged := existing.merged(certNode)
+	graph.certs.Store(merged.Fingerprint, merged)
+	return merged, true
 }
 
 // AddDomain add a DomainNode to the graph
 func (graph *CertGraph) AddDomain(domainNode *DomainNode) {
-	graph.numDomains++
+	atomic.AddInt64(&graph.numDomains, 1)
+	graph.storeDomain(domainNode)
+}
+
+// AddDomainLimit adds a DomainNode to the graph unless it already has max domains, a max of 0 has no limit
+// returns true if the DomainNode was added, the check and add are atomic so concurrent calls never exceed max
+func (graph *CertGraph) AddDomainLimit(domainNode *DomainNode, max int) bool {
+	for {
+		n := atomic.LoadInt64(&graph.numDomains)
+		if max > 0 && n >= int64(max) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&graph.numDomains, n, n+1) {
+			break
+		}
+	}
+	graph.storeDomain(domainNode)
+	return true
+}
+
+// storeDomain saves the DomainNode to the graph without counting it
+func (graph *CertGraph) storeDomain(domainNode *DomainNode) {
 	// save the new maximum depth if greather then current
 	if domainNode.Depth > graph.depth {
 		graph.depth = domainNode.Depth
@@ -44,7 +94,12 @@ func (graph *CertGraph) AddDomain(domainNode *DomainNode) {
 
 //NumDomains returns the number of domains in the graph
 func (graph *CertGraph) NumDomains() int {
-	return graph.numDomains
+	return int(atomic.LoadInt64(&graph.numDomains))
+}
+
+// NumCerts returns the number of certificates in the graph
+func (graph *CertGraph) NumCerts() int {
+	return int(atomic.LoadInt64(&graph.numCerts))
 }
 
 //DomainDepth returns the maximum depth of the graph from the initial root domains
@@ -70,10 +125,55 @@ func (graph *CertGraph) GetDomain(domain string) (*DomainNode, bool) {
 	return nil, false
 }
 
+// HasValidCert returns true if any of the domain's certificates in the graph have not expired
+func (graph *CertGraph) HasValidCert(domainNode *DomainNode) bool {
+	for _, fp := range domainNode.GetCertificates() {
+		certNode, ok := graph.GetCert(fp)
+		if ok && !certNode.Expired() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDomains returns all of the DomainNodes in the graph
+func (graph *CertGraph) GetDomains() []*DomainNode {
+	domains := make([]*DomainNode, 0, graph.NumDomains())
+	graph.domains.Range(func(key, value interface{}) bool {
+		domains = append(domains, value.(*DomainNode))
+		return true
+	})
+	return domains
+}
+
+// GetCerts returns all of the CertNodes in the graph
+func (graph *CertGraph) GetCerts() []*CertNode {
+	certs := make([]*CertNode, 0, graph.NumCerts())
+	graph.certs.Range(func(key, value interface{}) bool {
+		certs = append(certs, value.(*CertNode))
+		return true
+	})
+	return certs
+}
+
 // GetDomainNeighbors given a domain, return the list of all other domains that share a certificate with the provided domain that are in the graph
 // cdn will include CDN certs as well
 func (graph *CertGraph) GetDomainNeighbors(domain string, cdn bool, maxSANsSize int) []string {
-	neighbors := make(map[string]bool)
+	neighbors := graph.GetDomainNeighborSources(domain, cdn, maxSANsSize)
+
+	// convert map to array
+	neighborList := make([]string, 0, len(neighbors))
+	for key := range neighbors {
+		neighborList = append(neighborList, key)
+	}
+	return neighborList
+}
+
+// GetDomainNeighborSources is like GetDomainNeighbors but returns a map of each neighbor to the
+// drivers that found the certificates linking it to the provided domain
+// related domains are included with the drivers recorded with AddRelatedSources, or an empty list of drivers
+func (graph *CertGraph) GetDomainNeighborSources(domain string, cdn bool, maxSANsSize int) map[string][]string {
+	neighbors := make(map[string][]string)
 
 	domain = nonWildcard(domain)
 	node, ok := graph.domains.Load(domain)
@@ -81,7 +181,7 @@ func (graph *CertGraph) GetDomainNeighbors(domain string, cdn bool, maxSANsSize
 		domainNode := node.(*DomainNode)
 		// related cert neighbors
 		for relatedDomain := range domainNode.RelatedDomains {
-			neighbors[relatedDomain] = true
+			neighbors[relatedDomain] = domainNode.RelatedSources[relatedDomain]
 		}
 
 		// Cert neighbors
@@ -95,7 +195,7 @@ func (graph *CertGraph) GetDomainNeighbors(domain string, cdn bool, maxSANsSize
 					//v(domain, "-> Large CERT")
 				} else {
 					for _, neighbor := range certNode.Domains {
-						neighbors[neighbor] = true
+						neighbors[neighbor] = appendUniq(neighbors[neighbor], domainNode.Certs[fp]...)
 						//v(domain, "-- CT -->", neighbor)
 					}
 				}
@@ -104,52 +204,194 @@ func (graph *CertGraph) GetDomainNeighbors(domain string, cdn bool, maxSANsSize
 	}
 
 	//exclude domain from own neighbors list
-	neighbors[domain] = false
+	delete(neighbors, domain)
 
-	// convert map to array
-	neighborList := make([]string, 0, len(neighbors))
-	for key := range neighbors {
-		if neighbors[key] {
-			neighborList = append(neighborList, key)
+	return neighbors
+}
+
+// GetDomainLinks returns the links for the domain in the same form as GenerateMap
+// this includes links from the domain to its certificates, links from the domain's certificates
+// to other domains in the graph, and links to the domain from its BFS parents' certificates
+func (graph *CertGraph) GetDomainLinks(domain string) []map[string]string {
+	links := make([]map[string]string, 0)
+	domainNode, ok := graph.GetDomain(domain)
+	if !ok {
+		return links
+	}
+	links = append(links, graph.relatedLinks(domainNode)...)
+	for fingerprint, found := range domainNode.Certs {
+		links = append(links, map[string]string{"source": OutputDomain(domainNode.Domain), "target": fingerprint.HexString(), "type": strings.Join(found, " ")})
+		certNode, ok := graph.GetCert(fingerprint)
+		if !ok {
+			continue
+		}
+		for _, certDomain := range certNode.Domains {
+			certDomain = nonWildcard(certDomain)
+			if _, ok := graph.GetDomain(certDomain); ok {
+				links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": OutputDomain(certDomain), "type": "sans"})
+			}
 		}
 	}
-	return neighborList
+	for _, parent := range domainNode.Parents {
+		parentNode, ok := graph.GetDomain(parent)
+		if !ok {
+			continue
+		}
+		for _, fingerprint := range parentNode.GetCertificates() {
+			certNode, ok := graph.GetCert(fingerprint)
+			if !ok {
+				continue
+			}
+			for _, certDomain := range certNode.Domains {
+				if nonWildcard(certDomain) == domainNode.Domain {
+					links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": OutputDomain(domainNode.Domain), "type": "sans"})
+					break
+				}
+			}
+		}
+	}
+	return links
+}
+
+// relatedLinkTypes maps the status of related domains that are linked in the graph to the link type
+var relatedLinkTypes = map[status.DomainStatus]string{
+	status.SRV:  "srv",
+	status.MX:   "mx",
+	status.HINT: "hint",
+}
+
+// relatedLinkType returns the type of the link to a related domain with the status and the link's meta
+// redirects are linked whatever the status of their target with the type of redirect as the meta
+func relatedLinkType(s status.Status) (string, string, bool) {
+	if len(s.Redirect) > 0 {
+		return "redirect", s.Redirect, true
+	}
+	linkType, ok := relatedLinkTypes[s.Status]
+	return linkType, s.Meta, ok
+}
+
+// relatedLinks returns links from the domain to its related domains in the graph found by DNS lookups and redirects
+// redirect links include the type of redirect in "meta"
+func (graph *CertGraph) relatedLinks(domainNode *DomainNode) []map[string]string {
+	links := make([]map[string]string, 0)
+	for _, relatedDomain := range domainNode.GetRelatedDomains() {
+		linkType, meta, ok := relatedLinkType(domainNode.RelatedDomains[relatedDomain])
+		if !ok {
+			continue
+		}
+		if _, ok := graph.GetDomain(relatedDomain); ok {
+			link := map[string]string{"source": OutputDomain(domainNode.Domain), "target": OutputDomain(relatedDomain), "type": linkType}
+			if len(meta) > 0 {
+				link["meta"] = meta
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// linkKeyIDs adds links from certificates to their issuer by authority key id in GenerateMap
+var linkKeyIDs bool
+
+// SetLinkKeyIDs sets if GenerateMap links each certificate to its issuer by authority key id
+// the issuer is the certificate in the graph with a matching subject key id, or an issuer node for the key
+func SetLinkKeyIDs(link bool) {
+	linkKeyIDs = link
+}
+
+// subjectKeyIDs indexes the certificates by subject key id to link certificates to their issuer in the graph
+// it is empty unless SetLinkKeyIDs is set
+func (graph *CertGraph) subjectKeyIDs() map[string]string {
+	subjectKeyIDs := make(map[string]string)
+	if linkKeyIDs {
+		graph.certs.Range(func(key, value interface{}) bool {
+			certNode := value.(*CertNode)
+			if len(certNode.SubjectKeyID) > 0 {
+				subjectKeyIDs[certNode.SubjectKeyID] = certNode.Fingerprint.HexString()
+			}
+			return true
+		})
+	}
+	return subjectKeyIDs
+}
+
+// certLinks returns the links from the certificate to its issuer, organizations, and the domains in the graph on it
+// and the link to it from the certificate in the chain that issued it
+func (graph *CertGraph) certLinks(certNode *CertNode, subjectKeyIDs map[string]string) []map[string]string {
+	links := make([]map[string]string, 0, len(certNode.Domains))
+	if linkKeyIDs && len(certNode.AuthorityKeyID) > 0 {
+		issuer, found := subjectKeyIDs[certNode.AuthorityKeyID]
+		if !found {
+			issuer = certNode.AuthorityKeyID
+		}
+		if issuer != certNode.Fingerprint.HexString() {
+			links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": issuer, "type": "akid"})
+		}
+	}
+	if certNode.Issuer != (fingerprint.Fingerprint{}) {
+		if _, ok := graph.GetCert(certNode.Issuer); ok {
+			links = append(links, map[string]string{"source": certNode.Issuer.HexString(), "target": certNode.Fingerprint.HexString(), "type": "issuer"})
+		}
+	}
+	for _, org := range certNode.Organizations {
+		links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": org, "type": "organization"})
+	}
+	for _, domain := range certNode.Domains {
+		domain = nonWildcard(domain)
+		_, ok := graph.GetDomain(domain)
+		if ok {
+			links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": OutputDomain(domain), "type": "sans"})
+		}
+	}
+	return links
 }
 
 // GenerateMap returns a map representation of the certificate graph
 // used for JSON serialization
 func (graph *CertGraph) GenerateMap() map[string]interface{} {
 	m := make(map[string]interface{})
-	nodes := make([]map[string]string, 0, 2*graph.numDomains)
-	links := make([]map[string]string, 0, 2*graph.numDomains)
+	numDomains := graph.NumDomains()
+	nodes := make([]map[string]string, 0, 2*numDomains)
+	links := make([]map[string]string, 0, 2*numDomains)
 
 	// add all domain nodes
 	graph.domains.Range(func(key, value interface{}) bool {
 		domainNode := value.(*DomainNode)
 		nodes = append(nodes, domainNode.ToMap())
 		for fingerprint, found := range domainNode.Certs {
-			links = append(links, map[string]string{"source": domainNode.Domain, "target": fingerprint.HexString(), "type": strings.Join(found, " ")})
+			links = append(links, map[string]string{"source": OutputDomain(domainNode.Domain), "target": fingerprint.HexString(), "type": strings.Join(found, " ")})
 		}
+		links = append(links, graph.relatedLinks(domainNode)...)
 		return true
 	})
 
 	// add all cert nodes
+	subjectKeyIDs := graph.subjectKeyIDs()
+	orgs := make(map[string]bool)
+	issuers := make(map[string]bool)
 	graph.certs.Range(func(key, value interface{}) bool {
 		certNode := value.(*CertNode)
 		nodes = append(nodes, certNode.ToMap())
-		for _, domain := range certNode.Domains {
-			domain = nonWildcard(domain)
-			_, ok := graph.GetDomain(domain)
-			if ok {
-				links = append(links, map[string]string{"source": certNode.Fingerprint.HexString(), "target": domain, "type": "sans"})
+		if _, found := subjectKeyIDs[certNode.AuthorityKeyID]; linkKeyIDs && len(certNode.AuthorityKeyID) > 0 && !found {
+			// the issuing certificate is not in the graph, link to a node for its key instead
+			if !issuers[certNode.AuthorityKeyID] {
+				issuers[certNode.AuthorityKeyID] = true
+				nodes = append(nodes, map[string]string{"type": "issuer", "id": certNode.AuthorityKeyID})
+			}
+		}
+		for _, org := range certNode.Organizations {
+			if !orgs[org] {
+				orgs[org] = true
+				nodes = append(nodes, map[string]string{"type": "organization", "id": org})
 			}
 		}
+		links = append(links, graph.certLinks(certNode, subjectKeyIDs)...)
 		return true
 	})
 
 	m["nodes"] = nodes
 	m["links"] = links
 	m["depth"] = graph.depth
-	m["numDomains"] = graph.numDomains
+	m["numDomains"] = numDomains
 	return m
 }
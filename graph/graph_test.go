@@ -0,0 +1,541 @@
+package graph_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/fingerprint"
+	"github.com/lanrat/certgraph/graph"
+	"github.com/lanrat/certgraph/status"
+)
+
+func TestAddCertMerge(t *testing.T) {
+	g := graph.NewCertGraph()
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+
+	first := &graph.CertNode{Fingerprint: fp, Domains: []string{"example.com", "www.example.com"}}
+	first.AddFound("http")
+	second := &graph.CertNode{Fingerprint: fp, Domains: []string{"example.com", "mail.example.com"}}
+	second.AddFound("crtsh")
+
+	if stored := g.AddCert(first); stored != first {
+		t.Errorf("expected first cert to be stored")
+	}
+	domainsBefore := append([]string(nil), first.Domains...)
+	merged := g.AddCert(second)
+	if merged == first || merged == second {
+		t.Errorf("expected a new cert merging first and second")
+	}
+	if !reflect.DeepEqual(first.Domains, domainsBefore) {
+		t.Errorf("expected the stored cert to not be modified, got domains %v", first.Domains)
+	}
+	// drivers found after the merge are shared with the replaced cert
+	first.AddFound("censys")
+
+	certNode, found := g.GetCert(fp)
+	if !found {
+		t.Fatalf("cert not found in graph")
+	}
+
+	domains := certNode.Domains
+	sort.Strings(domains)
+	expectedDomains := []string{"example.com", "mail.example.com", "www.example.com"}
+	if !reflect.DeepEqual(domains, expectedDomains) {
+		t.Errorf("expected domains %v got %v", expectedDomains, domains)
+	}
+
+	drivers := certNode.Found()
+	sort.Strings(drivers)
+	expectedDrivers := []string{"censys", "crtsh", "http"}
+	if !reflect.DeepEqual(drivers, expectedDrivers) {
+		t.Errorf("expected found %v got %v", expectedDrivers, drivers)
+	}
+}
+
+func TestCertFreshness(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	tests := []struct {
+		notBefore time.Time
+		notAfter  time.Time
+		freshness string
+	}{
+		{time.Time{}, time.Time{}, ""},
+		{now.Add(-2 * day), now.Add(88 * day), "new"},
+		{now.Add(-90 * day), now.Add(275 * day), "recent"},
+		{now.Add(-400 * day), now.Add(400 * day), "old"},
+		{now.Add(-400 * day), now.Add(-day), "expired"},
+	}
+	for _, test := range tests {
+		certNode := &graph.CertNode{NotBefore: test.notBefore, NotAfter: test.notAfter}
+		if freshness := certNode.Freshness(); freshness != test.freshness {
+			t.Errorf("Freshness() for NotBefore %s = %q, expected %q", test.notBefore, freshness, test.freshness)
+		}
+	}
+}
+
+func TestGenerateGraphML(t *testing.T) {
+	g := graph.NewCertGraph()
+	g.AddDomain(graph.NewDomainNode(`a<b>&"c".example.com`, 0))
+	g.AddDomain(graph.NewDomainNode("example.com", 1))
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{`a<b>&"c".example.com`, "example.com"}})
+
+	var buf bytes.Buffer
+	err := g.GenerateGraphML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"graph>edge"`
+	}
+	err = xml.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("invalid GraphML: %s\n%s", err, buf.String())
+	}
+	if len(doc.Nodes) != 3 {
+		t.Errorf("expected 3 nodes, got %d", len(doc.Nodes))
+	}
+	found := false
+	for _, edge := range doc.Edges {
+		if edge.Source == fp.HexString() && edge.Target == `a<b>&"c".example.com` {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("escaped domain edge not found in GraphML:\n%s", buf.String())
+	}
+}
+
+func TestOutputDomainIDN(t *testing.T) {
+	defer graph.SetOutputIDN(graph.IDNStored)
+	tests := []struct {
+		form   string
+		domain string
+		output string
+	}{
+		{graph.IDNStored, "xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{graph.IDNUnicode, "*.xn--bcher-kva.example", "*.bücher.example"},
+		{graph.IDNASCII, "bücher.example", "xn--bcher-kva.example"},
+		{graph.IDNASCII, "example.com", "example.com"},
+	}
+	for _, test := range tests {
+		if err := graph.SetOutputIDN(test.form); err != nil {
+			t.Fatal(err)
+		}
+		if output := graph.OutputDomain(test.domain); output != test.output {
+			t.Errorf("OutputDomain(%q) with %q = %q, expected %q", test.domain, test.form, output, test.output)
+		}
+	}
+	if err := graph.SetOutputIDN("utf16"); err == nil {
+		t.Errorf("expected unknown IDN form to return an error")
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"müller.de":                "xn--mller-kva.de",
+		"MÜLLER.de":                "xn--mller-kva.de",
+		"xn--mller-kva.de":         "xn--mller-kva.de",
+		"*.bücher.example":         "*.xn--bcher-kva.example",
+		"münchen.xn--mller-kva.de": "xn--mnchen-3ya.xn--mller-kva.de",
+		"例え.テスト":                   "xn--r8jz45g.xn--zckzah",
+		"":                         "",
+		"www..example.com":         "www..example.com",
+		"xn--.example.com":         "xn--.example.com",
+		"xn--zz.example.com":       "xn--zz.example.com",
+	}
+	for domain, expected := range tests {
+		if normalized := graph.NormalizeDomain(domain); normalized != expected {
+			t.Errorf("NormalizeDomain(%q) = %q, expected %q", domain, normalized, expected)
+		}
+	}
+}
+
+func TestMixedIDNSANs(t *testing.T) {
+	g := graph.NewCertGraph()
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	// the same domain as an A-label and U-label, and a unicode wildcard
+	g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{"xn--mller-kva.de", "MÜLLER.de", "*.bücher.example"}})
+	domainNode := graph.NewDomainNode("Müller.de", 0)
+	domainNode.AddCertFingerprint(fp, "http")
+	g.AddDomain(domainNode)
+	g.AddDomain(graph.NewDomainNode("xn--bcher-kva.example", 1))
+
+	if domainNode.Domain != "xn--mller-kva.de" {
+		t.Errorf("expected domain to be stored as punycode, got %q", domainNode.Domain)
+	}
+	sans := make(map[string]bool)
+	for _, link := range g.GenerateMap()["links"].([]map[string]string) {
+		if link["type"] == "sans" {
+			sans[link["target"]] = true
+		}
+	}
+	expected := map[string]bool{"xn--mller-kva.de": true, "xn--bcher-kva.example": true}
+	if !reflect.DeepEqual(sans, expected) {
+		t.Errorf("expected sans links %v got %v", expected, sans)
+	}
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	g := graph.NewCertGraph()
+	addDomain := func(domain string, certDomains ...string) {
+		domainNode := graph.NewDomainNode(domain, 0)
+		if len(certDomains) > 0 {
+			fp := fingerprint.FromRawCertBytes([]byte(domain))
+			g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: certDomains})
+			domainNode.AddCertFingerprint(fp, "http")
+		}
+		g.AddDomain(domainNode)
+	}
+	// a and b present certs for each other, b also links to c which links nowhere
+	// d and e form a second cycle through a wildcard
+	addDomain("a.example.com", "a.example.com", "b.example.com")
+	addDomain("b.example.com", "b.example.com", "a.example.com", "c.example.com")
+	addDomain("c.example.com")
+	addDomain("d.example.org", "*.e.example.org")
+	addDomain("e.example.org", "d.example.org", "missing.example.org")
+
+	components := g.StronglyConnectedComponents(false, 0)
+	expected := [][]string{
+		{"a.example.com", "b.example.com"},
+		{"d.example.org", "e.example.org"},
+	}
+	if !reflect.DeepEqual(components, expected) {
+		t.Errorf("expected components %v got %v", expected, components)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	g := graph.NewCertGraph()
+	addDomain := func(domain string, certDomains ...string) {
+		domainNode := graph.NewDomainNode(domain, 0)
+		if len(certDomains) > 0 {
+			fp := fingerprint.FromRawCertBytes([]byte(domain))
+			g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: certDomains})
+			domainNode.AddCertFingerprint(fp, "http")
+		}
+		g.AddDomain(domainNode)
+	}
+	// a links to b through its cert, c only shares b's cert, d is alone
+	addDomain("a.example.com", "a.example.com", "b.example.com")
+	addDomain("b.example.com", "b.example.com")
+	addDomain("c.example.com", "*.example.com", "b.example.com")
+	addDomain("d.example.org")
+	// e redirects to f without sharing a certificate, g is only an unknown related domain of e
+	addDomain("e.example.net")
+	addDomain("f.example.net")
+	addDomain("g.example.net")
+	e, _ := g.GetDomain("e.example.net")
+	e.AddStatusMap(status.NewMap("f.example.net", status.Status{Status: status.GOOD, Redirect: "https same-site"}))
+	e.AddRelatedDomains([]string{"g.example.net"})
+
+	components := g.Components()
+	if len(components) != 4 {
+		t.Fatalf("expected 4 components, got %d", len(components))
+	}
+	expected := [][]string{
+		{"a.example.com", "b.example.com", "c.example.com"},
+		{"e.example.net", "f.example.net"},
+		{"d.example.org"},
+		{"g.example.net"},
+	}
+	for i, component := range components {
+		domains := make([]string, 0)
+		for _, domainNode := range component.GetDomains() {
+			domains = append(domains, domainNode.Domain)
+		}
+		sort.Strings(domains)
+		if !reflect.DeepEqual(domains, expected[i]) {
+			t.Errorf("expected component %d to be %v got %v", i, expected[i], domains)
+		}
+	}
+	if components[0].NumCerts() != 3 || components[2].NumCerts() != 0 {
+		t.Errorf("expected the certificates in the first component, got %d and %d", components[0].NumCerts(), components[2].NumCerts())
+	}
+}
+
+func TestWriteEdgeCSV(t *testing.T) {
+	g := graph.NewCertGraph()
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	certNode := &graph.CertNode{Fingerprint: fp, Domains: []string{"example.com", "www.example.com"}, Organizations: []string{`Example, "Inc"`}}
+	certNode.AddFound("http")
+	g.AddCert(certNode)
+	domainNode := graph.NewDomainNode("example.com", 0)
+	domainNode.AddCertFingerprint(fp, "http")
+	g.AddDomain(domainNode)
+	g.AddDomain(graph.NewDomainNode("www.example.com", 1))
+
+	var buf bytes.Buffer
+	if err := g.WriteEdgeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"source", "target", "type", "driver", "depth"},
+		{"example.com", fp.HexString(), "certificate", "http", "0"},
+		{fp.HexString(), `Example, "Inc"`, "organization", "http", ""},
+		{fp.HexString(), "example.com", "sans", "http", "0"},
+		{fp.HexString(), "www.example.com", "sans", "http", "1"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %v got %v", expected, rows)
+	}
+}
+
+func TestCertNodeStringMaxSANs(t *testing.T) {
+	defer graph.SetMaxSANsPrint(0)
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	certNode := &graph.CertNode{Fingerprint: fp, Domains: []string{"a.example.com", "b.example.com", "c.example.com"}}
+	graph.SetMaxSANsPrint(2)
+	if s := certNode.String(); !strings.HasSuffix(s, "[a.example.com b.example.com] (+1 more)") {
+		t.Errorf("expected truncated domains, got %q", s)
+	}
+	graph.SetMaxSANsPrint(3)
+	if s := certNode.String(); !strings.HasSuffix(s, "[a.example.com b.example.com c.example.com]") {
+		t.Errorf("expected all domains, got %q", s)
+	}
+}
+
+func TestCrawlResultMarshalJSON(t *testing.T) {
+	g := graph.NewCertGraph()
+	g.AddDomain(graph.NewDomainNode("example.com", 0))
+	result := &graph.CrawlResult{
+		Graph:    g,
+		Metadata: map[string]interface{}{"version": "test"},
+		Failures: map[string]string{"failed.example.com": "Timeout"},
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"nodes", "links", "depth", "numDomains", "certgraph", "failures"} {
+		if _, found := m[key]; !found {
+			t.Errorf("expected key %q in %s", key, data)
+		}
+	}
+	for _, key := range []string{"driver_errors", "stats"} {
+		if _, found := m[key]; found {
+			t.Errorf("expected unset key %q to be omitted from %s", key, data)
+		}
+	}
+}
+
+func TestGenerateDOT(t *testing.T) {
+	g := graph.NewCertGraph()
+	root := graph.NewDomainNode(`a"b\c.example.com`, 0)
+	root.Root = true
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	root.AddCertFingerprint(fp, "http")
+	g.AddDomain(root)
+	g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{`a"b\c.example.com`}})
+
+	dot := g.GenerateDOT()
+	for _, expected := range []string{
+		`"a\"b\\c.example.com" [shape=ellipse label="a\"b\\c.example.com" depth=0 style=filled fillcolor=lightblue]`,
+		fmt.Sprintf(`"%s" [shape=box label="%s"]`, fp.HexString(), fp.HexString()[:16]),
+		fmt.Sprintf(`"a\"b\\c.example.com" -> "%s" [label="http"]`, fp.HexString()),
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected %s in DOT output:\n%s", expected, dot)
+		}
+	}
+}
+
+func TestGenerateSTIX(t *testing.T) {
+	newGraph := func() *graph.CertGraph {
+		g := graph.NewCertGraph()
+		fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+		for _, domain := range []string{"example.com", "www.example.com"} {
+			node := graph.NewDomainNode(domain, 0)
+			node.AddCertFingerprint(fp, "http")
+			g.AddDomain(node)
+		}
+		g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{"example.com", "*.example.com", "www.example.com"}, NotBefore: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)})
+		return g
+	}
+
+	bundle := newGraph().GenerateSTIX()
+	if !reflect.DeepEqual(bundle, newGraph().GenerateSTIX()) {
+		t.Error("expected the same graph to generate the same bundle")
+	}
+	if !strings.HasPrefix(bundle["id"].(string), "bundle--") {
+		t.Errorf("unexpected bundle id %v", bundle["id"])
+	}
+	objects := bundle["objects"].([]map[string]interface{})
+	types := make(map[string]int)
+	for _, object := range objects {
+		types[object["type"].(string)]++
+		id := object["id"].(string)
+		// the UUID version is the first character of the third group
+		if groups := strings.Split(id, "-"); len(groups) < 4 || groups[len(groups)-3][0] != '5' {
+			t.Errorf("expected a UUIDv5 id, got %s", id)
+		}
+		if object["type"] != "relationship" {
+			continue
+		}
+		if object["relationship_type"] != "related-to" {
+			t.Errorf("unexpected relationship_type %v", object["relationship_type"])
+		}
+		if object["created"] != "2020-01-02T03:04:05.000Z" {
+			t.Errorf("expected the relationship to be created with the certificate, got %v", object["created"])
+		}
+	}
+	// the wildcard SAN relates the certificate to example.com again, which is not repeated
+	expected := map[string]int{"domain-name": 2, "x509-certificate": 1, "relationship": 2}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected objects %v, got %v", expected, types)
+	}
+}
+
+func TestMergeLinks(t *testing.T) {
+	links := []map[string]string{
+		{"source": "example.com", "target": "FP", "type": "crtsh"},
+		{"source": "FP", "target": "example.com", "type": "sans"},
+		{"source": "example.com", "target": "FP", "type": "http crtsh"},
+		{"source": "FP", "target": "example.com", "type": "sans"},
+	}
+	expected := []map[string]string{
+		{"source": "example.com", "target": "FP", "type": "crtsh http", "weight": "2"},
+		{"source": "FP", "target": "example.com", "type": "sans", "weight": "1"},
+	}
+	if merged := graph.MergeLinks(links); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected merged links %v got %v", expected, merged)
+	}
+}
+
+func TestGenerateMapLinkKeyIDs(t *testing.T) {
+	graph.SetLinkKeyIDs(true)
+	defer graph.SetLinkKeyIDs(false)
+
+	g := graph.NewCertGraph()
+	leaf := &graph.CertNode{Fingerprint: fingerprint.FromRawCertBytes([]byte("leaf")), AuthorityKeyID: "01"}
+	intermediate := &graph.CertNode{Fingerprint: fingerprint.FromRawCertBytes([]byte("intermediate")), SubjectKeyID: "01", AuthorityKeyID: "02"}
+	g.AddCert(leaf)
+	g.AddCert(intermediate)
+
+	m := g.GenerateMap()
+	links := m["links"].([]map[string]string)
+	for _, expected := range []map[string]string{
+		{"source": leaf.Fingerprint.HexString(), "target": intermediate.Fingerprint.HexString(), "type": "akid"},
+		{"source": intermediate.Fingerprint.HexString(), "target": "02", "type": "akid"},
+	} {
+		found := false
+		for _, link := range links {
+			if reflect.DeepEqual(link, expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected link %v in %v", expected, links)
+		}
+	}
+	issuers := 0
+	for _, node := range m["nodes"].([]map[string]string) {
+		if node["type"] == "issuer" {
+			issuers++
+			if node["id"] != "02" {
+				t.Errorf("expected issuer node 02, got %s", node["id"])
+			}
+		}
+	}
+	if issuers != 1 {
+		t.Errorf("expected 1 issuer node, got %d", issuers)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	fp := fingerprint.FromRawCertBytes([]byte("certificate"))
+	build := func(domains ...string) *graph.CertGraph {
+		g := graph.NewCertGraph()
+		for _, domain := range domains {
+			domainNode := graph.NewDomainNode(domain, 0)
+			domainNode.AddCertFingerprint(fp, "http")
+			g.AddDomain(domainNode)
+		}
+		g.AddCert(&graph.CertNode{Fingerprint: fp, Domains: []string{"a.example.com", "b.example.com"}})
+		return g
+	}
+
+	hash := build("a.example.com", "b.example.com").ContentHash()
+	if other := build("b.example.com", "a.example.com").ContentHash(); hash != other {
+		t.Errorf("expected the same hash for the same graph, got %s and %s", hash, other)
+	}
+	if other := build("a.example.com").ContentHash(); hash == other {
+		t.Errorf("expected a different hash for a different graph, got %s", hash)
+	}
+}
+
+func TestAddDomainLimit(t *testing.T) {
+	g := graph.NewCertGraph()
+	var wg sync.WaitGroup
+	var added int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if g.AddDomainLimit(graph.NewDomainNode(fmt.Sprintf("%d.example.com", i), 0), 10) {
+				atomic.AddInt64(&added, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if added != 10 || g.NumDomains() != 10 {
+		t.Errorf("expected 10 domains added, got %d with %d in the graph", added, g.NumDomains())
+	}
+	if !g.AddDomainLimit(graph.NewDomainNode("unlimited.example.com", 0), 0) {
+		t.Errorf("expected a limit of 0 to always add the domain")
+	}
+}
+
+func TestAddCertLimit(t *testing.T) {
+	g := graph.NewCertGraph()
+	var wg sync.WaitGroup
+	var added int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fp := fingerprint.FromRawCertBytes([]byte(fmt.Sprintf("cert %d", i)))
+			if _, ok := g.AddCertLimit(&graph.CertNode{Fingerprint: fp}, 10); ok {
+				atomic.AddInt64(&added, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if added != 10 || g.NumCerts() != 10 {
+		t.Errorf("expected 10 certificates added, got %d with %d in the graph", added, g.NumCerts())
+	}
+	existing := g.GetCerts()[0]
+	if _, ok := g.AddCertLimit(&graph.CertNode{Fingerprint: existing.Fingerprint, Domains: []string{"example.com"}}, 10); !ok {
+		t.Errorf("expected a certificate already in the graph to be merged at the limit")
+	}
+	if _, ok := g.AddCertLimit(&graph.CertNode{Fingerprint: fingerprint.FromRawCertBytes([]byte("unlimited"))}, 0); !ok {
+		t.Errorf("expected a limit of 0 to always add the certificate")
+	}
+}
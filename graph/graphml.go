@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// cSpell:ignore graphml graphdrawing
+
+// graphMLTypes are the GraphML types of the node and link attributes that are not strings
+var graphMLTypes = map[string]string{
+	"depth":    "int",
+	"age_days": "int",
+	"root":     "boolean",
+	"hasDNS":   "boolean",
+}
+
+// GenerateGraphML writes a GraphML representation of the certificate graph to w
+// with the same nodes, links, and attributes as GenerateMap
+func (graph *CertGraph) GenerateGraphML(w io.Writer) error {
+	m := graph.GenerateMap()
+	nodes := m["nodes"].([]map[string]string)
+	links := m["links"].([]map[string]string)
+	nodeKeys := graphMLKeys(nodes, "id")
+	linkKeys := graphMLKeys(links, "source", "target")
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">` + "\n")
+	for _, key := range nodeKeys {
+		fmt.Fprintf(bw, "  <key id=\"n_%s\" for=\"node\" attr.name=\"%s\" attr.type=\"%s\"/>\n", xmlEscape(key), xmlEscape(key), graphMLType(key))
+	}
+	for _, key := range linkKeys {
+		fmt.Fprintf(bw, "  <key id=\"e_%s\" for=\"edge\" attr.name=\"%s\" attr.type=\"%s\"/>\n", xmlEscape(key), xmlEscape(key), graphMLType(key))
+	}
+	bw.WriteString("  <graph id=\"certgraph\" edgedefault=\"directed\">\n")
+	for _, node := range nodes {
+		fmt.Fprintf(bw, "    <node id=\"%s\">\n", xmlEscape(node["id"]))
+		writeGraphMLData(bw, "n_", nodeKeys, node)
+		bw.WriteString("    </node>\n")
+	}
+	for i, link := range links {
+		fmt.Fprintf(bw, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">\n", i, xmlEscape(link["source"]), xmlEscape(link["target"]))
+		writeGraphMLData(bw, "e_", linkKeys, link)
+		bw.WriteString("    </edge>\n")
+	}
+	bw.WriteString("  </graph>\n</graphml>\n")
+	return bw.Flush()
+}
+
+// graphMLKeys returns the sorted attribute names used by any of the items, excluding skip
+func graphMLKeys(items []map[string]string, skip ...string) []string {
+	keySet := make(map[string]bool)
+	for _, item := range items {
+		for key := range item {
+			keySet[key] = true
+		}
+	}
+	for _, key := range skip {
+		delete(keySet, key)
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeGraphMLData(w io.Writer, prefix string, keys []string, item map[string]string) {
+	for _, key := range keys {
+		value, ok := item[key]
+		if !ok || (len(value) == 0 && graphMLType(key) != "string") {
+			continue
+		}
+		fmt.Fprintf(w, "      <data key=\"%s%s\">%s</data>\n", prefix, xmlEscape(key), xmlEscape(value))
+	}
+}
+
+func graphMLType(key string) string {
+	if t, ok := graphMLTypes[key]; ok {
+		return t
+	}
+	return "string"
+}
+
+// xmlEscape escapes s to be used as XML text or an attribute value
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
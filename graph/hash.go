@@ -0,0 +1,33 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// ContentHash returns a hex encoded SHA-256 hash of the graph's nodes and links
+// the hash only depends on the node types and ids and the link sources, targets, and types
+// so it is the same for every crawl which found the same graph, regardless of the order they were found in
+func (graph *CertGraph) ContentHash() string {
+	m := graph.GenerateMap()
+	nodes := m["nodes"].([]map[string]string)
+	links := m["links"].([]map[string]string)
+
+	lines := make([]string, 0, len(nodes)+len(links))
+	for _, node := range nodes {
+		lines = append(lines, strings.Join([]string{"node", node["type"], node["id"]}, "\t"))
+	}
+	for _, link := range links {
+		lines = append(lines, strings.Join([]string{"link", link["source"], link["target"], link["type"]}, "\t"))
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,82 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// forms of internationalized domain names that can be output
+const (
+	IDNStored  = ""        // output domains as they were found
+	IDNUnicode = "unicode" // output domains as unicode
+	IDNASCII   = "ascii"   // output domains as ASCII punycode
+)
+
+var outputIDN = IDNStored
+
+// SetOutputIDN sets the form of internationalized domain names output by the graph
+func SetOutputIDN(form string) error {
+	switch form {
+	case IDNStored, IDNUnicode, IDNASCII:
+		outputIDN = form
+		return nil
+	}
+	return fmt.Errorf("unknown IDN output form: %q, must be %q or %q", form, IDNUnicode, IDNASCII)
+}
+
+// OutputDomain returns the domain converted to the IDN form set with SetOutputIDN
+// domains that can not be converted are returned as is
+func OutputDomain(domain string) string {
+	var converted string
+	var err error
+	switch outputIDN {
+	case IDNUnicode:
+		converted, err = idna.Punycode.ToUnicode(domain)
+	case IDNASCII:
+		converted, err = idna.Punycode.ToASCII(domain)
+	default:
+		return domain
+	}
+	if err != nil {
+		return domain
+	}
+	return converted
+}
+
+// outputDomains returns the domains converted with OutputDomain
+func outputDomains(domains []string) []string {
+	converted := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		converted = append(converted, OutputDomain(domain))
+	}
+	return converted
+}
+
+// NormalizeDomain returns the domain lowercase with its unicode labels converted to ASCII punycode
+// so the A-label and U-label forms of a domain, ex: xn--mller-kva.de and müller.de, are the same node
+// labels that are already ASCII or can not be converted are only lowercased
+func NormalizeDomain(domain string) string {
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		converted, err := idna.Punycode.ToASCII(label)
+		if err == nil {
+			labels[i] = converted
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
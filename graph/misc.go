@@ -1,10 +1,54 @@
 package graph
 
 import (
+	"strconv"
 	"strings"
 )
 
-// given a domain returns the non-wildcard version of that domain
+// given a domain returns the normalized non-wildcard version of that domain
 func nonWildcard(domain string) string {
-	return strings.TrimPrefix(domain, "*.")
+	return strings.TrimPrefix(NormalizeDomain(domain), "*.")
+}
+
+// appendUniq appends the values to the slice that are not already in it
+func appendUniq(slice []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range slice {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, value)
+		}
+	}
+	return slice
+}
+
+// MergeLinks combines links with the same source and target into a single link
+// the merged link's type holds each unique type of the links and its weight is the number of unique types
+// other link attributes are taken from the first link
+func MergeLinks(links []map[string]string) []map[string]string {
+	merged := make([]map[string]string, 0, len(links))
+	types := make(map[[2]string][]string)
+	index := make(map[[2]string]int)
+	for _, link := range links {
+		key := [2]string{link["source"], link["target"]}
+		i, found := index[key]
+		if !found {
+			i = len(merged)
+			index[key] = i
+			m := make(map[string]string, len(link)+1)
+			for k, v := range link {
+				m[k] = v
+			}
+			merged = append(merged, m)
+		}
+		types[key] = appendUniq(types[key], strings.Fields(link["type"])...)
+		merged[i]["type"] = strings.Join(types[key], " ")
+		merged[i]["weight"] = strconv.Itoa(len(types[key]))
+	}
+	return merged
 }
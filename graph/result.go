@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"encoding/json"
+)
+
+// CrawlResult holds the graph of a crawl along with the crawl's metadata, errors, and stats
+type CrawlResult struct {
+	Graph        *CertGraph
+	Metadata     map[string]interface{} // crawl options and version information
+	DriverErrors map[string]int         // number of domains each driver failed to query
+	Stats        map[string]interface{} // query latency stats for each driver, if recorded
+	Failures     map[string]string      // domains that could not be queried and the category of their error
+}
+
+// Map returns the CrawlResult in the same form as GenerateMap with the metadata under "certgraph"
+// driver_errors, stats, and failures are only added when set
+func (r *CrawlResult) Map() map[string]interface{} {
+	m := r.Graph.GenerateMap()
+	m["certgraph"] = r.Metadata
+	if len(r.DriverErrors) > 0 {
+		m["driver_errors"] = r.DriverErrors
+	}
+	if len(r.Stats) > 0 {
+		m["stats"] = r.Stats
+	}
+	if len(r.Failures) > 0 {
+		m["failures"] = r.Failures
+	}
+	return m
+}
+
+// MarshalJSON returns the json encoding of Map
+func (r *CrawlResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Map())
+}
@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"sort"
+)
+
+// StronglyConnectedComponents returns the groups of domains in the graph that are mutually reachable
+// in the domain projection of the graph, where a domain links to every domain on its certificates
+// cdn and maxSANsSize filter the certificates used the same way as GetDomainNeighbors
+// components with a single domain are not returned, the largest components are returned first
+func (graph *CertGraph) StronglyConnectedComponents(cdn bool, maxSANsSize int) [][]string {
+	// build the domain projection, only linking to domains in the graph
+	domains := make([]string, 0)
+	for _, domainNode := range graph.GetDomains() {
+		domains = append(domains, domainNode.Domain)
+	}
+	sort.Strings(domains)
+	edges := make(map[string][]string, len(domains))
+	for _, domain := range domains {
+		for _, neighbor := range graph.GetDomainNeighbors(domain, cdn, maxSANsSize) {
+			neighbor = nonWildcard(neighbor)
+			if _, ok := graph.GetDomain(neighbor); ok && neighbor != domain {
+				edges[domain] = appendUniq(edges[domain], neighbor)
+			}
+		}
+		sort.Strings(edges[domain])
+	}
+
+	t := tarjan{
+		edges:   edges,
+		index:   make(map[string]int, len(domains)),
+		lowLink: make(map[string]int, len(domains)),
+		onStack: make(map[string]bool, len(domains)),
+	}
+	for _, domain := range domains {
+		if _, visited := t.index[domain]; !visited {
+			t.strongConnect(domain)
+		}
+	}
+
+	sort.Slice(t.components, func(i, j int) bool {
+		if len(t.components[i]) != len(t.components[j]) {
+			return len(t.components[i]) > len(t.components[j])
+		}
+		return t.components[i][0] < t.components[j][0]
+	})
+	return t.components
+}
+
+// tarjan holds the state of Tarjan's strongly connected components algorithm
+type tarjan struct {
+	edges      map[string][]string
+	index      map[string]int
+	lowLink    map[string]int
+	onStack    map[string]bool
+	stack      []string
+	next       int
+	components [][]string
+}
+
+// strongConnect visits domain and its descendants, adding any completed components
+func (t *tarjan) strongConnect(domain string) {
+	t.index[domain] = t.next
+	t.lowLink[domain] = t.next
+	t.next++
+	t.stack = append(t.stack, domain)
+	t.onStack[domain] = true
+
+	for _, neighbor := range t.edges[domain] {
+		if _, visited := t.index[neighbor]; !visited {
+			t.strongConnect(neighbor)
+			if t.lowLink[neighbor] < t.lowLink[domain] {
+				t.lowLink[domain] = t.lowLink[neighbor]
+			}
+		} else if t.onStack[neighbor] && t.index[neighbor] < t.lowLink[domain] {
+			t.lowLink[domain] = t.index[neighbor]
+		}
+	}
+
+	// domain is the root of a component, pop it off the stack
+	if t.lowLink[domain] == t.index[domain] {
+		var component []string
+		for {
+			member := t.stack[len(t.stack)-1]
+			t.stack = t.stack[:len(t.stack)-1]
+			t.onStack[member] = false
+			component = append(component, member)
+			if member == domain {
+				break
+			}
+		}
+		if len(component) > 1 {
+			sort.Strings(component)
+			t.components = append(t.components, component)
+		}
+	}
+}
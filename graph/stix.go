@@ -0,0 +1,123 @@
+package graph
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cSpell:ignore stix
+
+// stixNamespace is the UUIDv5 namespace defined by STIX 2.1 for deterministic SCO identifiers
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixTimeFormat is the STIX 2.1 timestamp format
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// GenerateSTIX returns a STIX 2.1 bundle representation of the certificate graph
+// domains are domain-name SCOs, certificates are x509-certificate SCOs
+// and each certificate SAN in the graph is a related-to relationship SRO between them
+// all identifiers are UUIDv5 generated from the nodes so the same graph always produces the same bundle
+func (graph *CertGraph) GenerateSTIX() map[string]interface{} {
+	objects := make([]map[string]interface{}, 0, 2*graph.NumDomains())
+	domainIDs := make(map[string]string)
+
+	// add all domain nodes
+	graph.domains.Range(func(key, value interface{}) bool {
+		domainNode := value.(*DomainNode)
+		id := stixSCOID("domain-name", map[string]interface{}{"value": OutputDomain(domainNode.Domain)})
+		domainIDs[domainNode.Domain] = id
+		objects = append(objects, map[string]interface{}{
+			"type":         "domain-name",
+			"spec_version": "2.1",
+			"id":           id,
+			"value":        OutputDomain(domainNode.Domain),
+		})
+		return true
+	})
+
+	// add all cert nodes and their SAN relationships
+	graph.certs.Range(func(key, value interface{}) bool {
+		certNode := value.(*CertNode)
+		hashes := map[string]string{"SHA-256": certNode.Fingerprint.HexString()}
+		certID := stixSCOID("x509-certificate", map[string]interface{}{"hashes": hashes})
+		objects = append(objects, map[string]interface{}{
+			"type":         "x509-certificate",
+			"spec_version": "2.1",
+			"id":           certID,
+			"hashes":       hashes,
+		})
+		// the relationship is as old as the certificate, or the epoch if its validity is unknown
+		created := time.Unix(0, 0).UTC().Format(stixTimeFormat)
+		if !certNode.NotBefore.IsZero() {
+			created = certNode.NotBefore.UTC().Format(stixTimeFormat)
+		}
+		relationships := make(map[string]bool)
+		for _, domain := range certNode.Domains {
+			domainID, ok := domainIDs[nonWildcard(domain)]
+			if !ok {
+				continue
+			}
+			id := "relationship--" + stixUUID(certID+" "+domainID)
+			if relationships[id] {
+				continue
+			}
+			relationships[id] = true
+			objects = append(objects, map[string]interface{}{
+				"type":              "relationship",
+				"spec_version":      "2.1",
+				"id":                id,
+				"created":           created,
+				"modified":          created,
+				"relationship_type": "related-to",
+				"source_ref":        certID,
+				"target_ref":        domainID,
+			})
+		}
+		return true
+	})
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i]["id"].(string) < objects[j]["id"].(string)
+	})
+	ids := make([]string, 0, len(objects))
+	for _, object := range objects {
+		ids = append(ids, object["id"].(string))
+	}
+
+	m := make(map[string]interface{})
+	m["type"] = "bundle"
+	m["id"] = "bundle--" + stixUUID(strings.Join(ids, " "))
+	m["objects"] = objects
+	return m
+}
+
+// stixSCOID returns the deterministic UUIDv5 identifier for a STIX cyber-observable
+// generated from its ID contributing properties
+func stixSCOID(objectType string, properties map[string]interface{}) string {
+	// json.Marshal sorts map keys, which is sufficient canonicalization for our simple properties
+	data, err := json.Marshal(properties)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%s--%s", objectType, stixUUID(string(data)))
+}
+
+// stixUUID returns the UUIDv5 of the name in the STIX namespace
+func stixUUID(name string) string {
+	h := sha1.New()
+	h.Write(stixNamespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50 // version 5
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,233 @@
+// Package msgpack implements a minimal MessagePack encoder
+//
+// Values are encoded with the same structure and field names as encoding/json would give them,
+// so the MessagePack output matches the JSON output and consumers can use either interchangeably.
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+package msgpack
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Encode returns the MessagePack encoding of v
+// integers are encoded as MessagePack integers, floats as 64 bit floats,
+// and values implementing encoding.TextMarshaler, such as time.Time, as strings
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encode(&buf, reflect.ValueOf(v))
+	return buf.Bytes(), err
+}
+
+// WriteFile writes the MessagePack encoding of v to file
+func WriteFile(file string, v interface{}) error {
+	data, err := Encode(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0666)
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// encode writes value to buf
+func encode(buf *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if value.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+	}
+	if value.Type().Implements(textMarshalerType) {
+		text, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		encodeString(buf, string(text))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return encode(buf, value.Elem())
+	case reflect.Bool:
+		if value.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encodeInt(buf, value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := value.Uint()
+		if u > math.MaxInt64 {
+			buf.WriteByte(0xcf)
+			binary.Write(buf, binary.BigEndian, u)
+			return nil
+		}
+		encodeInt(buf, int64(u))
+	case reflect.Float32, reflect.Float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(value.Float()))
+	case reflect.String:
+		encodeString(buf, value.String())
+	case reflect.Slice, reflect.Array:
+		encodeLength(buf, value.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+		for i := 0; i < value.Len(); i++ {
+			err := encode(buf, value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("msgpack: unsupported map key type %s", value.Type().Key())
+		}
+		// sort the keys so the same value is always encoded the same way
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		encodeLength(buf, len(keys), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			encodeString(buf, key.String())
+			err := encode(buf, value.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return encodeStruct(buf, value)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// encodeStruct writes the struct's exported fields as a map keyed by their json names
+// fields tagged "-" are skipped, as are empty fields tagged omitempty
+func encodeStruct(buf *bytes.Buffer, value reflect.Value) error {
+	names := make([]string, 0, value.NumField())
+	fields := make([]reflect.Value, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" && len(tag) == 1 {
+			continue
+		}
+		name := tag[0]
+		if len(name) == 0 {
+			name = field.Name
+		}
+		omitEmpty := false
+		for _, option := range tag[1:] {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if omitEmpty && isEmpty(value.Field(i)) {
+			continue
+		}
+		names = append(names, name)
+		fields = append(fields, value.Field(i))
+	}
+	encodeLength(buf, len(fields), 0x80, 16, 0, 0xde, 0xdf)
+	for i := range fields {
+		encodeString(buf, names[i])
+		err := encode(buf, fields[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEmpty returns true if omitempty would leave the value out of the json
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return value.IsNil()
+	}
+	return false
+}
+
+// encodeString writes s as a MessagePack string
+func encodeString(buf *bytes.Buffer, s string) {
+	encodeLength(buf, len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	buf.WriteString(s)
+}
+
+// encodeInt writes i using the smallest MessagePack integer format
+func encodeInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// encodeLength writes the header of a string, array, or map of length n
+// lengths under fixMax use the fix format, otherwise the 8 bit format if there is one, then the 16 and 32 bit formats
+func encodeLength(buf *bytes.Buffer, n int, fix byte, fixMax int, format8, format16, format32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case format8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(format8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(format16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(format32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
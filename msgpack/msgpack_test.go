@@ -0,0 +1,63 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/certgraph/msgpack"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{5, []byte{0x05}},
+		{-1, []byte{0xff}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]string{"a"}, []byte{0x91, 0xa1, 'a'}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{struct {
+			Depth uint `json:"depth"`
+		}{3}, []byte{0x81, 0xa5, 'd', 'e', 'p', 't', 'h', 0x03}},
+		{struct {
+			A       int `json:"a,omitempty"`
+			B       int `json:"-"`
+			C       int
+			private int
+		}{B: 1, C: 2}, []byte{0x81, 0xa1, 'C', 0x02}},
+		{[]string(nil), []byte{0xc0}},
+		{&[]uint8{1}, []byte{0x91, 0x01}},
+		{map[string]interface{}{"a": []map[string]string{{"b": "c"}}}, []byte{0x81, 0xa1, 'a', 0x91, 0x81, 0xa1, 'b', 0xa1, 'c'}},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), append([]byte{0xb4}, "2020-01-02T03:04:05Z"...)},
+		{time.Second, []byte{0xce, 0x3b, 0x9a, 0xca, 0x00}},
+	}
+	for _, test := range tests {
+		data, err := msgpack.Encode(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Errorf("Encode(%v) = %x, expected %x", test.value, data, test.expected)
+		}
+	}
+}
+
+func TestEncodeLongString(t *testing.T) {
+	s := strings.Repeat("a", 300)
+	data, err := msgpack.Encode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:3], []byte{0xda, 0x01, 0x2c}) || len(data) != 303 {
+		t.Errorf("expected str16 header, got %x", data[:3])
+	}
+}
@@ -0,0 +1,279 @@
+// Package parquet implements a minimal Apache Parquet file writer
+//
+// Files are written with a single row group of required, uncompressed, PLAIN encoded columns.
+// This is enough to load certgraph results into columnar analytics tools without adding a dependency.
+// https://github.com/apache/parquet-format
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// cSpell:ignore thrift zigzag varint
+
+const magic = "PAR1"
+
+// parquet physical types
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeByteArray = 6
+)
+
+// parquet enum values
+const (
+	convertedTypeUTF8  = 0
+	noConvertedType    = -1
+	repetitionRequired = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+	metaDataVersion    = 1
+)
+
+// thrift compact protocol types
+const (
+	compactTypeI32        = 5
+	compactTypeI64        = 6
+	compactTypeBinary     = 8
+	compactTypeList       = 9
+	compactTypeStruct     = 12
+	compactMaxFieldDelta  = 15
+	compactMaxSmallList   = 14
+	compactLongListHeader = 0xF0
+)
+
+const createdBy = "certgraph"
+
+// Column is a named column of values to write
+type Column struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	numValues     int
+	data          []byte // PLAIN encoded values
+}
+
+// StringColumn returns a UTF8 string column
+func StringColumn(name string, values []string) Column {
+	var buf bytes.Buffer
+	for _, value := range values {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(value)))
+		buf.WriteString(value)
+	}
+	return Column{name: name, physicalType: typeByteArray, convertedType: convertedTypeUTF8, numValues: len(values), data: buf.Bytes()}
+}
+
+// Int32Column returns a 32 bit integer column
+func Int32Column(name string, values []int32) Column {
+	var buf bytes.Buffer
+	for _, value := range values {
+		binary.Write(&buf, binary.LittleEndian, value)
+	}
+	return Column{name: name, physicalType: typeInt32, convertedType: noConvertedType, numValues: len(values), data: buf.Bytes()}
+}
+
+// BoolColumn returns a boolean column
+func BoolColumn(name string, values []bool) Column {
+	data := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	return Column{name: name, physicalType: typeBoolean, convertedType: noConvertedType, numValues: len(values), data: data}
+}
+
+// WriteFile writes the columns to a new parquet file at path
+// all columns must have the same number of values
+func WriteFile(path string, columns []Column) error {
+	data, err := Encode(columns)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+// Encode returns the parquet file encoding of the columns
+func Encode(columns []Column) ([]byte, error) {
+	numRows := 0
+	if len(columns) > 0 {
+		numRows = columns[0].numValues
+	}
+	for _, column := range columns {
+		if column.numValues != numRows {
+			return nil, fmt.Errorf("parquet column %q has %d values, expected %d", column.name, column.numValues, numRows)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	// column chunks, each a single data page
+	chunks := make([]*thrift, 0, len(columns))
+	var totalSize int64
+	for _, column := range columns {
+		offset := int64(file.Len())
+
+		page := new(thrift)
+		page.i32(1, pageTypeData)
+		page.i32(2, int32(len(column.data)))
+		page.i32(3, int32(len(column.data)))
+		page.structBegin(5)
+		page.i32(1, int32(column.numValues))
+		page.i32(2, encodingPlain)
+		page.i32(3, encodingRLE)
+		page.i32(4, encodingRLE)
+		page.structEnd()
+		page.stop()
+
+		file.Write(page.Bytes())
+		file.Write(column.data)
+		size := int64(file.Len()) - offset
+		totalSize += size
+
+		chunk := new(thrift)
+		chunk.i64(2, offset)
+		chunk.structBegin(3)
+		chunk.i32(1, column.physicalType)
+		chunk.i32List(2, []int32{encodingPlain, encodingRLE})
+		chunk.stringList(3, []string{column.name})
+		chunk.i32(4, codecUncompressed)
+		chunk.i64(5, int64(column.numValues))
+		chunk.i64(6, size)
+		chunk.i64(7, size)
+		chunk.i64(9, offset)
+		chunk.structEnd()
+		chunk.stop()
+		chunks = append(chunks, chunk)
+	}
+
+	// file metadata
+	meta := new(thrift)
+	meta.i32(1, metaDataVersion)
+	meta.listBegin(2, compactTypeStruct, len(columns)+1)
+	root := new(thrift)
+	root.binary(4, []byte("schema"))
+	root.i32(5, int32(len(columns)))
+	root.stop()
+	meta.Write(root.Bytes())
+	for _, column := range columns {
+		element := new(thrift)
+		element.i32(1, column.physicalType)
+		element.i32(3, repetitionRequired)
+		element.binary(4, []byte(column.name))
+		if column.convertedType != noConvertedType {
+			element.i32(6, column.convertedType)
+		}
+		element.stop()
+		meta.Write(element.Bytes())
+	}
+	meta.i64(3, int64(numRows))
+	meta.listBegin(4, compactTypeStruct, 1)
+	rowGroup := new(thrift)
+	rowGroup.listBegin(1, compactTypeStruct, len(chunks))
+	for _, chunk := range chunks {
+		rowGroup.Write(chunk.Bytes())
+	}
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(numRows))
+	rowGroup.stop()
+	meta.Write(rowGroup.Bytes())
+	meta.binary(6, []byte(createdBy))
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.Len()))
+	file.WriteString(magic)
+	return file.Bytes(), nil
+}
+
+// thrift is a minimal thrift compact protocol struct encoder
+type thrift struct {
+	bytes.Buffer
+	lastField []int16 // stack of the last field id written in each open struct
+	last      int16
+}
+
+func (t *thrift) fieldHeader(id int16, compactType byte) {
+	delta := id - t.last
+	if delta > 0 && delta <= compactMaxFieldDelta {
+		t.WriteByte(byte(delta)<<4 | compactType)
+	} else {
+		t.WriteByte(compactType)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thrift) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	t.Write(buf[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.fieldHeader(id, compactTypeI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.fieldHeader(id, compactTypeI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thrift) binary(id int16, v []byte) {
+	t.fieldHeader(id, compactTypeBinary)
+	t.varint(uint64(len(v)))
+	t.Write(v)
+}
+
+func (t *thrift) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, compactTypeList)
+	if size <= compactMaxSmallList {
+		t.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.WriteByte(compactLongListHeader | elemType)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thrift) i32List(id int16, values []int32) {
+	t.listBegin(id, compactTypeI32, len(values))
+	for _, v := range values {
+		t.varint(zigzag(int64(v)))
+	}
+}
+
+func (t *thrift) stringList(id int16, values []string) {
+	t.listBegin(id, compactTypeBinary, len(values))
+	for _, v := range values {
+		t.varint(uint64(len(v)))
+		t.WriteString(v)
+	}
+}
+
+func (t *thrift) structBegin(id int16) {
+	t.fieldHeader(id, compactTypeStruct)
+	t.lastField = append(t.lastField, t.last)
+	t.last = 0
+}
+
+func (t *thrift) structEnd() {
+	t.stop()
+	t.last = t.lastField[len(t.lastField)-1]
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+// stop ends the current struct
+func (t *thrift) stop() {
+	t.WriteByte(0)
+}
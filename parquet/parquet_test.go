@@ -0,0 +1,206 @@
+package parquet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/lanrat/certgraph/parquet"
+)
+
+// thriftStruct is a decoded thrift struct of field ids to values
+// i32 and i64 values are int64, binary values are strings, and lists are []interface{}
+type thriftStruct map[int16]interface{}
+
+// thriftReader is a minimal thrift compact protocol decoder to check the encoded metadata independently of the encoder
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = fmt.Errorf("unexpected end of thrift data at %d", r.pos)
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) varint() int64 {
+	u, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return int64(u>>1) ^ -int64(u&1) // zigzag
+}
+
+func (r *thriftReader) value(compactType byte) interface{} {
+	switch compactType {
+	case 5, 6: // i32, i64
+		return r.varint()
+	case 8: // binary
+		u, n := binary.Uvarint(r.data[r.pos:])
+		if n <= 0 || r.pos+n+int(u) > len(r.data) {
+			r.err = fmt.Errorf("invalid binary at %d", r.pos)
+			return nil
+		}
+		r.pos += n + int(u)
+		return string(r.data[r.pos-int(u) : r.pos])
+	case 9: // list
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			u, n := binary.Uvarint(r.data[r.pos:])
+			if n <= 0 {
+				r.err = fmt.Errorf("invalid list size at %d", r.pos)
+				return nil
+			}
+			r.pos += n
+			size = int(u)
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case 12: // struct
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unsupported thrift type %d at %d", compactType, r.pos)
+	return nil
+}
+
+func (r *thriftReader) readStruct() thriftStruct {
+	s := make(thriftStruct)
+	var last int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		s[id] = r.value(header & 0x0f)
+		last = id
+	}
+	return s
+}
+
+func TestEncode(t *testing.T) {
+	data, err := parquet.Encode([]parquet.Column{
+		parquet.StringColumn("domain", []string{"example.com", "www.example.com"}),
+		parquet.Int32Column("depth", []int32{0, 1}),
+		parquet.BoolColumn("root", []bool{true, false}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Errorf("missing parquet magic bytes")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Errorf("invalid footer length %d for file of length %d", footerLen, len(data))
+	}
+	if !bytes.Contains(data, []byte("www.example.com")) {
+		t.Errorf("missing column data")
+	}
+
+	// FileMetaData: 1 version, 2 schema, 3 num_rows, 4 row_groups, 6 created_by
+	footer := &thriftReader{data: data[len(data)-8-footerLen : len(data)-8]}
+	meta := footer.readStruct()
+	if footer.err != nil {
+		t.Fatal(footer.err)
+	}
+	if footer.pos != footerLen {
+		t.Errorf("decoded %d bytes of the %d byte footer", footer.pos, footerLen)
+	}
+	if meta[1] != int64(1) || meta[3] != int64(2) || meta[6] != "certgraph" {
+		t.Errorf("unexpected version %v, num_rows %v, or created_by %v", meta[1], meta[3], meta[6])
+	}
+	// SchemaElement: 1 type, 3 repetition_type, 4 name, 5 num_children, 6 converted_type
+	schema := []interface{}{
+		thriftStruct{4: "schema", 5: int64(3)},
+		thriftStruct{1: int64(6), 3: int64(0), 4: "domain", 6: int64(0)}, // BYTE_ARRAY UTF8
+		thriftStruct{1: int64(1), 3: int64(0), 4: "depth"},               // INT32
+		thriftStruct{1: int64(0), 3: int64(0), 4: "root"},                // BOOLEAN
+	}
+	if !reflect.DeepEqual(meta[2], schema) {
+		t.Errorf("expected schema %v, got %v", schema, meta[2])
+	}
+
+	// RowGroup: 1 columns, 2 total_byte_size, 3 num_rows
+	rowGroups, _ := meta[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("expected 1 row group, got %v", meta[4])
+	}
+	rowGroup := rowGroups[0].(thriftStruct)
+	chunks, _ := rowGroup[1].([]interface{})
+	if len(chunks) != 3 || rowGroup[3] != int64(2) {
+		t.Fatalf("expected 3 column chunks of 2 rows, got %v", rowGroup)
+	}
+	values := [][]byte{
+		append(append([]byte{11, 0, 0, 0}, "example.com"...), append([]byte{15, 0, 0, 0}, "www.example.com"...)...),
+		{0, 0, 0, 0, 1, 0, 0, 0},
+		{0x01},
+	}
+	offset := int64(len("PAR1"))
+	for i, chunk := range chunks {
+		// ColumnChunk: 2 file_offset, 3 meta_data
+		// ColumnMetaData: 1 type, 2 encodings, 3 path_in_schema, 4 codec, 5 num_values, 6 total_uncompressed_size, 7 total_compressed_size, 9 data_page_offset
+		columnMeta := chunk.(thriftStruct)[3].(thriftStruct)
+		name := schema[i+1].(thriftStruct)[4]
+		if chunk.(thriftStruct)[2] != offset || columnMeta[9] != offset {
+			t.Errorf("%s: expected the chunk at offset %d, got %v", name, offset, chunk)
+		}
+		if columnMeta[1] != schema[i+1].(thriftStruct)[1] || !reflect.DeepEqual(columnMeta[3], []interface{}{name}) || columnMeta[4] != int64(0) || columnMeta[5] != int64(2) {
+			t.Errorf("%s: unexpected column metadata %v", name, columnMeta)
+		}
+
+		// PageHeader: 1 type, 2 uncompressed_page_size, 3 compressed_page_size, 5 data_page_header
+		// DataPageHeader: 1 num_values, 2 encoding
+		page := &thriftReader{data: data[offset:]}
+		header := page.readStruct()
+		if page.err != nil {
+			t.Fatalf("%s: %s", name, page.err)
+		}
+		pageSize := int64(len(values[i]))
+		if header[1] != int64(0) || header[2] != pageSize || header[3] != pageSize || !reflect.DeepEqual(header[5], thriftStruct{1: int64(2), 2: int64(0), 3: int64(3), 4: int64(3)}) {
+			t.Errorf("%s: unexpected page header %v", name, header)
+		}
+		start := offset + int64(page.pos)
+		if !bytes.Equal(data[start:start+pageSize], values[i]) {
+			t.Errorf("%s: expected values %v, got %v", name, values[i], data[start:start+pageSize])
+		}
+		size := int64(page.pos) + pageSize
+		if columnMeta[6] != size || columnMeta[7] != size {
+			t.Errorf("%s: expected chunk size %d, got %v and %v", name, size, columnMeta[6], columnMeta[7])
+		}
+		offset += size
+	}
+	if rowGroup[2] != offset-int64(len("PAR1")) {
+		t.Errorf("expected total_byte_size %d, got %v", offset-int64(len("PAR1")), rowGroup[2])
+	}
+	if offset != int64(len(data)-8-footerLen) {
+		t.Errorf("expected the footer to follow the last chunk at %d, got %d", offset, len(data)-8-footerLen)
+	}
+}
+
+func TestEncodeMismatchedColumns(t *testing.T) {
+	_, err := parquet.Encode([]parquet.Column{
+		parquet.StringColumn("domain", []string{"example.com"}),
+		parquet.Int32Column("depth", []int32{0, 1}),
+	})
+	if err == nil {
+		t.Errorf("expected error for columns of different lengths")
+	}
+}
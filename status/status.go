@@ -2,6 +2,8 @@
 package status
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"syscall"
@@ -13,8 +15,10 @@ type DomainStatus int
 // Status holds the domain status and optionally more information
 // ex: redirects will have the redirected domain in Meta
 type Status struct {
-	Status DomainStatus
-	Meta   string
+	Status   DomainStatus
+	Meta     string
+	TLS      string // negotiated TLS version and cipher suite, empty if the driver did not connect to the domain
+	Redirect string // type of the redirect that led to the domain, ex: "https same-site", empty if no redirect did
 }
 
 // New returns a new Status object with the provided DomainStatus
@@ -38,6 +42,23 @@ func (s *Status) String() string {
 	return fmt.Sprintf("%s(%s)", s.Status.String(), s.Meta)
 }
 
+// tlsVersions names the TLS protocol versions
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+	tls.VersionTLS13: "TLS1.3",
+}
+
+// TLSString returns the negotiated TLS version and cipher suite of the connection, ex: "TLS1.3 TLS_AES_128_GCM_SHA256"
+func TLSString(state tls.ConnectionState) string {
+	version, ok := tlsVersions[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04X", state.Version)
+	}
+	return version + " " + tls.CipherSuiteName(state.CipherSuite)
+}
+
 // Map is a map of returned domains to their status
 type Map map[string]Status
 
@@ -46,6 +67,22 @@ func (m Map) Set(domain string, status Status) {
 	m[domain] = status
 }
 
+// SetTLS sets the TLS version and cipher suite of the domain's status if the domain is in the map
+func (m Map) SetTLS(domain string, tls string) {
+	if s, ok := m[domain]; ok {
+		s.TLS = tls
+		m[domain] = s
+	}
+}
+
+// SetRedirect sets the type of the redirect that led to the domain if the domain is in the map
+func (m Map) SetRedirect(domain string, redirect string) {
+	if s, ok := m[domain]; ok {
+		s.Redirect = redirect
+		m[domain] = s
+	}
+}
+
 // NewMap returns a new StatusMap containing the domain and status
 func NewMap(domain string, status Status) Map {
 	m := make(Map)
@@ -64,6 +101,9 @@ const (
 	REDIRECT = iota
 	CT       = iota
 	MULTI    = iota
+	SRV      = iota
+	MX       = iota
+	HINT     = iota
 )
 
 // String returns the domain status for printing
@@ -87,28 +127,36 @@ func (status DomainStatus) String() string {
 		return "CT"
 	case MULTI:
 		return "MULTI"
+	case SRV:
+		return "SRV"
+	case MX:
+		return "MX"
+	case HINT:
+		return "Hint"
 	}
 	return "?"
 }
 
 // CheckNetErr check for errors, print if network related
+// wrapped errors, such as those returned by net/http, are unwrapped
 func CheckNetErr(err error) DomainStatus {
 	if err == nil {
 		return GOOD
-	} else if netError, ok := err.(net.Error); ok && netError.Timeout() {
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
 		return TIMEOUT
-	} else {
-		switch t := err.(type) {
-		case *net.OpError:
-			if t.Op == "dial" {
-				return NOHOST
-			} else if t.Op == "read" {
-				return REFUSED
-			}
-		case syscall.Errno:
-			if t == syscall.ECONNREFUSED {
-				return REFUSED
-			}
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) && errno == syscall.ECONNREFUSED {
+		return REFUSED
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) {
+		if opError.Op == "dial" {
+			return NOHOST
+		} else if opError.Op == "read" {
+			return REFUSED
 		}
 	}
 	return ERROR
@@ -0,0 +1,55 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrScanRunning is returned by a scanner when a scan can't start because another is running
+var ErrScanRunning = errors.New("a scan is already running")
+
+// api holds the function backing /api/scan, it is not found until it is set
+var api struct {
+	sync.RWMutex
+	scan func(domain string) error
+}
+
+// SetScanner enables /api/scan, posting a domain to it calls scan which should start the scan in the background
+// scan should return ErrScanRunning if it can't start a new scan yet
+func SetScanner(scan func(domain string) error) {
+	api.Lock()
+	defer api.Unlock()
+	api.scan = scan
+}
+
+// serveScan starts a scan of the domain form value, responding 202 Accepted once it has started
+func serveScan(w http.ResponseWriter, r *http.Request) {
+	api.RLock()
+	scan := api.scan
+	api.RUnlock()
+	if scan == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	domain := r.FormValue("domain")
+	if len(domain) == 0 {
+		http.Error(w, "missing domain", http.StatusBadRequest)
+		return
+	}
+	err := scan(domain)
+	if errors.Is(err, ErrScanRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,159 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// graph holds the json graph served as /graph.json
+var graph graphCache
+
+// graphCache caches the parsed json graph and its compressed encoding so they are only computed once per graph
+type graphCache struct {
+	sync.RWMutex
+	raw     []byte
+	gzipped []byte
+	fields  map[string]json.RawMessage
+	nodes   []map[string]interface{}
+	links   []map[string]interface{}
+}
+
+// SetGraph replaces the json graph served as /graph.json, invalidating any cached renderings of the previous graph
+// setting a nil graph stops serving /graph.json
+func SetGraph(graphJSON []byte) error {
+	var fields map[string]json.RawMessage
+	var nodes, links []map[string]interface{}
+	var gzipped bytes.Buffer
+	if graphJSON != nil {
+		err := json.Unmarshal(graphJSON, &fields)
+		if err != nil {
+			return err
+		}
+		if nodesJSON, ok := fields["nodes"]; ok {
+			err = json.Unmarshal(nodesJSON, &nodes)
+			if err != nil {
+				return fmt.Errorf("invalid graph nodes: %w", err)
+			}
+		}
+		if linksJSON, ok := fields["links"]; ok {
+			err = json.Unmarshal(linksJSON, &links)
+			if err != nil {
+				return fmt.Errorf("invalid graph links: %w", err)
+			}
+		}
+		zw := gzip.NewWriter(&gzipped)
+		zw.Write(graphJSON)
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	graph.Lock()
+	defer graph.Unlock()
+	graph.raw = graphJSON
+	graph.gzipped = gzipped.Bytes()
+	graph.fields = fields
+	graph.nodes = nodes
+	graph.links = links
+	return nil
+}
+
+// serveGraph serves the json graph, compressed if the client accepts gzip
+// the offset and limit query parameters return a page of the graph's nodes with the links between them
+// and the total number of nodes as "totalNodes"
+func serveGraph(w http.ResponseWriter, r *http.Request) {
+	graph.RLock()
+	defer graph.RUnlock()
+	if graph.raw == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body := graph.raw
+	compressed := graph.gzipped
+	query := r.URL.Query()
+	if len(query.Get("offset")) > 0 || len(query.Get("limit")) > 0 {
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(query.Get("limit"), len(graph.nodes))
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		body, err = graph.page(offset, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		compressed = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	if compressed != nil {
+		w.Write(compressed)
+		return
+	}
+	zw := gzip.NewWriter(w)
+	zw.Write(body)
+	zw.Close()
+}
+
+// queryInt parses a non-negative integer query parameter, returning def if it is not set
+func queryInt(value string, def int) (int, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return i, nil
+}
+
+// page returns the json graph with only the nodes from offset to offset+limit and the links between them
+// must be called with the read lock held
+func (g *graphCache) page(offset, limit int) ([]byte, error) {
+	if offset > len(g.nodes) {
+		offset = len(g.nodes)
+	}
+	end := offset + limit
+	if end > len(g.nodes) || end < offset {
+		end = len(g.nodes)
+	}
+	nodes := g.nodes[offset:end]
+	ids := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		ids[fmt.Sprint(node["id"])] = true
+	}
+	links := make([]map[string]interface{}, 0)
+	for _, link := range g.links {
+		if ids[fmt.Sprint(link["source"])] && ids[fmt.Sprint(link["target"])] {
+			links = append(links, link)
+		}
+	}
+
+	page := make(map[string]interface{}, len(g.fields)+1)
+	for key, value := range g.fields {
+		page[key] = value
+	}
+	page["nodes"] = nodes
+	page["links"] = links
+	page["totalNodes"] = len(g.nodes)
+	return json.Marshal(page)
+}
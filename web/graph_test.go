@@ -0,0 +1,122 @@
+package web
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestServeGraph(t *testing.T) {
+	defer SetGraph(nil)
+	err := SetGraph([]byte(`{"depth":1,"nodes":[{"id":"a.example.com"},{"id":"FP"},{"id":"b.example.com"}],"links":[{"source":"a.example.com","target":"FP"},{"source":"FP","target":"b.example.com"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// full graph, compressed
+	req := httptest.NewRequest("GET", "/graph.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	serveGraph(w, req)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip response")
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(body) {
+		t.Errorf("invalid json graph: %s", body)
+	}
+
+	// first two nodes only include the link between them
+	w = httptest.NewRecorder()
+	serveGraph(w, httptest.NewRequest("GET", "/graph.json?limit=2", nil))
+	var page struct {
+		Depth      int
+		Nodes      []map[string]string
+		Links      []map[string]string
+		TotalNodes int
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Depth != 1 || len(page.Nodes) != 2 || len(page.Links) != 1 || page.TotalNodes != 3 {
+		t.Errorf("unexpected page: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	serveGraph(w, httptest.NewRequest("GET", "/graph.json?offset=-1", nil))
+	if w.Code != 400 {
+		t.Errorf("expected invalid offset to return 400, got %d", w.Code)
+	}
+}
+
+func TestServeAPI(t *testing.T) {
+	defer SetScanner(nil)
+
+	// not found until set
+	w := httptest.NewRecorder()
+	serveScan(w, httptest.NewRequest("POST", "/api/scan?domain=example.com", nil))
+	if w.Code != 404 {
+		t.Errorf("expected /api/scan to not be found, got %d", w.Code)
+	}
+
+	var scanned string
+	SetScanner(func(domain string) error {
+		if len(scanned) > 0 {
+			return ErrScanRunning
+		}
+		scanned = domain
+		return nil
+	})
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "/api/scan?domain=example.com", 405},
+		{"POST", "/api/scan", 400},
+		{"POST", "/api/scan?domain=example.com", 202},
+		{"POST", "/api/scan?domain=example.org", 409},
+	}
+	for _, test := range tests {
+		w = httptest.NewRecorder()
+		serveScan(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.code, w.Code)
+		}
+	}
+	if scanned != "example.com" {
+		t.Errorf("expected example.com to be scanned, got %q", scanned)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	atomic.StoreInt32(&ready, 0)
+
+	w := httptest.NewRecorder()
+	readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Errorf("expected not ready before the web UI is loaded, got %d", w.Code)
+	}
+	// the UI is loaded before listening, so an invalid address still marks it ready without a graph or crawl
+	err := Serve("127.0.0.1:-1", fstest.MapFS{"docs/index.html": {Data: []byte("certgraph")}}, nil)
+	if err == nil {
+		t.Fatal("expected an error listening on an invalid address")
+	}
+	w = httptest.NewRecorder()
+	readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 200 {
+		t.Errorf("expected ready once the web UI is loaded, got %d", w.Code)
+	}
+}
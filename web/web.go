@@ -5,22 +5,69 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"sync/atomic"
 )
 
+// ready is set to 1 once the web UI is loaded
+var ready int32
+
+// probePaths are the health check endpoints, they are not logged
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
 // Serve starts a very basic webserver serving the embed web UI
-func Serve(addr string, data fs.FS) error {
-	data, err := fs.Sub(data, "docs")
+// if graphJSON is not nil it is served as /graph.json which the web UI loads by default
+// the served graph can be replaced with SetGraph
+// the graph is also served as /api/graph so the snapshots of a running scan set with SetGraph can be polled
+// domains can be posted to /api/scan once SetScanner is called
+func Serve(addr string, data fs.FS, graphJSON []byte) error {
+	err := SetGraph(graphJSON)
 	if err != nil {
 		return err
 	}
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", readyz)
+	http.HandleFunc("/graph.json", serveGraph)
+	http.HandleFunc("/api/graph", serveGraph)
+	http.HandleFunc("/api/scan", serveScan)
+	data, err = fs.Sub(data, "docs")
+	if err != nil {
+		return err
+	}
+	SetStarted()
 	http.Handle("/", http.FileServer(http.FS(data)))
 	return http.ListenAndServe(addr, logRequest(http.DefaultServeMux))
 }
 
+// healthz is the liveness probe, it always succeeds while the server is running
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// SetStarted marks the server ready, Serve calls it once the web UI is loaded
+func SetStarted() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+// readyz is the readiness probe, it succeeds once the web UI is loaded
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
 // very minimal request logger
 func logRequest(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+		if !probePaths[r.URL.Path] {
+			log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+		}
 		handler.ServeHTTP(w, r)
 	})
 }